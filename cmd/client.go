@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/boot"
+	"github.com/marcellof23/vfs-TA/cmd/vfs/load"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/producer"
+	"github.com/marcellof23/vfs-TA/pkg/progress"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/publisher"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/subscriber"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+	"github.com/marcellof23/vfs-TA/pkg/user"
+)
+
+const (
+	envUsername = "VFS_USERNAME"
+	envPassword = "VFS_PASSWORD"
+)
+
+var (
+	username string
+	password string
+)
+
+var errNoCredentials = errors.New("no credentials: use --username and --password or set " + envUsername + " and " + envPassword)
+
+// client is a logged in VFS client with its Filesystem loaded.
+type client struct {
+	ctx     context.Context
+	sess    *session.Session
+	user    *user.User
+	vol     *fsys.Volume
+	logFile *os.File
+	format  fsys.Format // Output format of the commands.
+}
+
+// clientOptions tells newClient how to set up the client.
+type clientOptions struct {
+	interactive bool     // Prompt for the login when no credentials are given.
+	oneShot     bool     // Run a single operation: no replication listener nor health checks.
+	paths       []string // Paths whose metadata a one-shot operation needs, nil for everything.
+}
+
+// credentials returns the username and password from the flags, falling
+// back to the environment.
+func credentials() (string, string, bool) {
+	uname, pass := username, password
+	if uname == "" {
+		uname = os.Getenv(envUsername)
+	}
+	if pass == "" {
+		pass = os.Getenv(envPassword)
+	}
+	return uname, pass, uname != ""
+}
+
+// authenticate logs in with the credentials from the flags or the
+// environment, then with the cached token, and finally by prompting when
+// interactive is set. A new login is cached for the next commands.
+func authenticate(dep *boot.Dependencies, interactive bool) (*user.User, error) {
+	var currentUser *user.User
+	if uname, pass, ok := credentials(); ok {
+		var err error
+		currentUser, err = user.Login(dep, uname, pass)
+		if err != nil {
+			return nil, err
+		}
+	} else if cached, err := user.CachedUser(); err == nil {
+		return cached, nil
+	} else if interactive {
+		currentUser = user.InitUser(dep)
+	} else {
+		return nil, errNoCredentials
+	}
+
+	if err := user.SaveToken(currentUser); err != nil {
+		log.Println("WARNING: could not cache the token:", err)
+	}
+	return currentUser, nil
+}
+
+// newClient loads the config, logs in and loads the Filesystem.
+func newClient(opts clientOptions) (*client, error) {
+	logFile, err := os.OpenFile("server-log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := setupClient(logFile, opts)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadDependencies loads the config file and initializes the dependencies.
+func loadDependencies() (*boot.Dependencies, error) {
+	cfg, err := boot.LoadConfig(files)
+	if err != nil {
+		return nil, err
+	}
+	return boot.InitDependencies(cfg)
+}
+
+func setupClient(logFile *os.File, opts clientOptions) (*client, error) {
+	format, err := outputFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	dep, err := loadDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := authenticate(dep, opts.interactive)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New(logFile, time.Now().Format("2006-01-02 15:04:05")+": ", 0)
+	ctx := context.Background()
+
+	pubs, err := publisher.InitDefault(ctx, dep, logger)
+	if err != nil {
+		logger.Println("ERROR: ", err)
+		return nil, err
+	}
+
+	sess := session.New(dep, user.ToModelUserState(currentUser), pubs, producer.New(logger), logger)
+	sess.Progress = progress.ForFile(os.Stderr)
+	if format != fsys.FormatText {
+		sess.Progress = progress.New(os.Stderr, false)
+	}
+
+	if opts.oneShot {
+		err = load.LoadPaths(ctx, sess, opts.paths)
+	} else {
+		err = load.LoadFilesystem(ctx, sess)
+	}
+	if err != nil {
+		logger.Println("ERROR: ", err)
+		return nil, err
+	}
+
+	vol := fsys.NewVolume(fsys.DefaultOptions(dep.Config().MaxFileSize))
+	os.RemoveAll("backup")
+
+	if !opts.oneShot {
+		subs, err := subscriber.InitDefault(ctx, dep, logger)
+		if err != nil {
+			logger.Println("ERROR: ", err)
+			vol.Close()
+			return nil, err
+		}
+
+		go subs.ListenMessage(ctx, sess, vol)
+		go producer.IntermediateHealthCheck(ctx, dep)
+		go producer.KafkaHealthCheck(ctx)
+	}
+
+	return &client{
+		ctx:     ctx,
+		sess:    sess,
+		user:    currentUser,
+		vol:     vol,
+		logFile: logFile,
+		format:  format,
+	}, nil
+}
+
+// newShell creates a shell on the root of the volume writing in the output
+// format of the client.
+func (c *client) newShell() *fsys.Shell {
+	shells := fsys.InitShell(c.vol.Root())
+	shells.Format = c.format
+	return shells
+}
+
+// Wait blocks until the replicated operations are acknowledged.
+func (c *client) Wait() error {
+	return c.sess.Wait()
+}
+
+// Close stops the volume and closes the log file.
+func (c *client) Close() {
+	c.vol.Close()
+	c.logFile.Close()
+}
+
+// addCredentialFlags adds the flags giving the credentials to cmd.
+func addCredentialFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&username, "username", "", "Username, defaults to $"+envUsername)
+	cmd.PersistentFlags().StringVar(&password, "password", "", "Password, defaults to $"+envPassword)
+}
@@ -1,139 +1,148 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/marcellof23/vfs-TA/boot"
 	"github.com/marcellof23/vfs-TA/cmd/vfs/load"
-	"github.com/marcellof23/vfs-TA/global"
-	"github.com/marcellof23/vfs-TA/pkg/memory"
-	"github.com/marcellof23/vfs-TA/pkg/model"
-	"github.com/marcellof23/vfs-TA/pkg/producer"
-	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/publisher"
-	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/subscriber"
-
+	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/fsys"
-	"github.com/marcellof23/vfs-TA/pkg/user"
+	"github.com/marcellof23/vfs-TA/pkg/model"
 )
 
-func shellLoop(ctx context.Context, currentUser *user.User) {
-	var shellFlag bool
+// rcFile is the file in the home directory run at the start of the shell.
+const rcFile = ".vfsrc"
+
+func shellLoop(c *client) {
+	hist, err := c.user.LoadHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "history:", err.Error())
+	}
+
+	shells := c.newShell()
+	shells.Stdin = os.Stdin
+	shells.History = hist
+	prompt := c.user.InitPrompt(hist, fsys.NewCompleter(shells, c.sess.Clients()))
+
+	publishing := model.Publishing{
+		PublishSync:         true,
+		PublishIntermediate: true,
+	}
 
-	maxFileSize, _ := fsys.GetMaxFileSzFromContext(ctx)
-	global.Filesys = fsys.New(maxFileSize)
-	prompt := currentUser.InitPrompt()
-	shells := fsys.InitShell(global.Filesys)
-	os.RemoveAll("backup")
+	// The rc file of the user runs once logged in, before the first prompt.
+	if home, err := os.UserHomeDir(); err == nil {
+		rc := filepath.Join(home, rcFile)
+		if _, err := os.Stat(rc); err == nil {
+			err := c.vol.Do(func() error {
+				return shells.Source(c.ctx, c.sess, publishing, constant.HostPrefix+rc)
+			})
+			exitOn(c, shells, err)
+		}
+	}
+	c.vol.Do(func() error {
+		c.user.SetPrompt(prompt, shells)
+		return nil
+	})
 
 	for {
+		shells.NotifyJobs(os.Stderr)
 		input, _ := prompt.Readline()
+		if hist != nil {
+			expanded, changed, err := hist.Expand(input)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				continue
+			}
+			if changed {
+				fmt.Println(expanded)
+				input = expanded
+			}
+
+			line, added, err := hist.Add(input)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "history:", err.Error())
+			}
+			if added {
+				prompt.SaveHistory(line)
+			}
+		}
+
 		input = strings.TrimSpace(input)
 		if len(input) == 0 {
 			continue
 		}
 
-		commands := strings.Split(input, " ")
-
-		// Execute the command for shell
-		shellFlag = shells.Execute(ctx, commands)
-		currentUser.SetPrompt(prompt, shells.Fs)
-
-		memory.PrintMemUsage()
-		if commands[0] == "reload" {
-			load.ReloadFilesys(ctx)
-			global.Filesys = fsys.New(maxFileSize)
+		if input == "reload" {
+			load.ReloadFilesys(c.ctx, c.sess)
+			c.vol.Reload()
+			// The working directory is kept when it still exists.
+			c.vol.Do(func() error {
+				shells.SetFilesystem(c.vol.Root())
+				return nil
+			})
 			os.RemoveAll("output")
 		} else {
-			global.Filesys = shells.Fs
-			if shellFlag {
-				continue
-			}
-
-			publishing := model.Publishing{
-				PublishSync:         true,
-				PublishIntermediate: true,
-			}
-			_, err := global.Filesys.Execute(ctx, commands, publishing)
-			if err != nil {
-				fmt.Println(err.Error())
-			}
+			err := shells.RunLine(c.ctx, c.sess, input, publishing)
+			exitOn(c, shells, err)
 		}
 
-		currentUser.SetPrompt(prompt, global.Filesys)
-		shells.SetFilesystem(global.Filesys)
+		c.vol.Do(func() error {
+			c.user.SetPrompt(prompt, shells)
+			return nil
+		})
 	}
 }
 
+// exitOn exits with the status given to exit when err comes from it, once
+// the jobs of shells and the replicated operations are done.
+func exitOn(c *client, shells *fsys.Shell, err error) {
+	var exit *fsys.ExitError
+	if !errors.As(err, &exit) {
+		return
+	}
+
+	status := exit.Status
+	if err := shells.WaitJobs(nil); err != nil && status == 0 {
+		status = 1
+	}
+	if err := c.Wait(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		if status == 0 {
+			status = 1
+		}
+	}
+	c.Close()
+	os.Exit(status)
+}
+
 func init() {
+	var command string
+
 	var apiCmd = &cobra.Command{
 		Use:   "shell",
 		Short: "Runs the main Shell Loop for the MemFilesystem",
 		Run: func(cmd *cobra.Command, args []string) {
-			LogFile, err := os.OpenFile("server-log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-			if err != nil {
-				log.Fatalf("error opening file: %v", err)
-				return
-			}
-			defer LogFile.Close()
-
-			configfile := files
-			cfg, err := boot.LoadConfig(configfile)
-			if err != nil {
-				log.Fatal(err)
+			if command != "" {
+				runAndExit(strings.NewReader(command))
 				return
 			}
 
-			dep, err := boot.InitDependencies(cfg)
+			c, err := newClient(clientOptions{interactive: true})
 			if err != nil {
 				log.Fatal(err)
 				return
 			}
-
-			logger := log.New(LogFile, time.Now().Format("2006-01-02 15:04:05")+": ", 0)
-			ctx := context.WithValue(context.Background(), "server-logger", logger)
-
-			pubs, err := publisher.InitDefault(ctx, dep)
-			if err != nil {
-				logger.Println("ERROR: ", err)
-				return
-			}
-			subs, err := subscriber.InitDefault(ctx, dep)
-			if err != nil {
-				logger.Println("ERROR: ", err)
-				return
-			}
-
-			currentUser := user.InitUser(dep)
-			clientID := currentUser.ClientID
-			ctx = context.WithValue(ctx, "role", currentUser.Role)
-			ctx = context.WithValue(ctx, "token", currentUser.Token)
-			ctx = context.WithValue(ctx, "host", dep.Config().Server.Addr)
-			ctx = context.WithValue(ctx, "clients", dep.Config().Clients)
-			ctx = context.WithValue(ctx, "maxFileSize", dep.Config().MaxFileSize)
-			ctx = context.WithValue(ctx, "dependency", dep)
-			ctx = context.WithValue(ctx, "userState", user.ToModelUserState(currentUser))
-			ctx = context.WithValue(ctx, "publisher", pubs)
-			ctx = context.WithValue(ctx, "clientID", clientID)
-
-			err = load.LoadFilesystem(ctx, dep, currentUser.Token)
-			if err != nil {
-				logger.Println("ERROR: ", err)
-				return
-			}
-
-			go subs.ListenMessage(ctx)
-			go producer.IntermediateHealthCheck(ctx, dep)
-			go producer.KafkaHealthCheck(ctx)
-			shellLoop(ctx, currentUser)
+			defer c.Close()
+			shellLoop(c)
 		},
 	}
+	apiCmd.Flags().StringVarP(&command, "command", "c", "", "Run the commands separated by ';' and exit")
 
 	rootCmd.AddCommand(apiCmd)
 }
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/user"
+)
+
+// oneShot runs the given command lines on a client loading only the
+// metadata of paths, waits for the replicated operations to be
+// acknowledged and exits with status 1 on failure.
+func oneShot(paths []string, commands ...[]string) {
+	for i, p := range paths {
+		paths[i] = strings.TrimPrefix(p, constant.VFSPrefix)
+	}
+
+	c, err := newClient(clientOptions{oneShot: true, paths: paths})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	publishing := model.Publishing{
+		PublishSync:         true,
+		PublishIntermediate: true,
+	}
+
+	shells := c.newShell()
+	for _, args := range commands {
+		err = c.vol.Do(func() error {
+			return shells.Run(c.ctx, c.sess, []fsys.Stage{{Args: args}}, publishing)
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	if werr := c.Wait(); werr != nil {
+		fmt.Fprintln(os.Stderr, werr.Error())
+		if err == nil {
+			err = werr
+		}
+	}
+	c.Close()
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// withFlag appends name to args when set.
+func withFlag(args []string, set bool, name string) []string {
+	if set {
+		return append(args, name)
+	}
+	return args
+}
+
+func init() {
+	var long bool
+	var lsCmd = &cobra.Command{
+		Use:   "ls [path]",
+		Short: "Lists a directory of the virtual filesystem",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+			oneShot([]string{path}, []string{"cd", path}, withFlag([]string{"ls"}, long, "-l"))
+		},
+	}
+	lsCmd.Flags().BoolVarP(&long, "long", "l", false, "Print the mode, owner, size and modification time")
+
+	var statCmd = &cobra.Command{
+		Use:   "stat path",
+		Short: "Prints the status of a file or directory",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			oneShot([]string{args[0]}, []string{"stat", args[0]})
+		},
+	}
+
+	var rmRecursive bool
+	var rmCmd = &cobra.Command{
+		Use:   "rm path",
+		Short: "Removes a file, or a directory with --recursive",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			oneShot([]string{args[0]}, append(withFlag([]string{"rm"}, rmRecursive, "-r"), args[0]))
+		},
+	}
+	rmCmd.Flags().BoolVarP(&rmRecursive, "recursive", "r", false, "Remove directories and their contents recursively")
+
+	var uploadRecursive bool
+	var uploadCmd = &cobra.Command{
+		Use:   "upload local-path vfs-path",
+		Short: "Uploads a file, or a directory with --recursive, from the host",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			oneShot([]string{args[1]}, append(withFlag([]string{"upload"}, uploadRecursive, "-r"), args...))
+		},
+	}
+	uploadCmd.Flags().BoolVarP(&uploadRecursive, "recursive", "r", false, "Upload directories and their contents recursively")
+
+	var downloadRecursive bool
+	var downloadCmd = &cobra.Command{
+		Use:   "download vfs-path local-path",
+		Short: "Downloads a file, or a directory with --recursive, to the host",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			oneShot([]string{args[0]}, append(withFlag([]string{"download"}, downloadRecursive, "-r"), args...))
+		},
+	}
+	downloadCmd.Flags().BoolVarP(&downloadRecursive, "recursive", "r", false, "Download directories and their contents recursively")
+
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate source-provider destination-provider",
+		Short: "Moves the stored files to another cloud provider",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			// A migration moves every file, so it needs all the metadata.
+			oneShot([]string{"/"}, append([]string{"migrate"}, args...))
+		},
+	}
+
+	var loginCmd = &cobra.Command{
+		Use:   "login",
+		Short: "Logs in and caches the token for the next commands",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := user.RemoveToken()
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
+
+			dep, err := loadDependencies()
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
+
+			currentUser, err := authenticate(dep, true)
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
+			fmt.Println("Logged in as", currentUser.Username)
+		},
+	}
+
+	var logoutCmd = &cobra.Command{
+		Use:   "logout",
+		Short: "Removes the cached token",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := user.RemoveToken()
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	rootCmd.AddCommand(lsCmd, statCmd, rmCmd, uploadCmd, downloadCmd, migrateCmd, loginCmd, logoutCmd)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+)
+
+var (
+	jsonOutput bool
+	outputName string
+)
+
+// addOutputFlags adds the flags choosing the output format to cmd.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Write the results as JSON, same as --output json")
+	cmd.PersistentFlags().StringVar(&outputName, "output", "text", "Output format: text, json or ndjson")
+}
+
+// outputFormat returns the output format chosen by the flags.
+func outputFormat() (fsys.Format, error) {
+	if jsonOutput {
+		return fsys.FormatJSON, nil
+	}
+	return fsys.ParseFormat(outputName)
+}
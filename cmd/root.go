@@ -15,7 +15,9 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() error {
-	rootCmd.PersistentFlags().StringVarP(&files, "config", "c", "config.yaml", "Config file")
+	rootCmd.PersistentFlags().StringVar(&files, "config", "config.yaml", "Config file")
+	addCredentialFlags(rootCmd)
+	addOutputFlags(rootCmd)
 
 	// sub commands are added in respective files
 	return rootCmd.Execute()
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+)
+
+// runAndExit runs the command lines read from r as a script, waits for the
+// replicated operations to be acknowledged and exits with status 1 on
+// failure, or with the status given to exit.
+func runAndExit(r io.Reader) {
+	c, err := newClient(clientOptions{oneShot: true})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	publishing := model.Publishing{
+		PublishSync:         true,
+		PublishIntermediate: true,
+	}
+
+	shells := c.newShell()
+	// edit asks its questions on stdin, unless the script is read from it.
+	if r != os.Stdin {
+		shells.Stdin = os.Stdin
+	}
+	err = shells.RunScript(c.ctx, c.sess, r, publishing)
+
+	status := 0
+	var exit *fsys.ExitError
+	if errors.As(err, &exit) {
+		status = exit.Status
+	} else if err != nil {
+		status = 1
+	}
+	if werr := c.Wait(); werr != nil {
+		fmt.Fprintln(os.Stderr, werr.Error())
+		if status == 0 {
+			status = 1
+		}
+	}
+	c.Close()
+
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+func init() {
+	var runCmd = &cobra.Command{
+		Use:   "run [script.vfs|-]",
+		Short: "Runs the commands of a script, or of stdin, without prompting",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var r io.Reader = os.Stdin
+			if len(args) > 0 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					log.Fatal(err)
+					return
+				}
+				defer f.Close()
+				r = f
+			}
+
+			runAndExit(r)
+		},
+	}
+
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/server"
+)
+
+// shutdownTimeout is how long the requests being served are waited for
+// once the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// serveAndExit serves the Filesystem of a client on addr with the handler
+// returned by handler until interrupted, waits for the replicated
+// operations to be acknowledged and exits with status 1 on failure. The
+// whole Filesystem is loaded and kept up to date with the other clients.
+func serveAndExit(addr string, handler func(*server.Server) http.Handler) {
+	c, err := newClient(clientOptions{})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	publishing := model.Publishing{
+		PublishSync:         true,
+		PublishIntermediate: true,
+	}
+
+	ctx, stop := signal.NotifyContext(c.ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:     addr,
+		Handler:  handler(server.New(c.ctx, c.sess, c.vol, publishing)),
+		ErrorLog: c.sess.Logger,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	fmt.Fprintln(os.Stderr, "Serving on", addr)
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(c.ctx, shutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+
+	if werr := c.Wait(); werr != nil {
+		fmt.Fprintln(os.Stderr, werr.Error())
+		if err == nil {
+			err = werr
+		}
+	}
+	c.Close()
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	var addr string
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serves the virtual filesystem to the users of the intermediate service",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	serveCmd.PersistentFlags().StringVar(&addr, "addr", "localhost:8080", "Address to listen on")
+
+	var webdavCmd = &cobra.Command{
+		Use:   "webdav",
+		Short: "Serves the virtual filesystem over WebDAV, with basic authentication",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serveAndExit(addr, (*server.Server).WebDAV)
+		},
+	}
+
+	var apiCmd = &cobra.Command{
+		Use:   "api",
+		Short: "Serves the virtual filesystem as a JSON API, with token or basic authentication",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serveAndExit(addr, (*server.Server).API)
+		},
+	}
+
+	serveCmd.AddCommand(webdavCmd, apiCmd)
+	rootCmd.AddCommand(serveCmd)
+}
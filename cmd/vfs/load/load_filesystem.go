@@ -8,7 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,13 +15,18 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/marcellof23/vfs-TA/boot"
 	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/fsys"
-	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 func Untar(tarball, target string) error {
+	return UntarFunc(tarball, target, nil)
+}
+
+// UntarFunc extracts the entries of tarball for which keep returns true, or
+// every entry when keep is nil.
+func UntarFunc(tarball, target string, keep func(name string) bool) error {
 	reader, err := os.Open(tarball)
 	if err != nil {
 		return err
@@ -38,6 +42,10 @@ func Untar(tarball, target string) error {
 			return err
 		}
 
+		if keep != nil && !keep(header.Name) {
+			continue
+		}
+
 		path := filepath.Join(target, header.Name)
 		info := header.FileInfo()
 		if info.IsDir() {
@@ -83,26 +91,67 @@ func Untar(tarball, target string) error {
 	return nil
 }
 
-func LoadFilesystem(ctx context.Context, dep *boot.Dependencies, token string) error {
+func LoadFilesystem(ctx context.Context, sess *session.Session) error {
+	return loadBackup(ctx, sess, nil)
+}
+
+// LoadPaths loads the metadata of the given paths of the virtual Filesystem,
+// their parents and, for directories, their contents. The root path, or no
+// path at all as for the scripts, loads everything.
+func LoadPaths(ctx context.Context, sess *session.Session, paths []string) error {
+	if len(paths) == 0 {
+		return loadBackup(ctx, sess, nil)
+	}
+
+	var prefixes []string
+	for _, p := range paths {
+		p = strings.Trim(filepath.ToSlash(filepath.Clean("/"+p)), "/")
+		if p == "" {
+			return loadBackup(ctx, sess, nil)
+		}
+		prefixes = append(prefixes, p)
+	}
+
+	return loadBackup(ctx, sess, func(name string) bool {
+		name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "./")
+		if name == backupDir {
+			return true
+		}
+		name = strings.TrimPrefix(name, backupDir+"/")
+
+		for _, prefix := range prefixes {
+			if name == prefix || strings.HasPrefix(prefix, name+"/") || strings.HasPrefix(name, prefix+"/") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// backupDir is the directory the backup is extracted to.
+const backupDir = "backup"
+
+func loadBackup(ctx context.Context, sess *session.Session, keep func(name string) bool) error {
 	syscall.Umask(0)
 
-	backupURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/backup"
+	backupURL := constant.Protocol + sess.Host() + constant.ApiVer + "/backup"
 
 	client := http.Client{}
 	req, err := http.NewRequest(http.MethodGet, backupURL, nil)
-	req.Header.Set("token", token)
+	req.Header.Set("token", sess.Token())
 	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
+	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return fmt.Errorf("ERROR: logger not initiated")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("loading the filesystem: %s", resp.Status)
 	}
 
+	log := sess.Logger
+
 	file, err := os.Create("backup.tar")
 	if err != nil {
 		log.Print("ERROR: ", err)
@@ -116,7 +165,7 @@ func LoadFilesystem(ctx context.Context, dep *boot.Dependencies, token string) e
 		return err
 	}
 
-	err = Untar("backup.tar", ".")
+	err = UntarFunc("backup.tar", ".", keep)
 	if err != nil {
 		log.Print("ERROR: ", err)
 		return err
@@ -127,15 +176,15 @@ func LoadFilesystem(ctx context.Context, dep *boot.Dependencies, token string) e
 	return nil
 }
 
-func LoadFilesystem2(ctx context.Context, dep *boot.Dependencies, token string) error {
+func LoadFilesystem2(ctx context.Context, sess *session.Session) error {
 	syscall.Umask(0)
 	dst := "output"
 
-	backupURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/backup"
+	backupURL := constant.Protocol + sess.Host() + constant.ApiVer + "/backup"
 
 	client := http.Client{}
 	req, err := http.NewRequest(http.MethodGet, backupURL, nil)
-	req.Header.Set("token", token)
+	req.Header.Set("token", sess.Token())
 	resp, err := client.Do(req)
 	if err != nil || resp.StatusCode != http.StatusOK {
 		return err
@@ -143,10 +192,7 @@ func LoadFilesystem2(ctx context.Context, dep *boot.Dependencies, token string)
 
 	defer resp.Body.Close()
 
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return fmt.Errorf("ERROR: logger not initiated")
-	}
+	log := sess.Logger
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -198,25 +244,10 @@ func LoadFilesystem2(ctx context.Context, dep *boot.Dependencies, token string)
 	return nil
 }
 
-func ReloadFilesys(ctx context.Context) error {
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return fmt.Errorf("ERROR: logger not initiated")
-	}
-
-	userState, ok := ctx.Value("userState").(model.UserState)
-	if !ok {
-		return errors.New("failed to get userState from context")
-	}
-
-	dep, ok := ctx.Value("dependency").(*boot.Dependencies)
-	if !ok {
-		return errors.New("failed to get dependency from context")
-	}
-
-	err := LoadFilesystem(ctx, dep, userState.Token)
+func ReloadFilesys(ctx context.Context, sess *session.Session) error {
+	err := LoadFilesystem(ctx, sess)
 	if err != nil {
-		log.Println("ERROR: ", err)
+		sess.Logger.Println("ERROR: ", err)
 		return errors.New("Load new filesysytem")
 	}
 
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+)
+
+// watchAndExit uploads the changes of hostDir to dst until interrupted,
+// waits for the replicated operations to be acknowledged and exits with
+// status 1 on failure. The whole Filesystem is loaded and kept up to date,
+// so that the changes of other clients are seen while watching.
+func watchAndExit(hostDir, dst string, opts fsys.WatchOptions) {
+	c, err := newClient(clientOptions{})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	publishing := model.Publishing{
+		PublishSync:         true,
+		PublishIntermediate: true,
+	}
+
+	ctx, stop := signal.NotifyContext(c.ctx, syscall.SIGTERM)
+	streams := fsys.StdStreams()
+	streams.Format = c.format
+	err = c.vol.Root().Watch(ctx, c.sess, publishing, streams, hostDir, dst, opts)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+
+	if werr := c.Wait(); werr != nil {
+		fmt.Fprintln(os.Stderr, werr.Error())
+		if err == nil {
+			err = werr
+		}
+	}
+	c.Close()
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	var opts fsys.WatchOptions
+	var watchCmd = &cobra.Command{
+		Use:   "watch host-dir vfs-dir",
+		Short: "Uploads the changes of a host directory as they are made, until interrupted",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.Debounce <= 0 || opts.Interval <= 0 {
+				log.Fatal("watch: --debounce and --interval must be positive")
+				return
+			}
+			watchAndExit(args[0], strings.TrimPrefix(args[1], constant.VFSPrefix), opts)
+		},
+	}
+	watchCmd.Flags().BoolVar(&opts.Poll, "poll", false, "Scan the directory instead of using inotify, as for network mounts")
+	watchCmd.Flags().StringVar(&opts.StateFile, "state", "", "Where the uploaded files are recorded across restarts, under $XDG_DATA_HOME/vfs/watch by default")
+	watchCmd.Flags().DurationVar(&opts.Debounce, "debounce", 500*time.Millisecond, "The quiet time after a change before uploading")
+	watchCmd.Flags().DurationVar(&opts.Interval, "interval", 2*time.Second, "The time between two scans when polling")
+
+	rootCmd.AddCommand(watchCmd)
+}
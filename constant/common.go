@@ -3,4 +3,8 @@ package constant
 const (
 	Protocol = "http://"
 	ApiVer   = "/api/v1"
+
+	HostPrefix   = "host:"   // Marks a path of the host filesystem in the shell.
+	VFSPrefix    = "vfs:"    // Marks a path of the virtual Filesystem in the shell.
+	RemotePrefix = "remote:" // Marks an object of the intermediate service, path[@version].
 )
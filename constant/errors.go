@@ -10,10 +10,11 @@ var (
 	ErrPathNotFound       = errors.New("no such file or directory")
 	ErrAlreadyExists      = errors.New("file or directory already exists")
 	ErrPathFormatNotFound = errors.New("Error: path %s does not exist")
-	ErrTokenNotFound      = errors.New("failed to get token from context")
-	ErrHostNotFound       = errors.New("failed to get host from context")
-	ErrClientsNotFound    = errors.New("failed to get client list from context")
-	ErrUserStateNotFound  = errors.New("failed to get user state list from context")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrVolumeClosed       = errors.New("volume is closed")
+	ErrInvalidCredentials = errors.New("username or password is invalid")
+	ErrRemoteData         = errors.New("failed to get file data from remote")
+	ErrRemoteFailed       = errors.New("the intermediate service failed")
 )
 
 func Errorf(format string, a ...interface{}) error {
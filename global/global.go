@@ -1,7 +0,0 @@
-package global
-
-import "github.com/marcellof23/vfs-TA/pkg/fsys"
-
-var (
-	Filesys *fsys.Filesystem
-)
@@ -64,6 +64,7 @@ type FileData struct {
 	loaded  bool
 	uid     int
 	gid     int
+	sum     string
 }
 
 func (d *FileData) Name() string {
@@ -80,6 +81,12 @@ func (d *FileData) GetGID() int {
 	return d.gid
 }
 
+func (d *FileData) GetChecksum() string {
+	d.Lock()
+	defer d.Unlock()
+	return d.sum
+}
+
 func SetLoaded(f *FileData, isLoaded bool) {
 	f.Lock()
 	f.loaded = isLoaded
@@ -128,6 +135,12 @@ func SetGID(f *FileData, gid int) {
 	f.Unlock()
 }
 
+func SetChecksum(f *FileData, sum string) {
+	f.Lock()
+	f.sum = sum
+	f.Unlock()
+}
+
 func GetFileInfo(f *FileData) *FileInfo {
 	return &FileInfo{f}
 }
@@ -468,3 +468,30 @@ func (m *MemMapFs) Gid(name string) int {
 
 	return f.GetGID()
 }
+
+func (m *MemMapFs) Checksum(name string) string {
+	name = normalizePath(name)
+
+	m.mu.RLock()
+	f, ok := m.getData()[name]
+	m.mu.RUnlock()
+	if !ok {
+		return ""
+	}
+
+	return f.GetChecksum()
+}
+
+func (m *MemMapFs) SetChecksum(name string, sum string) error {
+	name = normalizePath(name)
+
+	m.mu.RLock()
+	f, ok := m.getData()[name]
+	m.mu.RUnlock()
+	if !ok {
+		return &os.PathError{Op: "setchecksum", Path: name, Err: ErrFileNotFound}
+	}
+
+	mem.SetChecksum(f, sum)
+	return nil
+}
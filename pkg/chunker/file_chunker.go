@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/marcellof23/vfs-TA/pkg/producer"
+	"github.com/marcellof23/vfs-TA/pkg/progress"
 )
 
 var (
@@ -19,12 +20,34 @@ var (
 
 type FileChunk struct {
 	Ctx           context.Context
+	Producer      *producer.Producer
 	Command       string
 	AbsPathSource string
 	AbsPathDest   string
 	Token         string
 	Uid           int
 	Gid           int
+	Checksum      string
+	Progress      *progress.Task // Counts the chunks sent and acknowledged.
+
+	errMu sync.Mutex
+	err   error // The first error producing a chunk.
+}
+
+// setErr records err unless an earlier error was.
+func (fc *FileChunk) setErr(err error) {
+	fc.errMu.Lock()
+	defer fc.errMu.Unlock()
+	if fc.err == nil {
+		fc.err = err
+	}
+}
+
+// firstErr returns the first error producing a chunk.
+func (fc *FileChunk) firstErr() error {
+	fc.errMu.Lock()
+	defer fc.errMu.Unlock()
+	return fc.err
 }
 
 func (fc *FileChunk) chunkBytes(data []byte, chunkSize int) [][]byte {
@@ -65,6 +88,9 @@ func (fc *FileChunk) ProcessChunk(chunk []byte, linesPool, stringPool *sync.Pool
 		go func(s int, e int) {
 			defer wg.Done() //to avoid deadlocks
 			for j := s; j < e; j++ {
+				if fc.Ctx.Err() != nil {
+					return
+				}
 				data := datasSlice[j]
 
 				msg := producer.Message{
@@ -76,14 +102,18 @@ func (fc *FileChunk) ProcessChunk(chunk []byte, linesPool, stringPool *sync.Pool
 					Order:         partition + j,
 					Uid:           fc.Uid,
 					Gid:           fc.Gid,
+					Checksum:      fc.Checksum,
 				}
 
-				err := producer.ProduceCommand(fc.Ctx, msg)
+				fc.Progress.ChunkSent()
+				err := fc.Producer.ProduceCommand(fc.Ctx, msg)
 				if err != nil {
-					fmt.Println(err)
+					fc.setErr(fmt.Errorf("chunk %d: %w", msg.Order, err))
+					return
 				}
+				fc.Progress.ChunkAcked(int64(len(data)))
 				//fmt.Printf("%+v\n", msg)
-				//r := producer.Retry(producer.ProduceCommand, 3e9)
+				//r := fc.Producer.Retry(fc.Producer.ProduceCommand, 3e9)
 				//go r(fc.Ctx, msg)
 
 				if len(data) == 0 {
@@ -109,6 +139,11 @@ func (fc *FileChunk) Process(f *os.File) error {
 		return lines
 	}}
 
+	if stat, err := f.Stat(); err == nil {
+		fc.Progress.SetTotal(stat.Size())
+		fc.Progress.SetChunks(int((stat.Size() + int64(chunkSz) - 1) / int64(chunkSz)))
+	}
+
 	r := bufio.NewReader(f)
 
 	var wg sync.WaitGroup
@@ -116,24 +151,27 @@ func (fc *FileChunk) Process(f *os.File) error {
 
 	partition := 0
 	for {
+		// A canceled context stops producing the remaining chunks.
+		if err := fc.Ctx.Err(); err != nil {
+			return err
+		}
+
 		buf := linesPool.Get().([]byte)
 
 		n, err := r.Read(buf)
 		buf = buf[:n]
 
 		if n == 0 {
-			if err != nil {
-				break
-			}
 			if err == io.EOF {
 				break
 			}
-			return err
+			if err != nil {
+				return err
+			}
 		}
 
 		wg.Add(1)
 
-		fmt.Println("punten", partition)
 		go func(partition int) {
 			fc.ProcessChunk(buf, &linesPool, &stringPool, partition)
 			wg.Done()
@@ -144,7 +182,12 @@ func (fc *FileChunk) Process(f *os.File) error {
 		mutex.Unlock()
 
 		wg.Wait()
+
+		// A chunk that could not be produced fails the whole file.
+		if err := fc.firstErr(); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return fc.Ctx.Err()
 }
@@ -0,0 +1,366 @@
+package fsys
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// AferoFs adapts a Filesystem and a user session to afero.Fs,
+// so the VFS can be used as a library. Names are resolved relative to the
+// directory of fs, permissions are checked for the session user and writes are
+// replicated according to publishing. Every call goes through the operation
+// queue of the volume, so the adapter is safe for concurrent use.
+type AferoFs struct {
+	fs         *Filesystem
+	ctx        context.Context
+	sess       *session.Session
+	publishing model.Publishing
+}
+
+var _ afero.Fs = &AferoFs{}
+
+// NewAferoFs returns an afero.Fs backed by fs.
+func NewAferoFs(ctx context.Context, sess *session.Session, fs *Filesystem, publishing model.Publishing) *AferoFs {
+	return &AferoFs{
+		fs:         fs,
+		ctx:        ctx,
+		sess:       sess,
+		publishing: publishing,
+	}
+}
+
+// NewIOFS returns an io/fs view of fs implementing fs.FS, fs.ReadDirFS,
+// fs.ReadFileFS, fs.StatFS, fs.GlobFS and fs.SubFS.
+func NewIOFS(ctx context.Context, sess *session.Session, fs *Filesystem, publishing model.Publishing) afero.IOFS {
+	return afero.NewIOFS(NewAferoFs(ctx, sess, fs, publishing))
+}
+
+func (a *AferoFs) Name() string { return "VFS" }
+
+func (a *AferoFs) Create(name string) (afero.File, error) {
+	return a.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+func (a *AferoFs) Mkdir(name string, perm os.FileMode) error {
+	return a.do(func() error {
+		if _, err := a.fs.Stat(name); err == nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+
+		err := a.authRun(a.fs.MkDir, "mkdir", name)
+		if err != nil {
+			return toPathError("mkdir", name, err)
+		}
+
+		return toPathError("mkdir", name, a.fs.MFS.Chmod(a.fs.absPath(name), perm.Perm()))
+	})
+}
+
+func (a *AferoFs) MkdirAll(path string, perm os.FileMode) error {
+	info, err := a.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+
+	parent := filepath.ToSlash(filepath.Dir(path))
+	if parent != path {
+		err = a.MkdirAll(parent, perm)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.Mkdir(path, perm)
+}
+
+func (a *AferoFs) Open(name string) (afero.File, error) {
+	return a.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (a *AferoFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	var f *File
+	err := a.do(func() (err error) {
+		f, err = a.fs.OpenFile(a.ctx, a.sess, a.publishing, name, flag, perm)
+		return err
+	})
+	if err != nil {
+		return nil, toPathError("open", name, err)
+	}
+	return &syncFile{File: f, vol: a.fs.vol}, nil
+}
+
+func (a *AferoFs) Remove(name string) error {
+	return a.do(func() error {
+		info, err := a.fs.Stat(name)
+		if err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+		}
+
+		if !info.IsDir() {
+			return toPathError("remove", name, a.authRun(a.fs.RemoveFile, "rm", name))
+		}
+
+		names, err := afero.ReadDir(a.fs.MFS, a.fs.absPath(name))
+		if err != nil {
+			return toPathError("remove", name, err)
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+
+		return toPathError("remove", name, a.authRun(a.fs.RemoveDir, "rm", "-r", name))
+	})
+}
+
+func (a *AferoFs) RemoveAll(path string) error {
+	return a.do(func() error {
+		info, err := a.fs.Stat(path)
+		if err != nil {
+			return nil
+		}
+
+		if !info.IsDir() {
+			return toPathError("removeall", path, a.authRun(a.fs.RemoveFile, "rm", path))
+		}
+		return toPathError("removeall", path, a.authRun(a.fs.RemoveDir, "rm", "-r", path))
+	})
+}
+
+// Rename moves oldname to newname by copying it and removing the source,
+// so each step is checked and replicated like the shell commands.
+func (a *AferoFs) Rename(oldname, newname string) error {
+	info, err := a.Stat(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+
+	if _, err = a.Stat(newname); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+
+	if !info.IsDir() {
+		err = a.copyFile(oldname, newname)
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: mapError(err)}
+		}
+		return a.Remove(oldname)
+	}
+
+	err = afero.Walk(a, oldname, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(oldname, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.ToSlash(filepath.Join(newname, rel))
+
+		if fi.IsDir() {
+			return a.Mkdir(target, fi.Mode().Perm())
+		}
+		return a.copyFile(path, target)
+	})
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: mapError(err)}
+	}
+
+	return a.RemoveAll(oldname)
+}
+
+func (a *AferoFs) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := a.do(func() (err error) {
+		info, err = a.fs.Stat(name)
+		return err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (a *AferoFs) Chmod(name string, mode os.FileMode) error {
+	return a.do(func() error {
+		if a.sess.Role() == "Normal" && a.sess.User.UserID != a.fs.MFS.Uid(a.fs.absPath(name)) {
+			return &os.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+		}
+
+		perm := strconv.FormatUint(uint64(mode.Perm()), 8)
+		return toPathError("chmod", name, a.fs.Chmod(a.ctx, a.sess, a.publishing, name, perm))
+	})
+}
+
+func (a *AferoFs) Chown(name string, uid, gid int) error {
+	if a.sess.Role() == "Normal" {
+		return &os.PathError{Op: "chown", Path: name, Err: os.ErrPermission}
+	}
+	return a.do(func() error {
+		return toPathError("chown", name, a.fs.MFS.Chown(a.fs.absPath(name), uid, gid))
+	})
+}
+
+func (a *AferoFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return a.do(func() error {
+		f, err := a.fs.OpenFile(a.ctx, a.sess, a.publishing, name, os.O_WRONLY, 0)
+		if err != nil {
+			return toPathError("chtimes", name, err)
+		}
+		f.Close()
+
+		return toPathError("chtimes", name, a.fs.Chtimes(a.ctx, a.sess, a.publishing, name, mtime))
+	})
+}
+
+func (a *AferoFs) copyFile(src, dst string) error {
+	return a.do(func() error {
+		err := a.fs.Authorize(a.sess, "cp", src, dst)
+		if err != nil {
+			return err
+		}
+		return a.fs.CopyFile(a.ctx, a.sess, a.publishing, src, dst)
+	})
+}
+
+// do applies fn through the operation queue of the volume.
+func (a *AferoFs) do(fn func() error) error {
+	return a.fs.vol.Do(fn)
+}
+
+// authRun checks the permissions of the shell command name with args and
+// runs f on the last of them, the path.
+func (a *AferoFs) authRun(f func(context.Context, *session.Session, model.Publishing, string) error, name string, args ...string) error {
+	err := a.fs.Authorize(a.sess, name, args...)
+	if err != nil {
+		return err
+	}
+	return f(a.ctx, a.sess, a.publishing, args[len(args)-1])
+}
+
+// mapError translates the VFS errors to the os errors expected by io/fs users.
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, constant.ErrUnauthorizedAccess):
+		return os.ErrPermission
+	case errors.Is(err, constant.ErrPathNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, constant.ErrAlreadyExists):
+		return os.ErrExist
+	}
+	return err
+}
+
+func toPathError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Err = mapError(pathErr.Err)
+		return pathErr
+	}
+	return &os.PathError{Op: op, Path: path, Err: mapError(err)}
+}
+
+// syncFile applies every call on an open File through the operation queue
+// of its volume.
+type syncFile struct {
+	*File
+	vol *Volume
+}
+
+func (f *syncFile) Close() error {
+	return f.vol.Do(f.File.Close)
+}
+
+func (f *syncFile) Read(p []byte) (n int, err error) {
+	err = f.vol.Do(func() (err error) {
+		n, err = f.File.Read(p)
+		return err
+	})
+	return n, err
+}
+
+func (f *syncFile) ReadAt(p []byte, off int64) (n int, err error) {
+	err = f.vol.Do(func() (err error) {
+		n, err = f.File.ReadAt(p, off)
+		return err
+	})
+	return n, err
+}
+
+func (f *syncFile) Seek(offset int64, whence int) (ret int64, err error) {
+	err = f.vol.Do(func() (err error) {
+		ret, err = f.File.Seek(offset, whence)
+		return err
+	})
+	return ret, err
+}
+
+func (f *syncFile) Write(p []byte) (n int, err error) {
+	err = f.vol.Do(func() (err error) {
+		n, err = f.File.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (f *syncFile) WriteAt(p []byte, off int64) (n int, err error) {
+	err = f.vol.Do(func() (err error) {
+		n, err = f.File.WriteAt(p, off)
+		return err
+	})
+	return n, err
+}
+
+func (f *syncFile) WriteString(s string) (n int, err error) {
+	return f.Write([]byte(s))
+}
+
+func (f *syncFile) Readdir(count int) (infos []os.FileInfo, err error) {
+	err = f.vol.Do(func() (err error) {
+		infos, err = f.File.Readdir(count)
+		return err
+	})
+	return infos, err
+}
+
+func (f *syncFile) Readdirnames(n int) (names []string, err error) {
+	err = f.vol.Do(func() (err error) {
+		names, err = f.File.Readdirnames(n)
+		return err
+	})
+	return names, err
+}
+
+func (f *syncFile) Stat() (info os.FileInfo, err error) {
+	err = f.vol.Do(func() (err error) {
+		info, err = f.File.Stat()
+		return err
+	})
+	return info, err
+}
+
+func (f *syncFile) Sync() error {
+	return f.vol.Do(f.File.Sync)
+}
+
+func (f *syncFile) Truncate(size int64) error {
+	return f.vol.Do(func() error {
+		return f.File.Truncate(size)
+	})
+}
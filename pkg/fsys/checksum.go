@@ -0,0 +1,108 @@
+package fsys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// Checksum returns the hex encoded sha256 of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChecksumReader returns the hex encoded sha256 of everything read from r.
+func ChecksumReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errNoChecksum is returned when there is no checksum to verify against.
+var errNoChecksum = errors.New("no checksum")
+
+// verifyChecksum compares the checksum of data with the expected one, which
+// must not be empty.
+func verifyChecksum(expected string, data []byte) error {
+	if expected == "" {
+		return errNoChecksum
+	}
+	if actual := Checksum(data); actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", constant.ErrChecksumMismatch, expected, actual)
+	}
+	return nil
+}
+
+// readContent returns the content of a file, fetching it from the
+// intermediate service when it has been evicted from memory.
+func (fs *Filesystem) readContent(ctx context.Context, sess *session.Session, path string) ([]byte, error) {
+	data, err := afero.ReadFile(fs.MFS, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.isEvicted(path) {
+		data, err = fs.fetchObject(ctx, sess, path)
+		if err != nil {
+			return nil, err
+		}
+		fs.vol.lru().Put(path, int64(len(data)), data, fs)
+	}
+	return data, nil
+}
+
+// Sha256Sum returns the sha256 of a file content.
+func (fs *Filesystem) Sha256Sum(ctx context.Context, sess *session.Session, publishing model.Publishing, path string) (ChecksumResult, error) {
+	absPath := fs.absPath(path)
+	info, err := fs.Stat(path)
+	if err != nil {
+		return ChecksumResult{}, fmt.Errorf("sha256sum: %s: %w", path, constant.ErrPathNotFound)
+	}
+	if info.IsDir() {
+		return ChecksumResult{}, fmt.Errorf("sha256sum: %s: Is a directory", path)
+	}
+
+	data, err := fs.readContent(ctx, sess, absPath)
+	if err != nil {
+		return ChecksumResult{}, err
+	}
+
+	return ChecksumResult{Path: path, Sha256: Checksum(data)}, nil
+}
+
+// Verify checks a file content against the checksum stored on write. The
+// result of a file that could be read is returned along with the error of a
+// mismatch or of a missing checksum.
+func (fs *Filesystem) Verify(ctx context.Context, sess *session.Session, publishing model.Publishing, path string) (*VerifyResult, error) {
+	absPath := fs.absPath(path)
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("verify: %s: %w", path, constant.ErrPathNotFound)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("verify: %s: Is a directory", path)
+	}
+
+	data, err := fs.readContent(ctx, sess, absPath)
+	if err != nil {
+		return &VerifyResult{Path: path, Error: err.Error()}, err
+	}
+
+	if err = verifyChecksum(fs.MFS.Checksum(absPath), data); err != nil {
+		err = fmt.Errorf("verify: %s: %w", path, err)
+		return &VerifyResult{Path: path, Error: err.Error()}, err
+	}
+
+	return &VerifyResult{Path: path, OK: true}, nil
+}
@@ -2,160 +2,806 @@ package fsys
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 // Filesystem Commands
 
-// Usage prints verifies that each command has the correct amount of
-// command arguments and throws an error if not.
-func (fs *Filesystem) Usage(comms []string) bool {
-	switch comms[0] {
-	case "mkdir":
-		if len(comms) < 2 {
-			fmt.Println(constant.UsageCommandMkdir)
-			return false
-		}
-	case "pwd":
-		if len(comms) > 1 {
-			fmt.Println(constant.UsageCommandPwd)
-			return false
-		}
-	case "ls":
-		if len(comms) > 1 {
-			fmt.Println(constant.UsageCommandLs)
-			return false
-		}
-	case "cat":
-		if len(comms) < 2 {
-			fmt.Println(constant.UsageCommandCat)
-			return false
-		}
-	case "stat":
-		if len(comms) < 2 {
-			fmt.Println(constant.UsageCommandStat)
-			return false
-		}
-	case "rm":
-		if len(comms) < 2 {
-			fmt.Println(constant.UsageCommandRm)
-			return false
-		}
-		if comms[1] == "-r" {
-			if len(comms) < 3 {
-				fmt.Println(constant.UsageCommandRm)
-				return false
+func init() {
+	for _, cmd := range builtinCommands {
+		Register(cmd)
+	}
+}
+
+var builtinCommands = []*Command{
+	{
+		Name:      "mkdir",
+		Summary:   "make a directory",
+		Args:      []Operand{{Name: "Directory name", Kind: OperandParent, Access: "-wx"}},
+		Replicate: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.MkDir(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
+		},
+	},
+	{
+		Name:    "pwd",
+		Summary: "print the current working directory",
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Print(fs.Pwd())
+		},
+	},
+	{
+		Name:    "ls",
+		Summary: "list the current directory",
+		Flags: []Flag{
+			{Name: "-l", Usage: "print the mode, owner, size and modification time"},
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Print(fs.ListDir(inv.Has("-l")))
+		},
+	},
+	{
+		Name:    "cat",
+		Summary: "print the content of a file",
+		Args:    []Operand{{Name: "File name", Kind: OperandPath, Access: "r--"}},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Cat(inv.Ctx, inv.Sess, inv.Publishing, inv.Stdout, inv.Args[0])
+		},
+	},
+	{
+		Name:    "stat",
+		Summary: "print the status of a file or directory",
+		Args:    []Operand{{Name: "File name", Kind: OperandPath, Access: "r--"}},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			stat, err := fs.Stat(inv.Args[0])
+			if err != nil {
+				return err
 			}
-		}
-	case "cp":
-		if len(comms) < 3 {
-			fmt.Println(constant.UsageCommandCp)
-			return false
-		}
-		if comms[1] == "-r" {
-			if len(comms) < 4 {
-				fmt.Println(constant.UsageCommandCp)
-				return false
+			return inv.Print(fs.Entry(stat, inv.Args[0]))
+		},
+	},
+	{
+		Name:    "rm",
+		Summary: "remove a file",
+		Flags: []Flag{
+			{Name: "-r", Usage: "remove directories and their contents recursively"},
+		},
+		Args:      []Operand{{Name: "File name", Kind: OperandPath, Access: "-wx"}},
+		Replicate: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.RemoveDir(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
 			}
-		}
-	case "chmod":
-		if len(comms) < 3 {
-			fmt.Println(constant.UsageCommandChmod)
-			return false
-		}
-	case "upload":
-		if len(comms) < 3 {
-			fmt.Println(constant.UsageCommandUpload)
-			return false
-		}
-		if comms[1] == "-r" {
-			if len(comms) < 4 {
-				fmt.Println(constant.UsageCommandUpload)
-				return false
+			return fs.RemoveFile(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
+		},
+	},
+	{
+		Name:    "cp",
+		Summary: "copy a file",
+		Flags: []Flag{
+			{Name: "-r", Usage: "copy directories and their contents recursively"},
+		},
+		Args: []Operand{
+			{Name: "File name source", Kind: OperandPath, Access: "r--", RecAccess: "r-x"},
+			{Name: "File name destination", Kind: OperandPath, Access: "-w-", RecAccess: "rw-"},
+		},
+		Replicate: true,
+		Check: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.CheckCPRecPath(inv.Args[0], inv.Args[1])
 			}
-		}
-	case "migrate":
-		if len(comms) < 3 {
-			fmt.Println(constant.UsageCommandMigrate)
-			return false
-		}
-	case "download":
-		if len(comms) < 3 {
-			fmt.Println(constant.UsageCommandDownload)
-			return false
-		}
-	}
-	return true
+			return fs.CheckCPPath(inv.Args[0], inv.Args[1])
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.CopyDir(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+			}
+			return fs.CopyFile(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+		},
+	},
+	{
+		Name:    "chmod",
+		Summary: "change the permissions of a file or directory",
+		Args: []Operand{
+			{Name: "Mode", Kind: OperandValue},
+			{Name: "File name", Kind: OperandPath},
+		},
+		Replicate: true,
+		Check: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Sess.Role() == "Normal" && inv.Sess.User.UserID != fs.MFS.Uid(fs.absPath(inv.Args[1])) {
+				return constant.ErrUnauthorizedAccess
+			}
+			return nil
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Chmod(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[1], inv.Args[0])
+		},
+	},
+	{
+		Name:    "chtimes",
+		Summary: "set the modification time of a file, as sync and the adapters do",
+		Args: []Operand{
+			{Name: "Time", Kind: OperandValue},
+			{Name: "File name", Kind: OperandPath, Access: "-w-"},
+		},
+		Hidden:    true,
+		Replicate: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			mtime, err := time.Parse(time.RFC3339Nano, inv.Args[0])
+			if err != nil {
+				return fmt.Errorf("chtimes: %w", err)
+			}
+			return fs.Chtimes(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[1], mtime)
+		},
+	},
+	{
+		Name:    "upload",
+		Summary: "upload a file from the host",
+		Flags: []Flag{
+			{Name: "-r", Usage: "upload directories and their contents recursively"},
+		},
+		Args: []Operand{
+			{Name: "File name local", Kind: OperandHostPath},
+			{Name: "File name vfs", Kind: OperandPath, Access: "-w-", RecAccess: "-wx"},
+		},
+		Check: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.CheckUploadRecPath(inv.Args[0], inv.Args[1])
+			}
+			return fs.CheckUploadPath(inv.Args[0], inv.Args[1])
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.UploadDir(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+			}
+			return fs.UploadFile(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+		},
+	},
+	{
+		Name:    "sync",
+		Summary: "make a directory like another, between the host (host:) and the Filesystem, copying only what changed",
+		Flags: []Flag{
+			{Name: "--delete", Usage: "remove the files of the destination not in the source"},
+			{Name: "--dry-run", Usage: "print the operations without making them"},
+			{Name: "--checksum", Usage: "compare the hashes of files of the same size, whatever their modification times"},
+		},
+		Args: []Operand{
+			{Name: "Directory source", Kind: OperandPath, Access: "r-x"},
+			{Name: "Directory destination", Kind: OperandPath, Access: "rwx", Create: true},
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			opts := SyncOptions{Delete: inv.Has("--delete"), DryRun: inv.Has("--dry-run"), Checksum: inv.Has("--checksum")}
+			result, err := fs.Sync(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1], opts)
+			if len(result.Ops) > 0 || result.DryRun {
+				if perr := inv.Print(result); perr != nil {
+					return perr
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name:    "watch",
+		Summary: "upload the changes of a host directory as they are made, until interrupted",
+		Flags: []Flag{
+			{Name: "--poll", Usage: "scan the directory instead of using inotify, as for network mounts"},
+			{Name: "--state", Value: "file", Usage: "where the uploaded files are recorded across restarts", Optional: true},
+			{Name: "--debounce", Value: "duration", Usage: "the quiet time after a change before uploading, 500ms by default", Optional: true},
+			{Name: "--interval", Value: "duration", Usage: "the time between two scans when polling, 2s by default", Optional: true},
+		},
+		Args: []Operand{
+			{Name: "Directory local", Kind: OperandHostPath},
+			{Name: "Directory vfs", Kind: OperandPath, Access: "rwx", Create: true},
+		},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if !inv.Sess.Unlocked {
+				return errors.New("watch: must run alone in the foreground, it would hold the volume")
+			}
+
+			opts, err := parseWatchOptions(inv)
+			if err != nil {
+				return err
+			}
+			return fs.Watch(inv.Ctx, inv.Sess, inv.Publishing, inv.Streams, inv.Args[0], inv.Args[1], opts)
+		},
+	},
+	{
+		Name:    "upload-sync",
+		Summary: "apply a file uploaded by another client",
+		Args: []Operand{
+			{Name: "File name vfs", Kind: OperandPath},
+		},
+		Replicate: true,
+		Internal:  true,
+	},
+	{
+		Name:    "migrate",
+		Summary: "move the stored files to another cloud provider",
+		Args: []Operand{
+			{Name: "source cloud provider", Kind: OperandProvider},
+			{Name: "destination cloud provider", Kind: OperandProvider},
+		},
+		AdminOnly: true,
+		Unlocked:  true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			result, err := fs.Migrate(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+			if err != nil {
+				return err
+			}
+			return inv.Print(result)
+		},
+	},
+	{
+		Name:    "download",
+		Summary: "download a file to the host",
+		Flags: []Flag{
+			{Name: "-r", Usage: "download directories and their contents recursively"},
+		},
+		Args: []Operand{
+			{Name: "File name vfs", Kind: OperandPath, Access: "r--"},
+			{Name: "File name local", Kind: OperandHostPath},
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-r") {
+				return fs.DownloadRecursive(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+			}
+			return fs.DownloadFile(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Args[1])
+		},
+	},
+	{
+		Name:    "sha256sum",
+		Summary: "print the sha256 of files",
+		Args:    []Operand{{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true}},
+		Each:    true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			result, err := fs.Sha256Sum(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
+			if err != nil {
+				return err
+			}
+			return inv.Print(result)
+		},
+	},
+	{
+		Name:    "verify",
+		Summary: "verify files against their stored checksum",
+		Args:    []Operand{{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true}},
+		Each:    true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			result, err := fs.Verify(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
+			if result != nil {
+				if perr := inv.Print(*result); perr != nil {
+					return perr
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name:    "truncate",
+		Summary: "shrink or extend files to a size",
+		Flags: []Flag{
+			{Name: "-s", Value: "size", Usage: "the size, suffixed with K, M or G and prefixed with + or - to be relative to the current size"},
+		},
+		Args:      []Operand{{Name: "File name", Kind: OperandPath, Access: "-w-", Variadic: true}},
+		Each:      true,
+		Replicate: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.TruncateFile(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0], inv.Flags["-s"])
+		},
+	},
+	{
+		Name:    "write-range",
+		Summary: "apply a ranged write made by another client",
+		Args: []Operand{
+			{Name: "File name vfs", Kind: OperandPath},
+		},
+		Replicate: true,
+		Internal:  true,
+	},
+	{
+		Name:    "dd",
+		Summary: "copy count blocks of bs bytes, skipping skip blocks of the source and seek blocks of the destination",
+		Args: []Operand{
+			{Name: "if=source of=destination bs=BYTES count=N skip=N seek=N conv=notrunc", Kind: OperandValue, Variadic: true},
+		},
+		Check: func(fs *Filesystem, inv *Invocation) error {
+			opts, err := ParseDdOptions(inv.Args)
+			if err != nil {
+				return err
+			}
+			if inv.Sess.Role() != "Normal" {
+				return nil
+			}
+
+			err = fs.requireAccess(inv.Sess, opts.Input, "r--")
+			if err != nil {
+				return err
+			}
+			return fs.requireAccess(inv.Sess, opts.Output, "-w-")
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			opts, err := ParseDdOptions(inv.Args)
+			if err != nil {
+				return err
+			}
+			return fs.Dd(inv.Ctx, inv.Sess, inv.Publishing, opts.Input, opts.Output, opts, inv.Stderr)
+		},
+	},
+	{
+		Name:    "grep",
+		Summary: "print the lines matching a pattern",
+		Flags: []Flag{
+			{Name: "-i", Usage: "ignore case distinctions"},
+			{Name: "-v", Usage: "select the lines not matching"},
+		},
+		Args: []Operand{
+			{Name: "pattern", Kind: OperandValue},
+			{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true, Optional: true},
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Grep(inv.Ctx, inv.Sess, inv.Streams, inv.Args[0], inv.Has("-i"), inv.Has("-v"), inv.Args[1:])
+		},
+	},
+	{
+		Name:    "sort",
+		Summary: "print the lines sorted",
+		Flags: []Flag{
+			{Name: "-r", Usage: "reverse the result"},
+		},
+		Args: []Operand{
+			{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true, Optional: true},
+		},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Sort(inv.Ctx, inv.Sess, inv.Streams, inv.Has("-r"), inv.Args)
+		},
+	},
+	{
+		Name:    "head",
+		Summary: "print the first lines of files, reading only what is needed",
+		Flags: []Flag{
+			{Name: "-n", Value: "lines", Usage: "the number of lines, 10 by default", Optional: true},
+			{Name: "-c", Value: "bytes", Usage: "print the first bytes instead of lines", Optional: true},
+		},
+		Args:     []Operand{{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true, Optional: true}},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			count, countBytes, err := parseCount(inv)
+			if err != nil {
+				return err
+			}
+			return fs.Head(inv.Ctx, inv.Sess, inv.Streams, count, countBytes, inv.Args)
+		},
+	},
+	{
+		Name:    "tail",
+		Summary: "print the last lines of a file, reading only what is needed",
+		Flags: []Flag{
+			{Name: "-n", Value: "lines", Usage: "the number of lines, 10 by default", Optional: true},
+			{Name: "-c", Value: "bytes", Usage: "print the last bytes instead of lines", Optional: true},
+			{Name: "-f", Usage: "print the data appended to the file until interrupted"},
+		},
+		Args:     []Operand{{Name: "File name", Kind: OperandPath, Access: "r--", Optional: true}},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			count, countBytes, err := parseCount(inv)
+			if err != nil {
+				return err
+			}
+			name := ""
+			if len(inv.Args) > 0 {
+				name = inv.Args[0]
+			}
+			return fs.Tail(inv.Ctx, inv.Sess, inv.Streams, count, countBytes, inv.Has("-f"), name)
+		},
+	},
+	{
+		Name:    "wc",
+		Summary: "count the lines, words and bytes of files",
+		Flags: []Flag{
+			{Name: "-l", Usage: "count the lines"},
+			{Name: "-w", Usage: "count the words"},
+			{Name: "-c", Usage: "count the bytes, without reading the files"},
+		},
+		Args:     []Operand{{Name: "File name", Kind: OperandPath, Access: "r--", Variadic: true, Optional: true}},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			opts := WcOptions{Lines: inv.Has("-l"), Words: inv.Has("-w"), Bytes: inv.Has("-c")}
+			list, err := fs.Wc(inv.Ctx, inv.Sess, inv.Stdin, inv.Args, opts)
+			if len(list.Counts) > 0 {
+				if perr := inv.Print(list); perr != nil {
+					return perr
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name:    "diff",
+		Summary: "compare files, or trees with -r, of the Filesystem, the host (host:) or the remote (remote:path[@version])",
+		Flags: []Flag{
+			{Name: "-u", Usage: "write the differences in the unified format"},
+			{Name: "-r", Usage: "compare two trees, reporting the files added, removed and changed"},
+		},
+		Args: []Operand{
+			{Name: "File name a", Kind: OperandPath, Access: "r--", RecAccess: "r-x"},
+			{Name: "File name b", Kind: OperandPath, Access: "r--", RecAccess: "r-x"},
+		},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			var result Result
+			differ := false
+			if inv.Has("-r") {
+				tree, err := fs.DiffTree(inv.Ctx, inv.Sess, inv.Args[0], inv.Args[1])
+				if err != nil {
+					return err
+				}
+				result, differ = tree, len(tree.Changes) > 0
+			} else {
+				diff, err := fs.Diff(inv.Ctx, inv.Sess, inv.Args[0], inv.Args[1], inv.Has("-u"))
+				if err != nil {
+					return err
+				}
+				result, differ = diff, !diff.Identical
+			}
+
+			if err := inv.Print(result); err != nil {
+				return err
+			}
+			// Like diff on the host, the status is 1 when the sides differ.
+			if differ {
+				return fmt.Errorf("%w: %s and %s differ", errReported, inv.Args[0], inv.Args[1])
+			}
+			return nil
+		},
+	},
+	{
+		Name:     "edit",
+		Summary:  "edit a file in $EDITOR and write it back",
+		Args:     []Operand{{Name: "File name", Kind: OperandPath, Access: "rw-", Create: true}},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Edit(inv.Ctx, inv.Sess, inv.Publishing, inv.Streams, inv.Shell, inv.Args[0])
+		},
+	},
+	{
+		Name:     "less",
+		Summary:  "page through a file, reading only the lines shown",
+		Args:     []Operand{{Name: "File name", Kind: OperandPath, Access: "r--"}},
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return fs.Less(inv.Ctx, inv.Sess, inv.Streams, inv.Args[0])
+		},
+	},
+	{
+		Name:    "help",
+		Summary: "list the commands or print the usage of one",
+		Args:    []Operand{{Name: "command", Kind: OperandValue, Optional: true}},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			name := ""
+			if len(inv.Args) > 0 {
+				name = inv.Args[0]
+			}
+
+			help, err := Help(name)
+			if err != nil {
+				return err
+			}
+			return inv.Print(help)
+		},
+	},
+	{
+		Name:    "echo",
+		Summary: "print the arguments",
+		Flags: []Flag{
+			{Name: "-n", Usage: "do not print the trailing newline"},
+		},
+		Args: []Operand{{Name: "text", Kind: OperandValue, Variadic: true, Optional: true}},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			fmt.Fprint(inv.Stdout, strings.Join(inv.Args, " "))
+			if !inv.Has("-n") {
+				fmt.Fprintln(inv.Stdout)
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "test",
+		Summary: "print the content of a file through the cache",
+		Args:    []Operand{{Name: "File name", Kind: OperandPath}},
+		Hidden:  true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			fs.Testing(inv.Ctx, inv.Sess, inv.Args[0])
+			return nil
+		},
+	},
+	{
+		Name:    "exit",
+		Summary: "exit the shell with a status, 0 by default",
+		Args:    []Operand{{Name: "status", Kind: OperandValue, Optional: true}},
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			status := 0
+			if len(inv.Args) > 0 {
+				n, err := strconv.Atoi(inv.Args[0])
+				if err != nil {
+					return fmt.Errorf("exit: %s: numeric argument required", inv.Args[0])
+				}
+				status = n
+			}
+			return &ExitError{Status: status}
+		},
+	},
+	{
+		Name:    "cd",
+		Summary: "change the working directory, to the home directory by default or to the previous one with -",
+		// The access is checked once the target is resolved.
+		Args:  []Operand{{Name: "target directory", Kind: OperandPath, Optional: true}},
+		Shell: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			dir := ""
+			if len(inv.Args) > 0 {
+				dir = inv.Args[0]
+			}
+			return inv.Shell.Cd(inv.Stdout, inv.Format, inv.Sess, dir)
+		},
+	},
+	{
+		Name:    "pushd",
+		Summary: "save the working directory on the stack and change to a directory, or swap the top two",
+		Args:    []Operand{{Name: "target directory", Kind: OperandPath, Optional: true}},
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			dir := ""
+			if len(inv.Args) > 0 {
+				dir = inv.Args[0]
+			}
+			if err := inv.Shell.Pushd(inv.Sess, dir); err != nil {
+				return err
+			}
+			return inv.Print(inv.Shell.Dirs(false))
+		},
+	},
+	{
+		Name:    "popd",
+		Summary: "change to the directory on top of the stack and remove it",
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if err := inv.Shell.Popd(inv.Sess); err != nil {
+				return err
+			}
+			return inv.Print(inv.Shell.Dirs(false))
+		},
+	},
+	{
+		Name:    "dirs",
+		Summary: "print the directory stack",
+		Flags: []Flag{
+			{Name: "-c", Usage: "clear the stack"},
+			{Name: "-v", Usage: "print one directory per line with its position"},
+		},
+		Shell: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-c") {
+				inv.Shell.ClearDirs()
+				return nil
+			}
+			return inv.Print(inv.Shell.Dirs(inv.Has("-v")))
+		},
+	},
+	{
+		Name:    "history",
+		Summary: "print the command history, or its last n lines",
+		Flags: []Flag{
+			{Name: "-c", Usage: "clear the history"},
+		},
+		Args:  []Operand{{Name: "n", Kind: OperandValue, Optional: true}},
+		Shell: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-c") {
+				if inv.Shell.History == nil {
+					return nil
+				}
+				return inv.Shell.History.Clear()
+			}
+
+			n := 0
+			if len(inv.Args) > 0 {
+				var err error
+				n, err = strconv.Atoi(inv.Args[0])
+				if err != nil || n < 0 {
+					return fmt.Errorf("history: %s: numeric argument required", inv.Args[0])
+				}
+			}
+			return inv.Print(inv.Shell.HistoryEntries(n))
+		},
+	},
+	{
+		Name:    "jobs",
+		Summary: "list the background jobs",
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Print(inv.Shell.Jobs())
+		},
+	},
+	{
+		Name:     "fg",
+		Summary:  "wait for a background job, the last one by default",
+		Args:     []Operand{{Name: "%job", Kind: OperandValue, Optional: true}},
+		Shell:    true,
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			spec := ""
+			if len(inv.Args) > 0 {
+				spec = inv.Args[0]
+			}
+			return inv.Shell.Foreground(inv.Stdout, spec)
+		},
+	},
+	{
+		Name:     "wait",
+		Summary:  "wait for background jobs, all of them by default",
+		Args:     []Operand{{Name: "%job", Kind: OperandValue, Variadic: true, Optional: true}},
+		Shell:    true,
+		Unlocked: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Shell.WaitJobs(inv.Args)
+		},
+	},
+	{
+		Name:    "kill",
+		Summary: "stop background jobs",
+		Args:    []Operand{{Name: "%job", Kind: OperandValue, Variadic: true}},
+		Each:    true,
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Shell.Kill(inv.Args[0])
+		},
+	},
+	{
+		Name:    "set",
+		Summary: "set shell options and variables, or print the variables",
+		Flags: []Flag{
+			{Name: "-e", Usage: "stop at the first failing command"},
+			{Name: "+e", Usage: "keep going after a failing command"},
+		},
+		Args:  []Operand{{Name: "NAME=value", Kind: OperandValue, Variadic: true, Optional: true}},
+		Shell: true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if inv.Has("-e") {
+				inv.Shell.ErrExit = true
+			}
+			if inv.Has("+e") {
+				inv.Shell.ErrExit = false
+			}
+			if len(inv.Flags) == 0 && len(inv.Args) == 0 {
+				inv.Shell.PrintVars(inv.Stdout, false)
+				return nil
+			}
+			return inv.Shell.assignVars(inv.Args, false)
+		},
+	},
+	{
+		Name:    "export",
+		Summary: "set and export shell variables, or print the exported ones",
+		Args:    []Operand{{Name: "NAME[=value]", Kind: OperandValue, Variadic: true, Optional: true}},
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if len(inv.Args) == 0 {
+				inv.Shell.PrintVars(inv.Stdout, true)
+				return nil
+			}
+			return inv.Shell.assignVars(inv.Args, true)
+		},
+	},
+	{
+		Name:    "unset",
+		Summary: "remove shell variables",
+		Args:    []Operand{{Name: "NAME", Kind: OperandValue, Variadic: true}},
+		Each:    true,
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			inv.Shell.Unset(inv.Args[0])
+			return nil
+		},
+	},
+	{
+		Name:    "alias",
+		Summary: "define aliases, or print them",
+		Args:    []Operand{{Name: "name[=value]", Kind: OperandValue, Variadic: true, Optional: true}},
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			var names []string
+			for _, arg := range inv.Args {
+				name, value, ok := strings.Cut(arg, "=")
+				if !ok {
+					names = append(names, arg)
+					continue
+				}
+				if err := inv.Shell.SetAlias(name, value); err != nil {
+					return fmt.Errorf("alias: %w", err)
+				}
+			}
+
+			if len(names) > 0 || len(inv.Args) == 0 {
+				if err := inv.Shell.PrintAliases(inv.Stdout, names); err != nil {
+					return fmt.Errorf("alias: %w", err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "unalias",
+		Summary: "remove aliases",
+		Args:    []Operand{{Name: "name", Kind: OperandValue, Variadic: true}},
+		Each:    true,
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			if err := inv.Shell.Unalias(inv.Args[0]); err != nil {
+				return fmt.Errorf("unalias: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "source",
+		Summary: "run the commands of a file in the current shell",
+		Args:    []Operand{{Name: "File name", Kind: OperandValue}},
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			return inv.Shell.Source(inv.Ctx, inv.Sess, inv.Publishing, inv.Args[0])
+		},
+	},
+	{
+		Name:    "clear",
+		Summary: "clear the terminal screen",
+		Shell:   true,
+		Run: func(fs *Filesystem, inv *Invocation) error {
+			inv.Shell.ClearScreen()
+			return nil
+		},
+	},
 }
 
 // Execute runs the commands passed into it.
-func (fs *Filesystem) Execute(ctx context.Context, comms []string, publishing model.Publishing) (bool, error) {
-	var err error
-	if fs.Usage(comms) == false {
-		return false, nil
-	}
+func (fs *Filesystem) Execute(ctx context.Context, sess *session.Session, comms []string, publishing model.Publishing) (bool, error) {
+	return fs.ExecuteWith(ctx, sess, comms, publishing, StdStreams())
+}
+
+// ExecuteWith runs the commands passed into it with the given streams.
+func (fs *Filesystem) ExecuteWith(ctx context.Context, sess *session.Session, comms []string, publishing model.Publishing, streams Streams) (bool, error) {
+	return fs.execute(ctx, sess, comms, publishing, streams, nil)
+}
 
-	role, ok := ctx.Value("role").(string)
-	if !ok {
-		return false, fmt.Errorf("User is not authorized!")
+// execute runs a Filesystem command for shell, nil outside the shell.
+func (fs *Filesystem) execute(ctx context.Context, sess *session.Session, comms []string, publishing model.Publishing, streams Streams, shell *Shell) (bool, error) {
+	cmd, ok := LookupCommand(comms[0])
+	if !ok || cmd.Shell || cmd.Internal {
+		return false, fmt.Errorf("%s: Command not found", comms[0])
 	}
 
-	switch comms[0] {
-	case "mkdir":
-		err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.MkDir, ctx, publishing, comms[1])
-	case "pwd":
-		fs.Pwd()
-	case "ls":
-		fs.ListDir()
-	case "cat":
-		err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.Cat, ctx, publishing, comms[1])
-	case "stat":
-		stat, errs := fs.Stat(comms[1])
-		if err == nil {
-			fs.PrintStat(stat, comms[1])
-		}
-		err = errs
-	case "rm":
-		if comms[1] == "-r" {
-			err = fs.FilesystemAccessAuth(ctx, role, true, comms[0], fs.RemoveDir, ctx, publishing, comms[2])
-		} else {
-			err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.RemoveFile, ctx, publishing, comms[1])
-		}
-	case "cp":
-		if comms[1] == "-r" {
-			err = fs.FilesystemAccessAuth(ctx, role, true, comms[0], fs.CopyDir, ctx, publishing, comms[2], comms[3])
-		} else {
-			err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.CopyFile, ctx, publishing, comms[1], comms[2])
-		}
-	case "chmod":
-		err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.Chmod, ctx, publishing, comms[1], comms[2])
-	case "upload":
-		if comms[1] == "-r" {
-			err = fs.FilesystemAccessAuth(ctx, role, true, comms[0], fs.UploadDir, ctx, publishing, comms[2], comms[3])
-		} else {
-			err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.UploadFile, ctx, publishing, comms[1], comms[2])
-		}
-	case "migrate":
-		err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.Migrate, ctx, publishing, comms[1], comms[2])
-	case "download":
-
-		if comms[1] == "-r" {
-			err = fs.FilesystemAccessAuth(ctx, role, true, comms[0], fs.DownloadRecursive, ctx, publishing, comms[2], comms[3])
-		} else {
-			err = fs.FilesystemAccessAuth(ctx, role, false, comms[0], fs.DownloadFile, ctx, publishing, comms[1], comms[2])
+	inv, err := cmd.Parse(comms[1:])
+	if err != nil {
+		if streams.Format == FormatText {
+			fmt.Fprintln(streams.Stderr, cmd.Usage())
 		}
-	case "test":
-		fs.Testing(ctx, comms[1])
-	case "exit":
-		fs.TearDown()
-		os.Exit(1)
-	default:
-		return false, fmt.Errorf("%s: Command not found", comms[0])
+		return false, err
 	}
+	inv.Streams = streams
+	inv.Ctx = ctx
+	inv.Sess = sess
+	inv.Publishing = publishing
+	inv.Shell = shell
+
+	err = fs.run(cmd, inv)
 	if err != nil {
 		return true, err
 	}
@@ -164,37 +810,50 @@ func (fs *Filesystem) Execute(ctx context.Context, comms []string, publishing mo
 
 // Shell Commands
 
-func (s *Shell) Usage(comms []string) bool {
-	switch comms[0] {
-	case "cd":
-		if len(comms) != 2 {
-			fmt.Println("Usage : cd [target directory]")
-			return false
-		}
+// Execute runs the commands acting on the shell and reports whether comms
+// was one of them.
+func (s *Shell) Execute(ctx context.Context, sess *session.Session, comms []string) bool {
+	cmd, ok := LookupCommand(comms[0])
+	if !ok || !cmd.Shell {
+		return false
+	}
+
+	streams := StdStreams()
+	streams.Format = s.Format
+	_, err := s.ExecuteWith(ctx, sess, comms, model.Publishing{}, streams)
+	if err != nil {
+		writeError(streams.Stdout, s.Format, comms[0], err)
 	}
 	return true
 }
 
-func (s *Shell) Execute(ctx context.Context, comms []string) bool {
-	role, ok := ctx.Value("role").(string)
-	if !ok {
-		fmt.Println("User is not authorized!")
-		return false
+// ExecuteWith runs a shell or Filesystem command with the given streams.
+func (s *Shell) ExecuteWith(ctx context.Context, sess *session.Session, comms []string, publishing model.Publishing, streams Streams) (bool, error) {
+	if isAssignmentList(comms) {
+		return true, s.assignVars(comms, false)
 	}
 
-	if s.Usage(comms) == false {
-		return true
+	cmd, ok := LookupCommand(comms[0])
+	if !ok || !cmd.Shell {
+		return s.Fs.execute(ctx, sess, comms, publishing, streams, s)
 	}
-	switch comms[0] {
-	case "cd":
-		err := s.Fs.FilesystemAccessAuth(ctx, role, false, comms[0], s.ChDir, ctx, false, comms[1])
-		if err != nil {
-			fmt.Println(err.Error())
+
+	inv, err := cmd.Parse(comms[1:])
+	if err != nil {
+		if streams.Format == FormatText {
+			fmt.Fprintln(streams.Stderr, cmd.Usage())
 		}
-	case "clear":
-		s.ClearScreen()
-	default:
-		return false
+		return false, err
 	}
-	return true
+	inv.Streams = streams
+	inv.Ctx = ctx
+	inv.Sess = sess
+	inv.Publishing = publishing
+	inv.Shell = s
+
+	err = s.Fs.run(cmd, inv)
+	if err != nil {
+		return true, err
+	}
+	return true, nil
 }
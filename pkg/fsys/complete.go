@@ -0,0 +1,309 @@
+package fsys
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+)
+
+// Completer completes the command line of an interactive shell: command
+// names, flags, paths of the virtual Filesystem relative to the working
+// directory, host paths and cloud providers, depending on the operand under
+// the cursor. It implements readline.AutoCompleter.
+type Completer struct {
+	Shell     *Shell
+	Providers []string // Cloud providers accepted by migrate.
+}
+
+// NewCompleter creates a Completer for the shell.
+func NewCompleter(s *Shell, providers []string) *Completer {
+	return &Completer{Shell: s, Providers: providers}
+}
+
+// Do returns the suffixes completing the word before pos, and the length of
+// that word.
+func (c *Completer) Do(line []rune, pos int) ([][]rune, int) {
+	words, current := completionWords(string(line[:pos]))
+
+	var candidates []string
+	switch {
+	case len(words) > 0 && isRedirect(words[len(words)-1]):
+		candidates = c.paths(current, OperandPath)
+	case len(words) == 0:
+		candidates = c.commands(current)
+	default:
+		candidates = c.operands(words, current)
+	}
+
+	// Inside an open quote the suffix is inserted as is and the quote is
+	// closed with the word.
+	typed, quote := unescapeWord(current)
+	var result [][]rune
+	for _, candidate := range candidates {
+		suffix := string([]rune(candidate)[len([]rune(typed)):])
+		complete := strings.HasSuffix(suffix, " ")
+		suffix = strings.TrimSuffix(suffix, " ")
+		if quote == 0 {
+			suffix = escapeWord(suffix)
+		}
+		if complete {
+			if quote != 0 {
+				suffix += string(quote)
+			}
+			suffix += " "
+		}
+		result = append(result, []rune(suffix))
+	}
+	return result, len([]rune(current))
+}
+
+// completionWords returns the words of the last command of line before the
+// one being typed, and the word being typed.
+func completionWords(line string) ([]string, string) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case r == '|' || r == ';':
+			words = nil
+			word.Reset()
+			inWord = false
+			continue
+		}
+		word.WriteRune(r)
+		inWord = true
+	}
+	return words, word.String()
+}
+
+// isRedirect tells whether word is a redirection operator.
+func isRedirect(word string) bool {
+	switch word {
+	case ">", ">>", "<", "2>", "2>>":
+		return true
+	}
+	return false
+}
+
+// commands returns the names of the commands starting with prefix.
+func (c *Completer) commands(prefix string) []string {
+	var names []string
+	for _, cmd := range Commands() {
+		if strings.HasPrefix(cmd.Name, prefix) {
+			names = append(names, cmd.Name+" ")
+		}
+	}
+	return names
+}
+
+// operands completes the flag or operand current of the command in words.
+func (c *Completer) operands(words []string, current string) []string {
+	cmd, ok := LookupCommand(words[0])
+	if !ok {
+		return nil
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var flags []string
+		for _, flag := range cmd.Flags {
+			if strings.HasPrefix(flag.Name, current) {
+				flags = append(flags, flag.Name+" ")
+			}
+		}
+		return flags
+	}
+
+	if flag, ok := cmd.flag(words[len(words)-1]); ok && flag.Value != "" {
+		return nil
+	}
+
+	// Count the operands before the current one, skipping flags and their
+	// values and redirections with their targets.
+	index := 0
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		if isRedirect(word) {
+			i++
+			continue
+		}
+		if flag, ok := cmd.flag(word); ok {
+			if flag.Value != "" {
+				i++
+			}
+			continue
+		}
+		index++
+	}
+
+	operand, ok := cmd.operand(index)
+	if !ok {
+		return nil
+	}
+
+	switch operand.Kind {
+	case OperandProvider:
+		var providers []string
+		for _, provider := range c.Providers {
+			if strings.HasPrefix(provider, current) {
+				providers = append(providers, provider+" ")
+			}
+		}
+		return providers
+	case OperandPath, OperandParent, OperandHostPath:
+		return c.paths(current, operand.Kind)
+	}
+	return nil
+}
+
+// paths returns the paths starting with word, of the host for host operands
+// or words with the host prefix, and of the virtual Filesystem otherwise.
+// Directories end with a slash.
+func (c *Completer) paths(word string, kind OperandKind) []string {
+	word, _ = unescapeWord(word)
+
+	prefix := ""
+	switch {
+	case strings.HasPrefix(word, constant.HostPrefix):
+		prefix, kind = constant.HostPrefix, OperandHostPath
+	case strings.HasPrefix(word, constant.VFSPrefix):
+		prefix, kind = constant.VFSPrefix, OperandPath
+	case strings.HasPrefix(word, constant.RemotePrefix):
+		prefix, kind = constant.RemotePrefix, OperandPath
+	}
+	word = strings.TrimPrefix(word, prefix)
+
+	dir, base := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+
+	var names []string
+	if kind == OperandHostPath {
+		names = hostEntries(dir)
+	} else {
+		names = c.vfsEntries(dir)
+	}
+
+	var paths []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		path := prefix + dir + name
+		if !strings.HasSuffix(name, "/") {
+			path += " "
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// vfsEntries returns the names in the directory dir of the virtual
+// Filesystem, relative to the working directory.
+func (c *Completer) vfsEntries(dir string) []string {
+	var names []string
+	c.Shell.Fs.vol.Do(func() error {
+		target := c.Shell.Fs
+		if dir != "" {
+			var err error
+			target, err = c.Shell.verifyPath(dir)
+			if err != nil {
+				return err
+			}
+		}
+
+		for name := range target.files {
+			names = append(names, name)
+		}
+		for name := range target.directories {
+			names = append(names, name+"/")
+		}
+		return nil
+	})
+	return names
+}
+
+// hostEntries returns the names in the directory dir of the host.
+func hostEntries(dir string) []string {
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+
+	entries, err := os.ReadDir(filepath.FromSlash(readDir))
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// escapeWord escapes the characters the lexer would split or expand.
+func escapeWord(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(" \t'\"\\$#|&;<>()*?[]{}~`", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescapeWord removes the quotes and escapes of a word being typed. It also
+// returns the quote left open, if any.
+func unescapeWord(s string) (string, rune) {
+	var b strings.Builder
+	quote := rune(0)
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			continue
+		case quote != 0 && r == quote:
+			quote = 0
+			continue
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), quote
+}
@@ -0,0 +1,513 @@
+package fsys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// diffContext is the number of unchanged lines around the changes of a
+// unified diff.
+const diffContext = 3
+
+// diffTimeFormat is the format of the modification times in the headers of
+// a unified diff.
+const diffTimeFormat = "2006-01-02 15:04:05.000000000 -0700"
+
+// diffSide is one side of a diff: a file of the virtual Filesystem, of the
+// host or an object of the intermediate service.
+type diffSide struct {
+	name    string // As given, printed in the headers.
+	data    []byte
+	modTime time.Time // Zero for an object of the intermediate service.
+}
+
+// Diff compares the files a and b line by line. Each may be a path of the
+// virtual Filesystem, a path of the host with the host: prefix or an object
+// of the intermediate service with the remote: prefix, as path or
+// path@version. Evicted files are read from the intermediate service
+// without being loaded.
+func (fs *Filesystem) Diff(ctx context.Context, sess *session.Session, a, b string, unified bool) (DiffResult, error) {
+	result := DiffResult{A: a, B: b}
+
+	sideA, err := fs.readDiffSide(ctx, sess, a)
+	if err != nil {
+		return result, fmt.Errorf("diff: %w", err)
+	}
+	sideB, err := fs.readDiffSide(ctx, sess, b)
+	if err != nil {
+		return result, fmt.Errorf("diff: %w", err)
+	}
+
+	if bytes.Equal(sideA.data, sideB.data) {
+		result.Identical = true
+		return result, nil
+	}
+	if isBinary(sideA.data) || isBinary(sideB.data) {
+		result.Diff = fmt.Sprintf("Binary files %s and %s differ\n", a, b)
+		return result, nil
+	}
+
+	linesA, linesB := splitLines(sideA.data), splitLines(sideB.data)
+	changes := diffLines(linesA, linesB)
+	var out strings.Builder
+	if unified {
+		writeUnified(&out, sideA, sideB, linesA, linesB, changes)
+	} else {
+		writeNormal(&out, linesA, linesB, changes)
+	}
+	result.Diff = out.String()
+	return result, nil
+}
+
+// readDiffSide reads the file compared by diff as given by operand.
+func (fs *Filesystem) readDiffSide(ctx context.Context, sess *session.Session, operand string) (diffSide, error) {
+	side := diffSide{name: operand}
+
+	switch {
+	case strings.HasPrefix(operand, constant.HostPrefix):
+		name := strings.TrimPrefix(operand, constant.HostPrefix)
+		info, err := os.Stat(name)
+		if err != nil {
+			return side, err
+		}
+		if info.IsDir() {
+			return side, fmt.Errorf("%s: Is a directory", operand)
+		}
+		side.modTime = info.ModTime()
+		side.data, err = os.ReadFile(name)
+		return side, err
+
+	case strings.HasPrefix(operand, constant.RemotePrefix):
+		name, version := splitVersion(strings.TrimPrefix(operand, constant.RemotePrefix))
+		// The file must be readable in the virtual Filesystem for its
+		// object to be fetched.
+		f, err := fs.statRanged(ctx, sess, name)
+		if err != nil {
+			return side, err
+		}
+		side.data, err = fetchVersion(ctx, sess, f.path, version)
+		if err != nil {
+			return side, fmt.Errorf("%s: %w", operand, err)
+		}
+		return side, nil
+	}
+
+	name := strings.TrimPrefix(operand, constant.VFSPrefix)
+	f, err := fs.openRanged(ctx, sess, name)
+	if err != nil {
+		return side, err
+	}
+	var buf bytes.Buffer
+	if err := copyRange(&buf, f, 0, f.Size()); err != nil {
+		return side, fmt.Errorf("%s: %w", operand, err)
+	}
+	side.data = buf.Bytes()
+	side.modTime = f.modTime
+	return side, nil
+}
+
+// splitVersion splits a remote object name into its path and its version,
+// which follows the last @ of the base name.
+func splitVersion(name string) (string, string) {
+	i := strings.LastIndexByte(name, '@')
+	if i < 0 || i < strings.LastIndexByte(name, '/') {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
+
+// fetchVersion gets the content of the object of the file at path from the
+// intermediate service: its given version, or the current one when version
+// is empty. The content is not checked against the checksum of the file,
+// which may differ from the remote one.
+func fetchVersion(ctx context.Context, sess *session.Session, path, version string) ([]byte, error) {
+	getFileURL := constant.Protocol + sess.Host() + constant.ApiVer + "/file/object?"
+
+	var param = url.Values{}
+	param.Add("filename", filepath.Clean(path))
+	if version != "" {
+		param.Add("version", version)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getFileURL+param.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("token", sess.Token())
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", constant.ErrRemoteData, resp.Status)
+	}
+
+	fileResp := GetFileResp{}
+	if err := json.NewDecoder(resp.Body).Decode(&fileResp); err != nil {
+		return nil, errors.New("failed to unmarshal file body")
+	}
+	return fileResp.Data, nil
+}
+
+// isBinary tells whether data looks binary, having a NUL byte in its
+// first 8000 bytes as for GNU diff.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// writeNormal writes changes in the normal diff format, such as 2,3c2.
+func writeNormal(w io.Writer, a, b []string, changes []change) {
+	for _, c := range changes {
+		switch {
+		case c.A == c.AEnd:
+			fmt.Fprintf(w, "%da%s\n", c.A, lineRange(c.B, c.BEnd))
+		case c.B == c.BEnd:
+			fmt.Fprintf(w, "%sd%d\n", lineRange(c.A, c.AEnd), c.B)
+		default:
+			fmt.Fprintf(w, "%sc%s\n", lineRange(c.A, c.AEnd), lineRange(c.B, c.BEnd))
+		}
+
+		writeDiffLines(w, "< ", a[c.A:c.AEnd])
+		if c.A != c.AEnd && c.B != c.BEnd {
+			fmt.Fprintln(w, "---")
+		}
+		writeDiffLines(w, "> ", b[c.B:c.BEnd])
+	}
+}
+
+// lineRange returns the lines [start, end) numbered from 1, as n or n,m.
+func lineRange(start, end int) string {
+	if end-start == 1 {
+		return fmt.Sprint(end)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end)
+}
+
+// writeUnified writes changes in the unified format, grouped in hunks with
+// diffContext unchanged lines around them.
+func writeUnified(w io.Writer, sideA, sideB diffSide, a, b []string, changes []change) {
+	fmt.Fprintf(w, "--- %s\n", unifiedHeader(sideA))
+	fmt.Fprintf(w, "+++ %s\n", unifiedHeader(sideB))
+
+	for i := 0; i < len(changes); {
+		// A hunk takes the next changes closer than twice the context.
+		j := i + 1
+		for j < len(changes) && changes[j].A-changes[j-1].AEnd <= 2*diffContext {
+			j++
+		}
+		first, last := changes[i], changes[j-1]
+
+		startA := first.A - diffContext
+		if startA < 0 {
+			startA = 0
+		}
+		endA := last.AEnd + diffContext
+		if endA > len(a) {
+			endA = len(a)
+		}
+		startB := first.B - (first.A - startA)
+		endB := last.BEnd + (endA - last.AEnd)
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(startA, endA), hunkRange(startB, endB))
+		pos := startA
+		for _, c := range changes[i:j] {
+			writeDiffLines(w, " ", a[pos:c.A])
+			writeDiffLines(w, "-", a[c.A:c.AEnd])
+			writeDiffLines(w, "+", b[c.B:c.BEnd])
+			pos = c.AEnd
+		}
+		writeDiffLines(w, " ", a[pos:endA])
+		i = j
+	}
+}
+
+// unifiedHeader returns the name of side followed by its modification time
+// when known.
+func unifiedHeader(side diffSide) string {
+	if side.modTime.IsZero() {
+		return side.name
+	}
+	return side.name + "\t" + side.modTime.Format(diffTimeFormat)
+}
+
+// hunkRange returns the lines [start, end) as written in a hunk header:
+// the first line and the number of lines, omitted when one. An empty range
+// is given by the line before it.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(end)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// writeDiffLines writes lines after prefix, marking a last line without a
+// newline.
+func writeDiffLines(w io.Writer, prefix string, lines []string) {
+	for _, line := range lines {
+		fmt.Fprint(w, prefix, line)
+		if !strings.HasSuffix(line, "\n") {
+			fmt.Fprint(w, "\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// DiffResult is the result of diff.
+type DiffResult struct {
+	A         string `json:"a"`
+	B         string `json:"b"`
+	Identical bool   `json:"identical"`
+	Diff      string `json:"diff,omitempty"` // As written in text.
+}
+
+// WriteText writes the differences, nothing when the files are identical.
+func (r DiffResult) WriteText(w io.Writer) error {
+	_, err := io.WriteString(w, r.Diff)
+	return err
+}
+
+// treeEntry is a file or directory found by diff -r, relative to the root
+// of its tree.
+type treeEntry struct {
+	isDir    bool
+	mode     os.FileMode // The permission bits.
+	size     int64
+	modTime  time.Time
+	checksum string // Known without reading the file for the virtual Filesystem only.
+}
+
+// DiffTree compares the subtrees a and b, of the virtual Filesystem or of
+// the host with the host: prefix. The files only in b are reported as
+// added and the files only in a as removed, so that diff -r dest host:src
+// shows what upload -r src dest would change. Files of the same size are
+// the same when they have the same modification time or hash.
+func (fs *Filesystem) DiffTree(ctx context.Context, sess *session.Session, a, b string) (TreeDiff, error) {
+	result := TreeDiff{A: a, B: b}
+
+	entriesA, err := fs.walkDiffTree(ctx, sess, a)
+	if err != nil {
+		return result, fmt.Errorf("diff: %w", err)
+	}
+	entriesB, err := fs.walkDiffTree(ctx, sess, b)
+	if err != nil {
+		return result, fmt.Errorf("diff: %w", err)
+	}
+
+	paths := make([]string, 0, len(entriesA)+len(entriesB))
+	for rel := range entriesA {
+		paths = append(paths, rel)
+	}
+	for rel := range entriesB {
+		if _, ok := entriesA[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	// The content of a directory added or removed is not reported.
+	skip := ""
+	for _, rel := range paths {
+		if skip != "" && strings.HasPrefix(rel, skip) {
+			continue
+		}
+		skip = ""
+
+		entryA, inA := entriesA[rel]
+		entryB, inB := entriesB[rel]
+		change := TreeChange{Path: rel}
+		switch {
+		case !inA:
+			change.Status = "added"
+		case !inB:
+			change.Status = "removed"
+		case entryA.isDir != entryB.isDir:
+			change.Status, change.Reason = "changed", "type"
+		case entryA.isDir:
+			continue
+		case entryA.size != entryB.size:
+			change.Status, change.Reason = "changed", "size"
+		case entryA.modTime.Equal(entryB.modTime):
+			continue
+		default:
+			same, err := fs.sameContent(ctx, sess, a, b, rel, entryA, entryB)
+			if err != nil {
+				return result, fmt.Errorf("diff: %w", err)
+			}
+			if same {
+				continue
+			}
+			change.Status, change.Reason = "changed", "content"
+		}
+
+		if (!inA && entryB.isDir) || (!inB && entryA.isDir) || change.Reason == "type" {
+			change.Path += "/"
+			skip = change.Path
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	return result, nil
+}
+
+// walkDiffTree returns the entries under root by path relative to it.
+func (fs *Filesystem) walkDiffTree(ctx context.Context, sess *session.Session, root string) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+
+	if strings.HasPrefix(root, constant.RemotePrefix) {
+		return nil, fmt.Errorf("%s: remote objects cannot be compared recursively", root)
+	}
+	if strings.HasPrefix(root, constant.HostPrefix) {
+		dir := strings.TrimPrefix(root, constant.HostPrefix)
+		err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, name)
+			if err != nil || rel == "." {
+				return err
+			}
+			entries[filepath.ToSlash(rel)] = treeEntry{isDir: info.IsDir(), mode: info.Mode().Perm(), size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+		return entries, err
+	}
+
+	name := strings.TrimPrefix(root, constant.VFSPrefix)
+	err := fs.volumeDo(sess)(func() error {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", root, constant.ErrPathNotFound)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: Not a directory", root)
+		}
+		if sess.Role() == "Normal" {
+			if err := fs.requireAccess(sess, name, "r-x"); err != nil {
+				return fmt.Errorf("%s: %w", root, err)
+			}
+		}
+
+		dir := fs.absPath(name)
+		return afero.Walk(fs.MFS, dir, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, name)
+			if err != nil || rel == "." {
+				return err
+			}
+
+			entry := treeEntry{isDir: info.IsDir(), mode: info.Mode().Perm(), size: info.Size(), modTime: info.ModTime()}
+			if !entry.isDir {
+				entry.checksum = fs.MFS.Checksum(name)
+				if entry.size == 0 {
+					// The content of a file not loaded is only known to the cache.
+					entry.size = fs.vol.lru().Sizes[name]
+				}
+			}
+			entries[filepath.ToSlash(rel)] = entry
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// sameContent tells whether the files at rel under the roots a and b have
+// the same hash, reading the files whose hash is not known.
+func (fs *Filesystem) sameContent(ctx context.Context, sess *session.Session, a, b, rel string, entryA, entryB treeEntry) (bool, error) {
+	sumA, err := fs.treeChecksum(ctx, sess, a, rel, entryA)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := fs.treeChecksum(ctx, sess, b, rel, entryB)
+	if err != nil {
+		return false, err
+	}
+	return sumA == sumB, nil
+}
+
+// treeChecksum returns the hash of the file at rel under root.
+func (fs *Filesystem) treeChecksum(ctx context.Context, sess *session.Session, root, rel string, entry treeEntry) (string, error) {
+	if entry.checksum != "" {
+		return entry.checksum, nil
+	}
+
+	if strings.HasPrefix(root, constant.HostPrefix) {
+		f, err := os.Open(filepath.Join(strings.TrimPrefix(root, constant.HostPrefix), filepath.FromSlash(rel)))
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return ChecksumReader(f)
+	}
+
+	f, err := fs.openRanged(ctx, sess, path.Join(strings.TrimPrefix(root, constant.VFSPrefix), rel))
+	if err != nil {
+		return "", err
+	}
+	return ChecksumReader(io.NewSectionReader(f, 0, f.Size()))
+}
+
+// TreeChange is a file or directory differing between the trees compared
+// by diff -r.
+type TreeChange struct {
+	Path   string `json:"path"`             // Relative to the roots, ending with a slash for a directory.
+	Status string `json:"status"`           // "added", "removed" or "changed".
+	Reason string `json:"reason,omitempty"` // For a change: "type", "size" or "content".
+}
+
+// TreeDiff is the result of diff -r.
+type TreeDiff struct {
+	A       string
+	B       string
+	Changes []TreeChange
+}
+
+func (d TreeDiff) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.entries())
+}
+
+func (d TreeDiff) entries() []interface{} {
+	entries := make([]interface{}, 0, len(d.Changes))
+	for _, change := range d.Changes {
+		entries = append(entries, change)
+	}
+	return entries
+}
+
+// WriteText writes a change per line, with its status and its reason.
+func (d TreeDiff) WriteText(w io.Writer) error {
+	for _, change := range d.Changes {
+		if change.Reason != "" {
+			fmt.Fprintf(w, "%-8s %s (%s)\n", change.Status, change.Path, change.Reason)
+		} else {
+			fmt.Fprintf(w, "%-8s %s\n", change.Status, change.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package fsys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// homeRoot is the directory holding the home directories of the users.
+const homeRoot = "/home"
+
+// Home returns the home directory of the session user: $HOME when set in
+// the shell, else /home/<user> when it exists, else the root.
+func (s *Shell) Home(sess *session.Session) string {
+	if home, ok := s.vars["HOME"]; ok && home != "" {
+		return home
+	}
+	if sess != nil {
+		home := path.Join(homeRoot, sess.User.Username)
+		if _, err := s.resolveDir(home); err == nil {
+			return home
+		}
+	}
+	return "/"
+}
+
+// resolveDir returns the directory at dir, relative to the working
+// directory unless absolute.
+func (s *Shell) resolveDir(dir string) (*Filesystem, error) {
+	if dir == "/" {
+		return s.Fs.vol.Root(), nil
+	}
+
+	// Only directories are followed, a file is not found.
+	target, err := s.verifyPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: No such directory", dir)
+	}
+	return target, nil
+}
+
+// enter makes dir the working directory once the session user is allowed to
+// search it, remembering the previous one for cd -.
+func (s *Shell) enter(sess *session.Session, dir string) error {
+	target, err := s.resolveDir(dir)
+	if err != nil {
+		return err
+	}
+	if sess != nil && sess.Role() == "Normal" {
+		if err := s.Fs.requireAccess(sess, dir, "--x"); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	s.oldDir = s.Cwd()
+	s.Fs = target
+	return nil
+}
+
+// Cd changes the working directory: to the home directory without dir, to
+// the previous one with -, else to dir, looked up in the directories of
+// $CDPATH when it is relative. The new directory is written to w when it
+// was not given as is.
+func (s *Shell) Cd(w io.Writer, format Format, sess *session.Session, dir string) error {
+	switch dir {
+	case "":
+		return s.enter(sess, s.Home(sess))
+	case "-":
+		if s.oldDir == "" {
+			return errors.New("cd: OLDPWD not set")
+		}
+		if err := s.enter(sess, s.oldDir); err != nil {
+			return fmt.Errorf("cd: %w", err)
+		}
+		return writeResult(w, format, PathResult{Path: s.Cwd()})
+	}
+
+	if target, ok := s.lookupCdPath(dir); ok {
+		if err := s.enter(sess, target); err != nil {
+			return fmt.Errorf("cd: %w", err)
+		}
+		return writeResult(w, format, PathResult{Path: s.Cwd()})
+	}
+
+	if err := s.enter(sess, dir); err != nil {
+		return fmt.Errorf("cd: %w", err)
+	}
+	return nil
+}
+
+// lookupCdPath returns the first directory called dir under the
+// directories of the shell variable CDPATH, separated by colons. Paths that
+// are absolute or start with . or .. are not looked up, nor dir itself when
+// it exists in the working directory.
+func (s *Shell) lookupCdPath(dir string) (string, bool) {
+	cdPath := s.vars["CDPATH"]
+	if cdPath == "" || strings.HasPrefix(dir, "/") || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return "", false
+	}
+	if _, err := s.resolveDir(dir); err == nil {
+		return "", false
+	}
+
+	for _, base := range strings.Split(cdPath, ":") {
+		if base == "" {
+			continue
+		}
+		target := path.Join(base, dir)
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(s.Cwd(), target)
+		}
+		if _, err := s.resolveDir(target); err == nil {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// Pushd saves the working directory on the stack and changes to dir, or
+// swaps the two top directories without dir.
+func (s *Shell) Pushd(sess *session.Session, dir string) error {
+	cwd := s.Cwd()
+	if dir == "" {
+		if len(s.dirStack) == 0 {
+			return errors.New("pushd: no other directory")
+		}
+		dir = s.dirStack[0]
+		if err := s.enter(sess, dir); err != nil {
+			return fmt.Errorf("pushd: %w", err)
+		}
+		s.dirStack[0] = cwd
+		return nil
+	}
+
+	if err := s.enter(sess, dir); err != nil {
+		return fmt.Errorf("pushd: %w", err)
+	}
+	s.dirStack = append([]string{cwd}, s.dirStack...)
+	return nil
+}
+
+// Popd removes the top directory of the stack and changes to it.
+func (s *Shell) Popd(sess *session.Session) error {
+	if len(s.dirStack) == 0 {
+		return errors.New("popd: directory stack empty")
+	}
+	if err := s.enter(sess, s.dirStack[0]); err != nil {
+		return fmt.Errorf("popd: %w", err)
+	}
+	s.dirStack = s.dirStack[1:]
+	return nil
+}
+
+// ClearDirs empties the directory stack.
+func (s *Shell) ClearDirs() {
+	s.dirStack = nil
+}
+
+// Dirs returns the directory stack, the working directory first.
+func (s *Shell) Dirs(verbose bool) DirStack {
+	return DirStack{Dirs: append([]string{s.Cwd()}, s.dirStack...), Verbose: verbose}
+}
+
+// DirStack is the directory stack printed by dirs, pushd and popd.
+type DirStack struct {
+	Dirs    []string
+	Verbose bool // Print one directory per line with its position.
+}
+
+func (d DirStack) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Dirs)
+}
+
+func (d DirStack) entries() []interface{} {
+	entries := make([]interface{}, len(d.Dirs))
+	for i, dir := range d.Dirs {
+		entries[i] = PathResult{Path: dir}
+	}
+	return entries
+}
+
+func (d DirStack) WriteText(w io.Writer) error {
+	if !d.Verbose {
+		_, err := fmt.Fprintln(w, strings.Join(d.Dirs, " "))
+		return err
+	}
+	for i, dir := range d.Dirs {
+		fmt.Fprintf(w, "%2d  %s\n", i, dir)
+	}
+	return nil
+}
+
+// SetFilesystem moves the shell to the tree of root, such as after a
+// reload, staying in the same working directory, or in its nearest
+// ancestor that still exists. The directory stack is kept as paths.
+func (s *Shell) SetFilesystem(root *Filesystem) {
+	cwd := s.Cwd()
+	s.Fs = root
+	for dir := cwd; dir != "/"; dir = path.Dir(dir) {
+		if target, err := s.resolveDir(dir); err == nil {
+			s.Fs = target
+			return
+		}
+	}
+}
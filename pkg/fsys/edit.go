@@ -0,0 +1,257 @@
+package fsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// defaultEditor is run by edit when neither $VISUAL nor $EDITOR is set.
+const defaultEditor = "vi"
+
+// editSnapshot is a file of the virtual Filesystem as edit last saw it.
+type editSnapshot struct {
+	exists   bool
+	checksum string // The stored checksum, compared to detect a change.
+	data     []byte
+}
+
+// Edit opens the file name in the editor of the shell, or of the host, and
+// writes it back when it was changed, as a replicated write. The file is
+// created when it does not exist. The volume is not held while editing, so
+// the changes made meanwhile by other clients are detected before writing:
+// they can then be merged with the edited content, overwritten or kept.
+func (fs *Filesystem) Edit(ctx context.Context, sess *session.Session, publishing model.Publishing, streams Streams, shell *Shell, name string) error {
+	if !sess.Unlocked {
+		return errors.New("edit: must run alone in the foreground, it would hold the volume")
+	}
+	do := fs.volumeDo(sess)
+
+	var base editSnapshot
+	err := do(func() error {
+		var err error
+		base, err = fs.editSnapshot(ctx, sess, name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("edit: %w", err)
+	}
+
+	// The file is edited in a directory only the user can read, under its
+	// own name so that the editor recognizes its type.
+	dir, err := os.MkdirTemp("", "vfs-edit-")
+	if err != nil {
+		return fmt.Errorf("edit: %w", err)
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	tmp := filepath.Join(dir, path.Base(name))
+	if err := os.WriteFile(tmp, base.data, 0o600); err != nil {
+		return fmt.Errorf("edit: %w", err)
+	}
+
+	original := Checksum(base.data)
+	runEditor := true
+	for {
+		if runEditor {
+			if err := editorCommand(shell, tmp).Run(); err != nil {
+				return fmt.Errorf("edit: %s: %w", name, err)
+			}
+		}
+		runEditor = true
+
+		mine, err := os.ReadFile(tmp)
+		if err != nil {
+			return fmt.Errorf("edit: %w", err)
+		}
+		if Checksum(mine) == original {
+			fmt.Fprintf(streams.Stderr, "edit: %s: no changes\n", name)
+			return nil
+		}
+
+		// The file is written only when nobody changed it since it was read.
+		var theirs editSnapshot
+		written := false
+		err = do(func() error {
+			var err error
+			theirs, err = fs.editSnapshot(ctx, sess, name)
+			if err != nil {
+				return err
+			}
+			if theirs.exists != base.exists || theirs.checksum != base.checksum {
+				return nil
+			}
+			written = true
+			return fs.writeFile(ctx, sess, publishing, name, mine)
+		})
+		if err != nil {
+			return fmt.Errorf("edit: %w", err)
+		}
+		if written {
+			return nil
+		}
+
+		what := "changed"
+		if !theirs.exists {
+			what = "removed"
+		}
+		fmt.Fprintf(streams.Stderr, "edit: %s was %s by another client while editing\n", name, what)
+
+		switch askEditConflict(streams) {
+		case "m":
+			merged, conflicts := merge3(base.data, mine, theirs.data, "edited", "current")
+			if err := os.WriteFile(tmp, merged, 0o600); err != nil {
+				return fmt.Errorf("edit: %w", err)
+			}
+			if conflicts > 0 {
+				fmt.Fprintf(streams.Stderr, "edit: %s: %d conflicts left to resolve\n", name, conflicts)
+			}
+			// The merge is reviewed in the editor before being written.
+			original = Checksum(theirs.data)
+		case "o":
+			runEditor = false
+		default:
+			keep = true
+			return fmt.Errorf("edit: %s: not written, the edited file is kept in %s", name, tmp)
+		}
+		base = theirs
+	}
+}
+
+// editSnapshot returns the content of the file name as edit sees it, after
+// checking that the session user may read and write it. It must run on the
+// volume.
+func (fs *Filesystem) editSnapshot(ctx context.Context, sess *session.Session, name string) (editSnapshot, error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		if sess.Role() == "Normal" {
+			parent := filepath.ToSlash(filepath.Dir(name))
+			if err := fs.requireAccess(sess, parent, "-w-"); err != nil {
+				return editSnapshot{}, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return editSnapshot{}, nil
+	}
+	if info.IsDir() {
+		return editSnapshot{}, fmt.Errorf("%s: Is a directory", name)
+	}
+	if sess.Role() == "Normal" {
+		if err := fs.requireAccess(sess, name, "rw-"); err != nil {
+			return editSnapshot{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	absPath := fs.absPath(name)
+	data, err := afero.ReadFile(fs.MFS, absPath)
+	if err == nil && fs.isEvicted(absPath) {
+		data, err = fs.readContent(ctx, sess, absPath)
+	}
+	if err != nil {
+		return editSnapshot{}, err
+	}
+	return editSnapshot{exists: true, checksum: fs.MFS.Checksum(absPath), data: data}, nil
+}
+
+// writeFile replaces the content of the file name, creating it when
+// needed, as a replicated write.
+func (fs *Filesystem) writeFile(ctx context.Context, sess *session.Session, publishing model.Publishing, name string, data []byte) error {
+	f, err := fs.OpenFile(ctx, sess, publishing, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// askEditConflict asks on the input of the command what to do with a file
+// changed while editing: m to merge, o to overwrite or q to keep the
+// current file.
+func askEditConflict(streams Streams) string {
+	for {
+		fmt.Fprint(streams.Stderr, "[m]erge, [o]verwrite or [q]uit keeping the current file? ")
+		answer, err := readAnswer(streams.Stdin)
+		if err != nil && answer == "" {
+			return "q"
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		switch answer {
+		case "m", "merge":
+			return "m"
+		case "o", "overwrite":
+			return "o"
+		case "q", "quit":
+			return "q"
+		}
+	}
+}
+
+// readAnswer reads a line from r one byte at a time, so that nothing after
+// it is consumed.
+func readAnswer(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// editorCommand returns the command running the editor on file: $VISUAL,
+// else $EDITOR, as set in shell or on the host, else vi. The exported
+// variables of the shell are given to the editor.
+func editorCommand(shell *Shell, file string) *exec.Cmd {
+	lookup := os.LookupEnv
+	env := os.Environ()
+	if shell != nil {
+		lookup = shell.lookup(nil)
+		for name := range shell.exported {
+			env = append(env, name+"="+shell.vars[name])
+		}
+	}
+
+	editor := defaultEditor
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if value, ok := lookup(name); ok && strings.TrimSpace(value) != "" {
+			editor = value
+			break
+		}
+	}
+
+	// The editor may be given with its arguments, such as code --wait.
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	return cmd
+}
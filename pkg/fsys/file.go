@@ -0,0 +1,636 @@
+package fsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/producer"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// maxPendingWrite is the size up to which contiguous writes through a File
+// are coalesced into one replicated range.
+const maxPendingWrite = 1 << 20
+
+// File is an open handle to a file of the virtual Filesystem.
+// Partial writes through the handle are coalesced and replicated as ranged
+// operations, the checksum of the file being sent with the last range on
+// Sync or Close.
+type File struct {
+	afero.File
+	ctx        context.Context
+	sess       *session.Session
+	fs         *Filesystem
+	publishing model.Publishing
+	name       string // The absolute path of the file.
+	flag       int
+
+	pending    []byte // Written but not yet replicated.
+	pendingOff int64
+	truncating bool // Truncated to truncSize but not yet replicated.
+	truncSize  int64
+	dirty      bool // Written or truncated since the checksum was last stored.
+}
+
+// Open opens the named file for reading.
+func (fs *Filesystem) Open(ctx context.Context, sess *session.Session, name string) (*File, error) {
+	return fs.OpenFile(ctx, sess, model.Publishing{}, name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with the specified flag, checking the
+// permissions of the current user on the file.
+func (fs *Filesystem) OpenFile(ctx context.Context, sess *session.Session, publishing model.Publishing, name string, flag int, perm os.FileMode) (*File, error) {
+	absName := fs.absPath(name)
+
+	info, err := fs.Stat(name)
+	if err != nil && flag&os.O_CREATE == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: constant.ErrPathNotFound}
+	}
+	if info != nil && info.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if info != nil && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: constant.ErrAlreadyExists}
+	}
+
+	err = fs.checkOpenAccess(ctx, sess, name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		userState := sess.User
+
+		err = fs.Touch(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		fs.MFS.Chmod(absName, perm.Perm())
+		fs.MFS.Chown(absName, userState.UserID, userState.GroupID)
+		fs.MFS.SetChecksum(absName, Checksum(nil))
+	} else if flag&os.O_TRUNC == 0 && fs.isEvicted(absName) {
+		_, err = fs.readContent(ctx, sess, absName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mf, err := fs.MFS.OpenFile(absName, flag&^(os.O_TRUNC|os.O_CREATE|os.O_EXCL), perm)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		File:       mf,
+		ctx:        ctx,
+		sess:       sess,
+		fs:         fs,
+		publishing: publishing,
+		name:       absName,
+		flag:       flag,
+	}
+
+	if info != nil && flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		err = f.Truncate(0)
+		if err != nil {
+			mf.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// checkOpenAccess verifies that the current user may open the file with flag.
+func (fs *Filesystem) checkOpenAccess(ctx context.Context, sess *session.Session, name string, flag int) error {
+	if sess.Role() != "Normal" {
+		return nil
+	}
+
+	userState := sess.User
+
+	access, err := fs.getAccess(name, userState.UserID, userState.GroupID)
+	if err != nil {
+		return err
+	}
+
+	requiredAccess := "r--"
+	switch {
+	case flag&os.O_RDWR != 0:
+		requiredAccess = "rw-"
+	case flag&os.O_WRONLY != 0:
+		requiredAccess = "-w-"
+	}
+
+	if !checkAccess(access, requiredAccess) {
+		return constant.ErrUnauthorizedAccess
+	}
+	return nil
+}
+
+// isEvicted reports whether the content of a file was evicted from memory:
+// the eviction truncates it but keeps the checksum of its content, which an
+// empty file does not have.
+func (fs *Filesystem) isEvicted(absName string) bool {
+	info, err := fs.MFS.Stat(absName)
+	if err != nil || info.IsDir() || info.Size() > 0 {
+		return false
+	}
+	sum := fs.MFS.Checksum(absName)
+	return sum != "" && sum != Checksum(nil)
+}
+
+// Name returns the absolute path of the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	off, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.addWrite(off, b[:n])
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(b, off)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.addWrite(off, b[:n])
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Truncate changes the size of the file. The truncation is replicated before
+// the next write, or with the checksum of the file by Sync.
+func (f *File) Truncate(size int64) error {
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errors.New("invalid size")}
+	}
+
+	err := f.flushWrites("")
+	if err != nil {
+		return err
+	}
+	err = f.flushTruncate("")
+	if err != nil {
+		return err
+	}
+
+	err = f.File.Truncate(size)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		// an empty file with the checksum of its old content looks evicted
+		f.fs.MFS.SetChecksum(f.name, Checksum(nil))
+	}
+
+	f.dirty = true
+	f.truncating, f.truncSize = true, size
+	return nil
+}
+
+// Sync replicates the pending writes or truncation along with the checksum of
+// the file, which is only computed here so that each write costs its own size.
+func (f *File) Sync() error {
+	if !f.dirty {
+		return nil
+	}
+
+	sum, err := f.updateChecksum()
+	if err != nil {
+		return err
+	}
+	f.dirty = false
+
+	// a write replicates the truncation first, so at most one is pending
+	err = f.flushTruncate(sum)
+	if err != nil {
+		return err
+	}
+	return f.flushWrites(sum)
+}
+
+// Close replicates the pending writes and closes the file.
+func (f *File) Close() error {
+	err := f.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// addWrite adds a written range to the pending one, replicating the pending
+// range first when b does not follow it or it is full.
+func (f *File) addWrite(off int64, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	f.dirty = true
+
+	err := f.flushTruncate("")
+	if err != nil {
+		return err
+	}
+
+	if len(f.pending) > 0 && (off != f.pendingOff+int64(len(f.pending)) || len(f.pending)+len(b) > maxPendingWrite) {
+		err = f.flushWrites("")
+		if err != nil {
+			return err
+		}
+	}
+	if len(f.pending) == 0 {
+		f.pendingOff = off
+	}
+
+	// the caller may reuse b once the write returns
+	f.pending = append(f.pending, b...)
+	return nil
+}
+
+// flushWrites sends the pending range to the other clients and the
+// intermediate service with sum, the checksum of the file once the range is
+// applied, or none when more writes follow, which marks the range as such.
+func (f *File) flushWrites(sum string) error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+
+	b, off := f.pending, f.pendingOff
+	f.pending = nil
+
+	msgSync := pubsub_notify.MessageCommand{
+		Args:     []string{"write-range", "/" + f.name},
+		Offset:   off,
+		Length:   int64(len(b)),
+		Buffer:   b,
+		Checksum: sum,
+		More:     sum == "",
+	}
+	msg := producer.Message{
+		Command:       "write-range",
+		AbsPathSource: f.name,
+		Offset:        off,
+		Length:        int64(len(b)),
+		Buffer:        b,
+		Checksum:      sum,
+	}
+
+	return f.replicate(msgSync, msg)
+}
+
+// flushTruncate sends the pending truncation to the other clients and the
+// intermediate service with sum, as flushWrites does.
+func (f *File) flushTruncate(sum string) error {
+	if !f.truncating {
+		return nil
+	}
+	f.truncating = false
+
+	msgSync := pubsub_notify.MessageCommand{
+		Args:     []string{"truncate", "/" + f.name},
+		Length:   f.truncSize,
+		Checksum: sum,
+		More:     sum == "",
+	}
+	msg := producer.Message{
+		Command:       "truncate",
+		AbsPathSource: f.name,
+		Length:        f.truncSize,
+		Buffer:        []byte{},
+		Checksum:      sum,
+	}
+
+	return f.replicate(msgSync, msg)
+}
+
+// updateChecksum recomputes and stores the checksum of the whole file.
+func (f *File) updateChecksum() (string, error) {
+	data, err := afero.ReadFile(f.fs.MFS, f.name)
+	if err != nil {
+		return "", err
+	}
+
+	sum := Checksum(data)
+	f.fs.MFS.SetChecksum(f.name, sum)
+	return sum, nil
+}
+
+func (f *File) replicate(msgSync pubsub_notify.MessageCommand, msg producer.Message) error {
+	userState := f.sess.User
+	f.fs.vol.Changed()
+
+	info, err := f.fs.MFS.Stat(f.name)
+	if err != nil {
+		return err
+	}
+
+	if f.publishing.PublishSync {
+		pubs := f.sess.Publisher
+		clientID := f.sess.ClientID()
+
+		// Sync to other client
+		msgSync.FileMode = uint64(info.Mode())
+		msgSync.Uid = userState.UserID
+		msgSync.Gid = userState.GroupID
+		msgSync.ClientID = clientID
+
+		err = pubs.Publish(f.ctx, msgSync)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.publishing.PublishIntermediate {
+		token := f.sess.Token()
+
+		msg.Token = token
+		msg.FileMode = uint64(info.Mode())
+		msg.Uid = userState.UserID
+		msg.Gid = userState.GroupID
+
+		f.sess.Producer.Go(f.ctx, msg)
+	}
+
+	return nil
+}
+
+// ApplyRangeSync applies a ranged write or truncate replicated by another client.
+// The checksum it carries is verified unless more of the same write follows,
+// and a message without either is rejected before it is applied.
+func (fs *Filesystem) ApplyRangeSync(ctx context.Context, sess *session.Session, msgCmd pubsub_notify.MessageCommand) error {
+	comms, err := msgCmd.Command()
+	if err != nil || len(comms) < 2 {
+		return fmt.Errorf("%s: malformed command", msgCmd.FullCommand)
+	}
+	destPath := fs.absPath(filepath.ToSlash(filepath.Clean(comms[1])))
+
+	// only the ranges followed by more of the same write carry no checksum
+	if msgCmd.Checksum == "" && !msgCmd.More {
+		return fmt.Errorf("%s: %s: %w", comms[0], destPath, errNoChecksum)
+	}
+
+	if _, err := fs.MFS.Stat(destPath); err != nil {
+		err = fs.Touch(ctx, destPath)
+		if err != nil {
+			return err
+		}
+		fs.MFS.Chmod(destPath, os.FileMode(msgCmd.FileMode))
+		fs.MFS.Chown(destPath, msgCmd.Uid, msgCmd.Gid)
+	} else if fs.isEvicted(destPath) {
+		_, err = fs.readContent(ctx, sess, destPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	destFile, err := fs.MFS.OpenFile(destPath, os.O_RDWR, os.FileMode(msgCmd.FileMode))
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	switch comms[0] {
+	case "write-range":
+		_, err = destFile.WriteAt(msgCmd.Buffer, msgCmd.Offset)
+	case "truncate":
+		err = destFile.Truncate(msgCmd.Length)
+	}
+	if err != nil {
+		return err
+	}
+
+	if msgCmd.More {
+		return nil
+	}
+
+	data, err := afero.ReadFile(fs.MFS, destPath)
+	if err != nil {
+		return err
+	}
+
+	err = verifyChecksum(msgCmd.Checksum, data)
+	if err != nil {
+		// the content diverged, it is fetched again on the next read
+		destFile.Truncate(0)
+		fs.MFS.SetChecksum(destPath, msgCmd.Checksum)
+		return fmt.Errorf("%s: %s: %w", comms[0], destPath, err)
+	}
+	fs.MFS.SetChecksum(destPath, msgCmd.Checksum)
+
+	return nil
+}
+
+// DdOptions are the operands of the dd command.
+type DdOptions struct {
+	Input     string
+	Output    string
+	BlockSize int64
+	Count     int64 // Number of blocks to copy, negative copies until EOF.
+	Skip      int64
+	Seek      int64
+	NoTrunc   bool
+}
+
+// ParseDdOptions parses dd operands of the form key=value.
+func ParseDdOptions(operands []string) (DdOptions, error) {
+	opts := DdOptions{BlockSize: 512, Count: -1}
+	for _, operand := range operands {
+		key, value, found := strings.Cut(operand, "=")
+		if !found {
+			return opts, fmt.Errorf("dd: unrecognized operand '%s'", operand)
+		}
+
+		var err error
+		switch key {
+		case "if":
+			opts.Input = value
+		case "of":
+			opts.Output = value
+		case "bs":
+			opts.BlockSize, err = parseSize(value)
+			if err == nil && opts.BlockSize <= 0 {
+				err = errors.New("invalid block size")
+			}
+		case "count":
+			opts.Count, err = parseSize(value)
+		case "skip":
+			opts.Skip, err = parseSize(value)
+		case "seek":
+			opts.Seek, err = parseSize(value)
+		case "conv":
+			for _, conv := range strings.Split(value, ",") {
+				if conv != "notrunc" {
+					return opts, fmt.Errorf("dd: invalid conversion '%s'", conv)
+				}
+				opts.NoTrunc = true
+			}
+		default:
+			return opts, fmt.Errorf("dd: unrecognized operand '%s'", operand)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("dd: invalid number '%s': %w", value, err)
+		}
+	}
+
+	if opts.Input == "" || opts.Output == "" {
+		return opts, errors.New("dd: both if= and of= must be given")
+	}
+	return opts, nil
+}
+
+// parseSize parses a size with an optional K, M or G suffix.
+func parseSize(size string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(size, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(size, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(size, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// TruncateFile shrinks or extends a file to the given size, creating it if needed.
+// The size may be prefixed with + or - to be relative to the current size.
+func (fs *Filesystem) TruncateFile(ctx context.Context, sess *session.Session, publishing model.Publishing, name, size string) error {
+	relative := 0
+	if strings.HasPrefix(size, "+") {
+		relative = 1
+		size = size[1:]
+	} else if strings.HasPrefix(size, "-") {
+		relative = -1
+		size = size[1:]
+	}
+
+	sz, err := parseSize(size)
+	if err != nil {
+		return fmt.Errorf("truncate: invalid number '%s'", size)
+	}
+
+	f, err := fs.OpenFile(ctx, sess, publishing, name, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("truncate: cannot open '%s' for writing: %w", name, err)
+	}
+	defer f.Close()
+
+	if relative != 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sz = info.Size() + int64(relative)*sz
+		if sz < 0 {
+			sz = 0
+		}
+	}
+
+	return f.Truncate(sz)
+}
+
+// Dd copies blocks from one virtual file to another using ranged reads and
+// writes, writing the number of records copied to stderr.
+func (fs *Filesystem) Dd(ctx context.Context, sess *session.Session, publishing model.Publishing, input, output string, opts DdOptions, stderr io.Writer) error {
+	in, err := fs.Open(ctx, sess, input)
+	if err != nil {
+		return fmt.Errorf("dd: failed to open '%s': %w", input, err)
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(ctx, sess, publishing, output, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("dd: failed to open '%s': %w", output, err)
+	}
+
+	var rec ddRecords
+	if !opts.NoTrunc {
+		err = out.Truncate(opts.Seek * opts.BlockSize)
+	}
+	if err == nil {
+		rec, err = ddCopy(in, out, opts)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "%d+%d records in\n", rec.fullIn, rec.partialIn)
+	fmt.Fprintf(stderr, "%d+%d records out\n", rec.fullOut, rec.partialOut)
+	fmt.Fprintf(stderr, "%d bytes copied\n", rec.copied)
+	return nil
+}
+
+// ddRecords counts the full and partial blocks copied by dd.
+type ddRecords struct {
+	fullIn, partialIn, fullOut, partialOut, copied int64
+}
+
+// ddCopy copies the blocks selected by opts from in to out.
+func ddCopy(in io.ReaderAt, out io.WriterAt, opts DdOptions) (ddRecords, error) {
+	var rec ddRecords
+	buf := make([]byte, opts.BlockSize)
+	for i := int64(0); opts.Count < 0 || i < opts.Count; i++ {
+		n, err := in.ReadAt(buf, (opts.Skip+i)*opts.BlockSize)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return rec, err
+			}
+			break
+		}
+
+		if int64(n) == opts.BlockSize {
+			rec.fullIn++
+		} else {
+			rec.partialIn++
+		}
+
+		written, werr := out.WriteAt(buf[:n], (opts.Seek+i)*opts.BlockSize)
+		if int64(written) == opts.BlockSize {
+			rec.fullOut++
+		} else if written > 0 {
+			rec.partialOut++
+		}
+		rec.copied += int64(written)
+		if werr != nil {
+			return rec, werr
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return rec, nil
+}
@@ -0,0 +1,113 @@
+package fsys_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
+)
+
+func TestFileChecksumOnClose(t *testing.T) {
+	vol, sess := newTestVolume(t)
+	ctx := context.Background()
+	fs := vol.Root()
+
+	tests := []struct {
+		name  string
+		write func(f *fsys.File) error
+		want  string
+	}{
+		{"writes", func(f *fsys.File) error {
+			if _, err := f.WriteString("abc"); err != nil {
+				return err
+			}
+			_, err := f.WriteAt([]byte("xyz"), 10)
+			return err
+		}, "abc\x00\x00\x00\x00\x00\x00\x00xyz"},
+		{"write then truncate", func(f *fsys.File) error {
+			if _, err := f.WriteString("abcdef"); err != nil {
+				return err
+			}
+			return f.Truncate(2)
+		}, "ab"},
+		{"truncate then write", func(f *fsys.File) error {
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			_, err := f.WriteAt([]byte("z"), 1)
+			return err
+		}, "\x00z"},
+		{"truncate only", func(f *fsys.File) error {
+			return f.Truncate(0)
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := fs.OpenFile(ctx, sess, model.Publishing{}, "docs/"+tt.name, os.O_RDWR|os.O_CREATE, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := f.Name()
+			if err := tt.write(f); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := fs.MFS.Checksum(name), fsys.Checksum([]byte(tt.want)); got != want {
+				t.Errorf("checksum: got %s, want %s", got, want)
+			}
+			data, err := afero.ReadFile(fs.MFS, name)
+			if err != nil || string(data) != tt.want {
+				t.Errorf("content: got %q, %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRangeSyncChecksum(t *testing.T) {
+	vol, sess := newTestVolume(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		msg     pubsub_notify.MessageCommand
+		want    string
+		wantErr error
+	}{
+		{"verified", pubsub_notify.MessageCommand{Args: []string{"write-range", "/v"}, Length: 3, Buffer: []byte("abc"), Checksum: fsys.Checksum([]byte("abc"))}, "abc", nil},
+		{"more follows", pubsub_notify.MessageCommand{Args: []string{"write-range", "/m"}, Length: 3, Buffer: []byte("abc"), More: true}, "abc", nil},
+		{"no checksum", pubsub_notify.MessageCommand{Args: []string{"write-range", "/n"}, Length: 3, Buffer: []byte("abc")}, "", os.ErrNotExist},
+		{"truncate without checksum", pubsub_notify.MessageCommand{Args: []string{"truncate", "/t"}, Length: 3}, "", os.ErrNotExist},
+		{"upload without checksum", pubsub_notify.MessageCommand{Args: []string{"upload-sync", "/u"}, Buffer: []byte("abc")}, "", os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.msg.FileMode = 0o644
+			err := applyReplicated(ctx, sess, vol, tt.msg)
+			data, rerr := afero.ReadFile(vol.Root().MFS, strings.TrimPrefix(tt.msg.Args[1], "/"))
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("applied without a checksum")
+				}
+				if !errors.Is(rerr, tt.wantErr) {
+					t.Errorf("file created: %q, %v", data, rerr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rerr != nil || string(data) != tt.want {
+				t.Errorf("got %q, %v, want %q", data, rerr, tt.want)
+			}
+		})
+	}
+}
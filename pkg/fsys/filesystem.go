@@ -3,6 +3,8 @@ package fsys
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,8 +17,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/briandowns/spinner"
-
 	"github.com/marcellof23/vfs-TA/lib/afero"
 	"github.com/marcellof23/vfs-TA/pkg/model"
 	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
@@ -25,11 +25,8 @@ import (
 	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/chunker"
 	"github.com/marcellof23/vfs-TA/pkg/producer"
-)
-
-var (
-	LargeFileConstraint = 50 * 1024 * 1024 // 50MB
-	FileSizeMap         = make(map[string]int64, 0)
+	"github.com/marcellof23/vfs-TA/pkg/progress"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 type file struct {
@@ -48,6 +45,7 @@ type fileDir struct {
 type Filesystem struct {
 	*boot.MemFilesystem
 	*fileDir
+	vol *Volume // The volume this directory belongs to.
 }
 
 type FileInfo struct {
@@ -55,27 +53,25 @@ type FileInfo struct {
 	Uid      int
 	Gid      int
 	IsLoaded bool
+	Checksum string
 }
 
-// Root node.
-var root *Filesystem
-
 // Getter and Setter Functions
 
-// SetFilesystem set newFS to current Filesystem.
-func (fs *Filesystem) SetFilesystem(newFS *Filesystem) {
-	*fs = *newFS
-}
-
 func (fs *Filesystem) GetRootPath() string {
 	return fs.rootPath
 }
 
+// Volume returns the volume this directory belongs to.
+func (fs *Filesystem) Volume() *Volume {
+	return fs.vol
+}
+
 // Filesystem Library
 
-// Pwd prints pwd() the current working directory.
-func (fs *Filesystem) Pwd() {
-	fmt.Println(fs.rootPath)
+// Pwd returns the current working directory.
+func (fs *Filesystem) Pwd() PathResult {
+	return PathResult{Path: fs.rootPath}
 }
 
 // Stat gracefully ends the current session.
@@ -91,18 +87,24 @@ func (fs *Filesystem) Stat(filename string) (*FileInfo, error) {
 		IsLoaded: fs.MFS.IsLoaded(path),
 		Uid:      fs.MFS.Uid(path),
 		Gid:      fs.MFS.Gid(path),
+		Checksum: fs.MFS.Checksum(path),
 	}
 
 	return fileInfo, nil
 }
 
 // UploadSyncFile uploads a file to the virtual Filesystem.
-func (fs *Filesystem) UploadSyncFile(ctx context.Context, msgCmd pubsub_notify.MessageCommand) error {
-	comms := strings.Split(msgCmd.FullCommand, " ")
+func (fs *Filesystem) UploadSyncFile(ctx context.Context, sess *session.Session, msgCmd pubsub_notify.MessageCommand) error {
+	comms, err := msgCmd.Command()
+	if err != nil || len(comms) < 2 {
+		return fmt.Errorf("upload-sync: malformed command %q", msgCmd.FullCommand)
+	}
 	destPath := comms[1]
-	userState, err := GetUserStateFromContext(ctx)
+	userState := sess.User
+
+	err = verifyChecksum(msgCmd.Checksum, msgCmd.Buffer)
 	if err != nil {
-		return err
+		return fmt.Errorf("upload-sync: %s: %w", destPath, err)
 	}
 
 	fs.MFS.MkdirAll(destPath, 0o775)
@@ -112,28 +114,19 @@ func (fs *Filesystem) UploadSyncFile(ctx context.Context, msgCmd pubsub_notify.M
 	fs.MFS.Chown(filepath.Clean(destPath), userState.UserID, userState.GroupID)
 
 	fileSz := int64(len(msgCmd.Buffer))
-	if fileSz <= int64(LargeFileConstraint) {
+	if fileSz <= fs.vol.opts.LargeFileConstraint {
 		destFile.Truncate(fileSz)
 		destFile.Write(msgCmd.Buffer)
 	}
+	fs.MFS.SetChecksum(destFile.Name(), msgCmd.Checksum)
 
 	return nil
 }
 
 // UploadFile uploads a file to the virtual Filesystem.
-func (fs *Filesystem) UploadFile(ctx context.Context, publishing model.Publishing, sourcePath, destPath string) error {
-	s := spinner.New(spinner.CharSets[14], 150*time.Millisecond) // Build our new spinner
-	s.Start()
-	s.Suffix = fmt.Sprintf(" Uploading in progress...") // Start the spinner
-	defer func() {
-		s.Stop()
-	}()
-
+func (fs *Filesystem) UploadFile(ctx context.Context, sess *session.Session, publishing model.Publishing, sourcePath, destPath string) error {
 	destFS, _ := fs.searchFS2(destPath)
-	userState, err := GetUserStateFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	userState := sess.User
 
 	fl, err := os.Stat(sourcePath)
 	if err != nil {
@@ -147,37 +140,30 @@ func (fs *Filesystem) UploadFile(ctx context.Context, publishing model.Publishin
 
 	dat, _ := os.ReadFile(sourcePath)
 	mode := fl.Mode()
+	sum := Checksum(dat)
 
 	fs.Touch(ctx, destPath)
 	destFile, _ := fs.MFS.OpenFile(destPath, os.O_RDWR|os.O_CREATE, fl.Mode())
 	fs.MFS.Chmod(filepath.Clean(destPath), mode.Perm())
 	fs.MFS.Chown(filepath.Clean(destPath), userState.UserID, userState.GroupID)
+	fs.MFS.SetChecksum(destFile.Name(), sum)
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	token := sess.Token()
 
 	if publishing.PublishSync {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
-
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		absDestPath := fs.absPath(destPath)
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
-			FullCommand: fmt.Sprintf("%s %s", "upload-sync", "/"+absDestPath),
-			Buffer:      dat,
-			FileMode:    uint64(mode),
-			Uid:         userState.UserID,
-			Gid:         userState.GroupID,
-			ClientID:    clientID,
+			Args:     []string{"upload-sync", "/" + absDestPath},
+			Buffer:   dat,
+			FileMode: uint64(mode),
+			Uid:      userState.UserID,
+			Gid:      userState.GroupID,
+			Checksum: sum,
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
@@ -196,70 +182,85 @@ func (fs *Filesystem) UploadFile(ctx context.Context, publishing model.Publishin
 			Buffer:        []byte{},
 			Uid:           userState.UserID,
 			Gid:           userState.GroupID,
+			Checksum:      sum,
 		}
 
-		if fl.Size() <= int64(LargeFileConstraint) {
+		if fl.Size() <= fs.vol.opts.LargeFileConstraint {
 			destFile.Truncate(fl.Size())
 			destFile.Write(dat)
 
 			msg.Buffer = dat
 
-			r := producer.Retry(producer.ProduceCommand, 3e9)
-			go r(ctx, msg)
+			task := sess.Progress.Start("upload", sourcePath, fl.Size())
+			sess.Producer.GoAcked(ctx, msg, ackTask(task, fl.Size()))
 		} else {
-			producer.ProduceCommand(ctx, msg)
-
 			fileChunker := chunker.FileChunk{
 				Ctx:           ctx,
+				Producer:      sess.Producer,
 				Command:       "write",
 				Token:         token,
 				AbsPathSource: destFile.Name(),
 				AbsPathDest:   destFS.rootPath,
 				Uid:           userState.UserID,
 				Gid:           userState.GroupID,
+				Checksum:      sum,
 			}
 
-			err := fileChunker.Process(sourceFile)
-			if err != nil {
+			// Producing the chunks does not need the volume.
+			return detach(sess, func() error {
+				chunkFile, err := os.Open(sourcePath)
+				if err != nil {
+					return fmt.Errorf("file %s cannot be opened", sourcePath)
+				}
+				defer chunkFile.Close()
+
+				task := sess.Progress.Start("upload", sourcePath, 0)
+				fileChunker.Progress = task
+				sess.Producer.ProduceCommand(ctx, msg)
+				err = fileChunker.Process(chunkFile)
+				task.Finish(err)
 				return err
-			}
+			})
 		}
 	}
 
 	return nil
 }
 
-// UploadDir uploads a file to the virtual Filesystem.
-func (fs *Filesystem) UploadDir(ctx context.Context, publishing model.Publishing, sourcePath, destPath string) error {
-	s := spinner.New(spinner.CharSets[14], 150*time.Millisecond) // Build our new spinner
-	s.Start()
-	s.Suffix = fmt.Sprintf(" Uploading in progress...") // Start the spinner
-	defer func() {
-		s.Stop()
-	}()
+// ackTask returns the callback finishing task, the upload of a file of
+// size bytes produced as a single message, once the broker acknowledged it.
+func ackTask(task *progress.Task, size int64) func(error) {
+	task.SetChunks(1)
+	task.ChunkSent()
+	return func(err error) {
+		if err == nil {
+			task.ChunkAcked(size)
+		}
+		task.Finish(err)
+	}
+}
 
+// UploadDir uploads a file to the virtual Filesystem.
+func (fs *Filesystem) UploadDir(ctx context.Context, sess *session.Session, publishing model.Publishing, sourcePath, destPath string) error {
 	fsDest, _ := fs.searchFS(destPath)
 
-	userState, err := GetUserStateFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	userState := sess.User
 
 	destPathBase := filepath.Base(destPath)
-	fsDest.MkDir(ctx, publishing, destPathBase)
+	fsDest.MkDir(ctx, sess, publishing, destPathBase)
 
 	dir, _ := os.Stat(sourcePath)
 	fsDest.MFS.Chmod(destPathBase, dir.Mode())
 	fsDest.MFS.Chown(destPathBase, userState.UserID, userState.GroupID)
 	fsDest = fsDest.directories[destPathBase]
 
-	copyFilesystem(ctx, publishing, ".", sourcePath, fs.absPath(destPath), fsDest)
+	copyFilesystem(ctx, sess, publishing, ".", sourcePath, fs.absPath(destPath), fsDest)
 	return nil
 }
 
 func (fs *Filesystem) Touch(ctx context.Context, filename string) error {
 	filename = fs.absPath(filename)
-	currFs := root
+	currFs := fs.vol.Root()
 	segments := strings.Split(filename, "/")
 	for idx, segment := range segments {
 		dirExist := fs.doesDirExistRelativePath(segment, currFs)
@@ -302,20 +303,14 @@ func (fs *Filesystem) Touch(ctx context.Context, filename string) error {
 }
 
 // MkDir makes a virtual directory.
-func (fs *Filesystem) MkDir(ctx context.Context, publishing model.Publishing, dirName string) error {
+func (fs *Filesystem) MkDir(ctx context.Context, sess *session.Session, publishing model.Publishing, dirName string) error {
 	dirName = fs.absPath(dirName)
-	currFs := root
+	currFs := fs.vol.Root()
 	segments := strings.Split(dirName, "/")
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	token := sess.Token()
 
-	userState, err := GetUserStateFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	userState := sess.User
 
 	for idx, segment := range segments {
 		dirExist := fs.doesDirExistRelativePath(segment, currFs)
@@ -354,24 +349,17 @@ func (fs *Filesystem) MkDir(ctx context.Context, publishing model.Publishing, di
 				prev:        currFs,
 			}
 
-			currFs.directories[segment] = &Filesystem{currFs.MemFilesystem, newDir}
+			currFs.directories[segment] = &Filesystem{currFs.MemFilesystem, newDir, currFs.vol}
 			currFs = currFs.directories[segment]
 
 			if publishing.PublishSync {
-				pubs, err := GetPublisherFromContext(ctx)
-				if err != nil {
-					return err
-				}
-
-				clientID, err := GetClientIDFromContext(ctx)
-				if err != nil {
-					return err
-				}
+				pubs := sess.Publisher
+				clientID := sess.ClientID()
 
 				// Sync to other client
 				msgSync := pubsub_notify.MessageCommand{
-					FullCommand: fmt.Sprintf("%s %s", "mkdir", "/"+dirName),
-					ClientID:    clientID,
+					Args:     []string{"mkdir", "/" + dirName},
+					ClientID: clientID,
 				}
 
 				err = pubs.Publish(ctx, msgSync)
@@ -389,8 +377,7 @@ func (fs *Filesystem) MkDir(ctx context.Context, publishing model.Publishing, di
 					Gid:           userState.GroupID,
 				}
 
-				r := producer.Retry(producer.ProduceCommand, 3e9)
-				go r(ctx, msg)
+				sess.Producer.Go(ctx, msg)
 			}
 		}
 	}
@@ -399,7 +386,7 @@ func (fs *Filesystem) MkDir(ctx context.Context, publishing model.Publishing, di
 }
 
 // RemoveFile removes a File from the virtual Filesystem.
-func (fs *Filesystem) RemoveFile(ctx context.Context, publishing model.Publishing, filename string) error {
+func (fs *Filesystem) RemoveFile(ctx context.Context, sess *session.Session, publishing model.Publishing, filename string) error {
 	absFilename := fs.absPath(filename)
 
 	info, err := fs.Stat(filename)
@@ -425,28 +412,18 @@ func (fs *Filesystem) RemoveFile(ctx context.Context, publishing model.Publishin
 	}
 	delete(fsTarget.files, baseFilename)
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	token := sess.Token()
 
 	if publishing.PublishSync {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
-
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		fname := filepath.ToSlash("/" + absFilename)
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
 
-			FullCommand: fmt.Sprintf("%s %s", "rm", fname),
-			ClientID:    clientID,
+			Args:     []string{"rm", fname},
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
@@ -465,15 +442,14 @@ func (fs *Filesystem) RemoveFile(ctx context.Context, publishing model.Publishin
 			Buffer:        []byte{},
 		}
 
-		r := producer.Retry(producer.ProduceCommand, 3e9)
-		go r(ctx, msg)
+		sess.Producer.Go(ctx, msg)
 	}
 
 	return nil
 }
 
 // RemoveDir removes a directory from the virtual Filesystem.
-func (fs *Filesystem) RemoveDir(ctx context.Context, publishing model.Publishing, dirname string) error {
+func (fs *Filesystem) RemoveDir(ctx context.Context, sess *session.Session, publishing model.Publishing, dirname string) error {
 	fsTarget, _ := fs.searchFS(dirname)
 	dirname = fs.absPath(dirname)
 	_, err := fs.Stat(dirname)
@@ -484,7 +460,7 @@ func (fs *Filesystem) RemoveDir(ctx context.Context, publishing model.Publishing
 	walkFn := func(rootpath, path string, fss *Filesystem, err error) error {
 		publishing2 := publishing
 		publishing2.PublishSync = false
-		fs.RemoveFile(ctx, publishing2, filepath.ToSlash(filepath.Join(rootpath, path)))
+		fs.RemoveFile(ctx, sess, publishing2, filepath.ToSlash(filepath.Join(rootpath, path)))
 		return nil
 	}
 
@@ -502,28 +478,18 @@ func (fs *Filesystem) RemoveDir(ctx context.Context, publishing model.Publishing
 		return err
 	}
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	token := sess.Token()
 
 	if publishing.PublishSync {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
-
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		dname := filepath.ToSlash("/" + dirname)
 
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
-			FullCommand: fmt.Sprintf("%s %s", "rm -r", dname),
-			ClientID:    clientID,
+			Args:     []string{"rm", "-r", dname},
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
@@ -541,15 +507,14 @@ func (fs *Filesystem) RemoveDir(ctx context.Context, publishing model.Publishing
 			Buffer:        []byte{},
 		}
 
-		r := producer.Retry(producer.ProduceCommand, 3e9)
-		go r(ctx, msg)
+		sess.Producer.Go(ctx, msg)
 	}
 
 	return nil
 }
 
 // CopyFile copy a file from source to destination on the virtual Filesystem.
-func (fs *Filesystem) CopyFile(ctx context.Context, publishing model.Publishing, pathSource, pathDest string) error {
+func (fs *Filesystem) CopyFile(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) error {
 	flSource, err := fs.Stat(pathSource)
 	if err != nil {
 		return errors.New("cp: no such file or directory")
@@ -571,31 +536,24 @@ func (fs *Filesystem) CopyFile(ctx context.Context, publishing model.Publishing,
 	sourceFile.Read(b)
 	destFile.Write(b)
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
+	sum := fs.MFS.Checksum(pathSourceFileName)
+	if sum == "" {
+		sum = Checksum(b)
 	}
+	fs.MFS.SetChecksum(pathTargetFileName, sum)
 
-	userState, err := GetUserStateFromContext(ctx)
-	if err != nil {
-		return err
-	}
+	token := sess.Token()
 
-	if publishing.PublishSync {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
+	userState := sess.User
 
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+	if publishing.PublishSync {
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
-			FullCommand: fmt.Sprintf("%s %s %s", "cp", "/"+pathSourceFileName, "/"+pathTargetFileName),
-			ClientID:    clientID,
+			Args:     []string{"cp", "/" + pathSourceFileName, "/" + pathTargetFileName},
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
@@ -614,17 +572,17 @@ func (fs *Filesystem) CopyFile(ctx context.Context, publishing model.Publishing,
 			FileMode:      uint64(flSource.Mode()),
 			Uid:           userState.UserID,
 			Gid:           userState.UserID,
+			Checksum:      sum,
 		}
 
-		r := producer.Retry(producer.ProduceCommand, 3e9)
-		go r(ctx, msg)
+		sess.Producer.Go(ctx, msg)
 	}
 
 	return nil
 }
 
 // CopyDir copy a file from source to destination on the virtual Filesystem.
-func (fs *Filesystem) CopyDir(ctx context.Context, publishing model.Publishing, pathSource, pathDest string) error {
+func (fs *Filesystem) CopyDir(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) error {
 	fsSource, _ := fs.searchFS(pathSource)
 	fsDest, _ := fs.searchFS(pathDest)
 
@@ -641,7 +599,7 @@ func (fs *Filesystem) CopyDir(ctx context.Context, publishing model.Publishing,
 			remainingPath := filepath.ToSlash(filepath.Join(splitPaths...))
 
 			newDir := filepath.ToSlash(filepath.Join(pathDest, remainingPath))
-			fsDest.MkDir(ctx, publishing, newDir)
+			fsDest.MkDir(ctx, sess, publishing, newDir)
 		} else {
 			splitPaths := strings.Split(rootPath, "/")
 			splitPaths = splitPaths[1:]
@@ -650,7 +608,7 @@ func (fs *Filesystem) CopyDir(ctx context.Context, publishing model.Publishing,
 			newFile := filepath.ToSlash(filepath.Join(pathDest, remainingPath, path))
 			publishing2 := publishing
 			publishing2.PublishSync = false
-			fs.CopyFile(ctx, publishing2, filepath.ToSlash(filepath.Join(rootPath, path)), newFile)
+			fs.CopyFile(ctx, sess, publishing2, filepath.ToSlash(filepath.Join(rootPath, path)), newFile)
 		}
 		return nil
 	}
@@ -661,22 +619,15 @@ func (fs *Filesystem) CopyDir(ctx context.Context, publishing model.Publishing,
 	}
 
 	if publishing.PublishSync {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
-
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		absPathSource := fs.absPath(pathSource)
 		absPathDest := fs.absPath(pathDest)
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
-			FullCommand: fmt.Sprintf("%s %s %s", "cp -r", "/"+absPathSource, "/"+absPathDest),
-			ClientID:    clientID,
+			Args:     []string{"cp", "-r", "/" + absPathSource, "/" + absPathDest},
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
@@ -689,7 +640,7 @@ func (fs *Filesystem) CopyDir(ctx context.Context, publishing model.Publishing,
 }
 
 // Move copy a file from source to destination on the virtual Filesystem.
-func (fs *Filesystem) Move(ctx context.Context, publishing model.Publishing, pathSource, pathDest string) error {
+func (fs *Filesystem) Move(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) error {
 	pathSource = filepath.Base(pathSource)
 	pathDest = filepath.Base(pathDest)
 
@@ -704,41 +655,95 @@ func (fs *Filesystem) Move(ctx context.Context, publishing model.Publishing, pat
 	}
 
 	if !fileSource.IsDir() {
-		err = fs.CopyFile(ctx, publishing, pathSource, pathDest)
+		err = fs.CopyFile(ctx, sess, publishing, pathSource, pathDest)
 		if err != nil {
 			return errors.New("file or Directory does not exist")
 		}
-		fs.RemoveFile(ctx, publishing, pathSource)
+		fs.RemoveFile(ctx, sess, publishing, pathSource)
 	} else {
-		err = fs.CopyDir(ctx, publishing, pathSource, pathDest)
+		err = fs.CopyDir(ctx, sess, publishing, pathSource, pathDest)
 		if err != nil {
 			return errors.New("file or Directory does not exist")
 		}
-		fs.RemoveDir(ctx, publishing, pathSource)
+		fs.RemoveDir(ctx, sess, publishing, pathSource)
 	}
 
 	return nil
 }
 
-// ListDir lists a directory's contents.
-func (fs *Filesystem) ListDir() {
-	if len(fs.files) > 0 {
-		files := SortFiles(fs.files)
-		for _, file := range files {
-			fmt.Println(file)
+// ListDir lists a directory's contents, the files first, each group sorted
+// by name. The details of the entries are filled when long is set.
+func (fs *Filesystem) ListDir(long bool) Listing {
+	listing := Listing{Long: long}
+	for _, name := range SortFiles(fs.files) {
+		listing.Entries = append(listing.Entries, fs.listEntry(name, "file"))
+	}
+	for _, name := range SortDirs(fs.directories) {
+		listing.Entries = append(listing.Entries, fs.listEntry(name, "directory"))
+	}
+	return listing
+}
+
+// listEntry returns the entry of ls for the file or directory name.
+func (fs *Filesystem) listEntry(name, tipe string) Entry {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return Entry{Name: name, Path: fs.entryPath(name), Type: tipe}
+	}
+	return fs.Entry(info, name)
+}
+
+// List lists the directory name like ListDir, for the users allowed to read
+// and search it. A file is listed alone, as by ls.
+func (fs *Filesystem) List(sess *session.Session, name string, long bool) (Listing, error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return Listing{}, fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
+	}
+	if !info.IsDir() {
+		entry, err := fs.StatEntry(sess, name)
+		if err != nil {
+			return Listing{}, err
+		}
+		return Listing{Entries: []Entry{entry}, Long: long}, nil
+	}
+
+	if sess.Role() == "Normal" {
+		if err := fs.requireAccess(sess, name, "r-x"); err != nil {
+			return Listing{}, fmt.Errorf("%s: %w", name, err)
 		}
 	}
 
-	if len(fs.directories) > 0 {
-		directories := SortDirs(fs.directories)
-		for _, dirName := range directories {
-			coloredDir := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorBlue, dirName)
-			fmt.Println(coloredDir)
+	dir, err := fs.verifyPath(name)
+	if err != nil {
+		return Listing{}, fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
+	}
+	return dir.ListDir(long), nil
+}
+
+// StatEntry describes the file or directory name to the session user, who
+// must be able to search its parent. The checksum of a file is only given
+// to a user who may read it.
+func (fs *Filesystem) StatEntry(sess *session.Session, name string) (Entry, error) {
+	if sess.Role() == "Normal" {
+		parent := filepath.ToSlash(filepath.Dir(name))
+		if err := fs.requireAccess(sess, parent, "--x"); err != nil {
+			return Entry{}, fmt.Errorf("%s: %w", name, err)
 		}
 	}
+
+	info, err := fs.Stat(name)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
+	}
+	entry := fs.Entry(info, name)
+	if sess.Role() == "Normal" && fs.requireAccess(sess, name, "r--") != nil {
+		entry.Checksum = ""
+	}
+	return entry, nil
 }
 
-func (fs *Filesystem) Chmod(ctx context.Context, publish bool, perm, name string) error {
+func (fs *Filesystem) Chmod(ctx context.Context, sess *session.Session, publishing model.Publishing, name, perm string) error {
 	absName := fs.absPath(name)
 	_, err := fs.verifyPath(name)
 	if err != nil {
@@ -754,33 +759,25 @@ func (fs *Filesystem) Chmod(ctx context.Context, publish bool, perm, name string
 		return errors.New(err.Error())
 	}
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
-
-	if publish {
-		pubs, err := GetPublisherFromContext(ctx)
-		if err != nil {
-			return err
-		}
+	token := sess.Token()
 
-		clientID, err := GetClientIDFromContext(ctx)
-		if err != nil {
-			return err
-		}
+	if publishing.PublishSync {
+		pubs := sess.Publisher
+		clientID := sess.ClientID()
 
 		// Sync to other client
 		msgSync := pubsub_notify.MessageCommand{
-			FullCommand: fmt.Sprintf("%s %s %s", "chmod", perm, "/"+absName),
-			ClientID:    clientID,
+			Args:     []string{"chmod", perm, "/" + absName},
+			ClientID: clientID,
 		}
 
 		err = pubs.Publish(ctx, msgSync)
 		if err != nil {
 			return err
 		}
+	}
 
+	if publishing.PublishIntermediate {
 		msg := producer.Message{
 			Command:       "chmod",
 			Token:         token,
@@ -790,10 +787,32 @@ func (fs *Filesystem) Chmod(ctx context.Context, publish bool, perm, name string
 			Buffer:        []byte{},
 		}
 
-		r := producer.Retry(producer.ProduceCommand, 3e9)
-		go r(ctx, msg)
+		sess.Producer.Go(ctx, msg)
+	}
+
+	return nil
+}
+
+// Chtimes sets the modification time of the file name, replicated to the
+// other clients so that sync compares the same times everywhere.
+func (fs *Filesystem) Chtimes(ctx context.Context, sess *session.Session, publishing model.Publishing, name string, mtime time.Time) error {
+	absName := fs.absPath(name)
+	if _, err := fs.Stat(name); err != nil {
+		return fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
 	}
 
+	err := fs.MFS.Chtimes(absName, mtime, mtime)
+	if err != nil {
+		return err
+	}
+
+	if publishing.PublishSync {
+		msgSync := pubsub_notify.MessageCommand{
+			Args:     []string{"chtimes", mtime.Format(time.RFC3339Nano), "/" + absName},
+			ClientID: sess.ClientID(),
+		}
+		return sess.Publisher.Publish(ctx, msgSync)
+	}
 	return nil
 }
 
@@ -803,60 +822,30 @@ type GetFileResp struct {
 	Data    []byte `json:"data"`
 }
 
-func (fs *Filesystem) Cat(ctx context.Context, publishing model.Publishing, path string) error {
+func (fs *Filesystem) Cat(ctx context.Context, sess *session.Session, publishing model.Publishing, w io.Writer, path string) error {
 	path = fs.absPath(path)
 	data, err := afero.ReadFile(fs.MFS, path)
 	if err != nil {
 		return err
 	}
 
-	dep, ok := ctx.Value("dependency").(*boot.Dependencies)
-	if !ok {
-		return errors.New("failed to get dependency from context")
-	}
-
 	if len(data) == 0 {
-		token, err := GetTokenFromContext(ctx)
+		data, err = fs.fetchObject(ctx, sess, path)
 		if err != nil {
 			return err
 		}
 
-		filename := filepath.Clean(path)
-		getFileURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/file/object?"
-
-		client := http.Client{}
-		var param = url.Values{}
-		param.Add("filename", filename)
-
-		req, err := http.NewRequest(http.MethodGet, getFileURL+param.Encode(), nil)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("token", token)
-
-		resp, err := client.Do(req)
-		if err != nil || resp.StatusCode != 200 {
-			return err
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return errors.New("failed to get file data from remote")
-		}
-		fileResp := GetFileResp{}
-		err = json.Unmarshal(body, &fileResp)
-		if err != nil {
-			return errors.New("failed to unmarshal file body")
-		}
-
-		LruCache.Put(path, int64(len(fileResp.Data)), fileResp.Data, fs)
-		fmt.Print(string(fileResp.Data))
+		fs.vol.lru().Put(path, int64(len(data)), data, fs)
+	}
 
-	} else {
-		fmt.Println(string(data))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
-func (fs *Filesystem) DownloadFile(ctx context.Context, publish bool, pathSource, pathDest string) error {
+func (fs *Filesystem) DownloadFile(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) error {
 	pathSource = fs.absPath(pathSource)
 
 	_, err := fs.Stat(pathSource)
@@ -869,60 +858,77 @@ func (fs *Filesystem) DownloadFile(ctx context.Context, publish bool, pathSource
 		return err
 	}
 
-	f, err := os.Create(pathDest)
-	if err != nil {
-		return err
-	}
-
-	LruCache.Get(pathSource)
-
-	defer f.Close()
+	fs.vol.lru().Get(pathSource)
 
 	if len(data) == 0 {
-		token, err := GetTokenFromContext(ctx)
-		if err != nil {
-			return err
-		}
+		token := sess.Token()
 
-		dep, ok := ctx.Value("dependency").(*boot.Dependencies)
-		if !ok {
-			return errors.New("failed to get dependency from context")
-		}
+		dep := sess.Dep
 
 		filename := filepath.Clean(pathSource)
 		getFileURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/file/object?"
+		expected := fs.MFS.Checksum(pathSource)
 
-		client := http.Client{}
-		var param = url.Values{}
-		param.Add("filename", filename)
+		// The transfer does not need the volume.
+		return detach(sess, func() error {
+			f, err := os.Create(pathDest)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
 
-		req, err := http.NewRequest(http.MethodGet, getFileURL+param.Encode(), nil)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("token", token)
+			client := http.Client{}
+			var param = url.Values{}
+			param.Add("filename", filename)
 
-		resp, err := client.Do(req)
-		if err != nil || resp.StatusCode != 200 {
-			return err
-		}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, getFileURL+param.Encode(), nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("token", token)
 
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			fmt.Printf("Error downloading file: %s\n", err.Error())
-		}
+			resp, err := client.Do(req)
+			if err != nil {
+				return fmt.Errorf("download : %s: %w", pathSource, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				os.Remove(pathDest)
+				return fmt.Errorf("download : %s: %s", pathSource, resp.Status)
+			}
 
-		fmt.Println("File downloaded successfully.")
-	} else {
-		_, err = f.Write(data)
+			task := sess.Progress.Start("download", pathSource, resp.ContentLength)
+			h := sha256.New()
+			_, err = io.Copy(io.MultiWriter(f, h, task), resp.Body)
+			if err != nil {
+				task.Finish(err)
+				return fmt.Errorf("download : %s: %w", pathSource, err)
+			}
 
-		if err != nil {
-			return err
-		}
+			if actual := hex.EncodeToString(h.Sum(nil)); expected != "" && actual != expected {
+				os.Remove(pathDest)
+				err = fmt.Errorf("download : %s: %w", pathSource, constant.ErrChecksumMismatch)
+				task.Finish(err)
+				return err
+			}
+
+			task.Finish(nil)
+			return nil
+		})
 	}
 
-	return nil
+	f, err := os.Create(pathDest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
 }
 
-func (fs *Filesystem) DownloadRecursive(ctx context.Context, publish bool, pathSource, pathDest string) error {
+func (fs *Filesystem) DownloadRecursive(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) error {
 	fsSource, _ := fs.searchFS(pathSource)
 
 	_, err := fs.Stat(pathSource)
@@ -957,14 +963,8 @@ func (fs *Filesystem) DownloadRecursive(ctx context.Context, publish bool, pathS
 			stat, _ := sourceFile.Stat()
 
 			if stat.Size() == 0 {
-				token, err := GetTokenFromContext(ctx)
-				if err != nil {
-					return err
-				}
-				dep, ok := ctx.Value("dependency").(*boot.Dependencies)
-				if !ok {
-					return errors.New("failed to get dependency from context")
-				}
+				token := sess.Token()
+				dep := sess.Dep
 
 				filename := filepath.Clean(pathSource)
 				getFileURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/file/object?"
@@ -974,17 +974,26 @@ func (fs *Filesystem) DownloadRecursive(ctx context.Context, publish bool, pathS
 				param.Add("filename", filename)
 
 				req, err := http.NewRequest(http.MethodGet, getFileURL+param.Encode(), nil)
+				if err != nil {
+					return err
+				}
 				req.Header.Set("Content-Type", "application/json")
 				req.Header.Set("token", token)
 
 				resp, err := client.Do(req)
-				if err != nil || resp.StatusCode != 200 {
-					return err
+				if err != nil {
+					return fmt.Errorf("download : %s: %w", pathSourceFileName, err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					return fmt.Errorf("download : %s: %s", pathSourceFileName, resp.Status)
 				}
 
-				_, err = io.Copy(f, resp.Body)
+				task := sess.Progress.Start("download", pathSourceFileName, resp.ContentLength)
+				_, err = io.Copy(io.MultiWriter(f, task), resp.Body)
+				task.Finish(err)
 				if err != nil {
-					fmt.Printf("Error downloading file: %s\n", err.Error())
+					return fmt.Errorf("download : %s: %w", pathSourceFileName, err)
 				}
 			} else {
 				b := make([]byte, stat.Size())
@@ -1009,39 +1018,51 @@ type MigrateResp struct {
 	Error   string `json:"error"`
 }
 
-func (fs *Filesystem) Migrate(ctx context.Context, publish bool, pathSource, pathDest string) error {
-	s := spinner.New(spinner.CharSets[14], 150*time.Millisecond) // Build our new spinner
-	s.Start()
-	s.Suffix = fmt.Sprintf(" Migrating in progress...") // Start the spinner
-	// Run for some time to simulate work
+// MigrateResult is the outcome of a migration, printed by migrate.
+type MigrateResult struct {
+	Source  string `json:"source"`
+	Dest    string `json:"destination"`
+	Message string `json:"message"`
+}
 
-	token, err := GetTokenFromContext(ctx)
-	if err != nil {
-		return err
-	}
+func (r MigrateResult) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintln(w, r.Message)
+	return err
+}
 
-	host, err := GetHostFromContext(ctx)
-	if err != nil {
-		return err
-	}
+// MigrateStatus is the state of a running migration reported by the
+// intermediate service.
+type MigrateStatus struct {
+	Files      int   `json:"files"`
+	FilesTotal int   `json:"files_total"`
+	Bytes      int64 `json:"bytes"`
+	BytesTotal int64 `json:"bytes_total"`
+}
 
-	clients, err := GetClientsFromContext(ctx)
-	if err != nil {
-		return err
-	}
+// migratePollInterval is the delay between two polls of the migration
+// status.
+const migratePollInterval = time.Second
+
+// Migrate moves the stored files from the cloud provider pathSource to
+// pathDest, showing the progress polled from the intermediate service.
+func (fs *Filesystem) Migrate(ctx context.Context, sess *session.Session, publishing model.Publishing, pathSource, pathDest string) (MigrateResult, error) {
+	token := sess.Token()
+	host := sess.Host()
+	clients := sess.Clients()
 
 	clientSource := pathSource
 	if !contains(clients, clientSource) {
-		return errors.New("source cloud storage is not supported or not found")
+		return MigrateResult{}, errors.New("source cloud storage is not supported or not found")
 	}
 
 	clientDest := pathDest
 	if !contains(clients, clientDest) {
-		return errors.New("destination cloud storage is not supported or not found")
+		return MigrateResult{}, errors.New("destination cloud storage is not supported or not found")
 	}
 
+	result := MigrateResult{Source: clientSource, Dest: clientDest}
 	if clientSource == clientDest {
-		return errors.New("source and destination cloud storage cannot be the same")
+		return result, errors.New("source and destination cloud storage cannot be the same")
 	}
 
 	migrateURL := constant.Protocol + host + constant.ApiVer + "/migrate"
@@ -1052,35 +1073,100 @@ func (fs *Filesystem) Migrate(ctx context.Context, publish bool, pathSource, pat
 	param.Set("clientDest", clientDest)
 
 	var payload = bytes.NewBufferString(param.Encode())
-	req, err := http.NewRequest(http.MethodPost, migrateURL, payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, migrateURL, payload)
+	if err != nil {
+		return result, err
+	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("token", token)
+
+	// The service answers once the migration ends, its status is polled
+	// meanwhile.
+	task := sess.Progress.Start("migrate", clientSource+" -> "+clientDest, 0)
+	done := make(chan struct{})
+	go pollMigration(ctx, task, migrateURL+"/status?"+param.Encode(), token, done)
+
 	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		return err
+	close(done)
+	if err != nil {
+		task.Finish(fmt.Errorf("migrate : %s to %s failed", clientSource, clientDest))
+		return result, fmt.Errorf("migrate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("migrate: %w: %s", constant.ErrRemoteFailed, resp.Status)
+		task.Finish(err)
+		return result, err
 	}
 
 	body, err := io.ReadAll(resp.Body)
-	post := MigrateResp{}
+	if err != nil {
+		task.Finish(err)
+		return result, err
+	}
 
+	post := MigrateResp{}
 	err = json.Unmarshal(body, &post)
 	if err != nil {
-		return err
+		task.Finish(err)
+		return result, err
 	}
 
-	defer func() {
-		s.Stop()
-		fmt.Println(post.Message)
-	}()
+	task.Finish(nil)
+	result.Message = post.Message
+	return result, nil
+}
 
-	return nil
+// pollMigration updates task with the status of the migration at
+// statusURL until done is closed. A service without a status endpoint
+// leaves the task with its elapsed time only.
+func pollMigration(ctx context.Context, task *progress.Task, statusURL, token string, done chan struct{}) {
+	ticker := time.NewTicker(migratePollInterval)
+	defer ticker.Stop()
+
+	client := http.Client{Timeout: migratePollInterval}
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("token", token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return
+		}
+
+		status := MigrateStatus{}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		task.SetTotal(status.BytesTotal)
+		task.SetBytes(status.Bytes)
+		task.SetFiles(status.Files, status.FilesTotal)
+	}
 }
 
-func (fs *Filesystem) Testing(ctx context.Context, path string) {
+func (fs *Filesystem) Testing(ctx context.Context, sess *session.Session, path string) {
 
-	pubs, _ := GetPublisherFromContext(ctx)
-	clientID, _ := GetClientIDFromContext(ctx)
+	pubs := sess.Publisher
+	clientID := sess.ClientID()
 	msg := pubsub_notify.MessageCommand{
 		FullCommand: path,
 		ClientID:    clientID,
@@ -1093,12 +1179,10 @@ func (fs *Filesystem) Testing(ctx context.Context, path string) {
 
 }
 
-// SaveState aves the state of the VFS at this time.
-func (fs *Filesystem) SaveState() {
-	fmt.Println("Save the current state of the VFS")
-}
+// SaveState saves the state of the VFS at this time. The state is
+// replicated as it changes, so there is nothing left to save.
+func (fs *Filesystem) SaveState() {}
 
-// TearDown gracefully ends the current session.
-func (fs *Filesystem) TearDown() {
-	fmt.Println("Teardown")
-}
+// TearDown gracefully ends the current session. The client waits for the
+// replicated operations itself, so there is nothing left to end.
+func (fs *Filesystem) TearDown() {}
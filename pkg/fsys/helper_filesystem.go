@@ -2,6 +2,7 @@ package fsys
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,77 +13,12 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/marcellof23/vfs-TA/boot"
 	"github.com/marcellof23/vfs-TA/constant"
-	"github.com/marcellof23/vfs-TA/pkg/model"
-	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/publisher"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 type WalkDirFunc func(path, filename string, fs *Filesystem, err error) error
 
-func GetUserStateFromContext(c context.Context) (model.UserState, error) {
-	tmp := c.Value("userState")
-	userState, ok := tmp.(model.UserState)
-	if !ok {
-		return model.UserState{}, constant.ErrUserStateNotFound
-	}
-	return userState, nil
-}
-
-func GetTokenFromContext(c context.Context) (string, error) {
-	tmp := c.Value("token")
-	token, ok := tmp.(string)
-	if !ok {
-		return "", constant.ErrTokenNotFound
-	}
-	return token, nil
-}
-
-func GetClientsFromContext(c context.Context) ([]string, error) {
-	tmp := c.Value("clients")
-	clients, ok := tmp.([]string)
-	if !ok {
-		return []string{}, constant.ErrClientsNotFound
-	}
-	return clients, nil
-}
-
-func GetMaxFileSzFromContext(c context.Context) (int64, error) {
-	tmp := c.Value("maxFileSize")
-	maxSz, ok := tmp.(int64)
-	if !ok {
-		return 0, constant.ErrTokenNotFound
-	}
-	return maxSz, nil
-}
-
-func GetHostFromContext(c context.Context) (string, error) {
-	tmp := c.Value("host")
-	host, ok := tmp.(string)
-	if !ok {
-		return "", constant.ErrHostNotFound
-	}
-	return host, nil
-}
-
-func GetClientIDFromContext(c context.Context) (string, error) {
-	tmp := c.Value("clientID")
-	clientID, ok := tmp.(string)
-	if !ok {
-		return "", constant.ErrHostNotFound
-	}
-	return clientID, nil
-}
-
-func GetPublisherFromContext(c context.Context) (*publisher.Publisher, error) {
-	tmp := c.Value("publisher")
-	pubs, ok := tmp.(*publisher.Publisher)
-	if !ok {
-		return &publisher.Publisher{}, constant.ErrHostNotFound
-	}
-	return pubs, nil
-}
-
 func SortFiles(m map[string]*file) []string {
 	keys := make([]string, 0, len(m))
 
@@ -111,30 +47,34 @@ func SortDirs(m map[string]*Filesystem) []string {
 	return keys
 }
 
-func (fs *Filesystem) PrintStat(info *FileInfo, filename string) {
-	if info != nil {
-		var tipe string
-		if info.IsDir() {
-			tipe = "Directory"
-		} else {
-			tipe = "File"
-		}
-
-		sz := info.Size()
-		fname := filepath.Join(fs.rootPath, info.Name())
-		if sz == 0 {
-			sz = FileSizeMap[fname]
-			fmt.Println("puntens")
-		}
-
-		fmt.Println("File: ", info.Name())
-		fmt.Println("Size: ", sz)
-		fmt.Println("Access: ", info.Mode())
-		fmt.Println("Modify: ", info.ModTime())
-		fmt.Println("Type: ", tipe)
-		fmt.Println("UserID: ", info.Uid)
-		fmt.Println("GroupID: ", info.Gid)
+// Entry describes the file or directory filename from its status.
+func (fs *Filesystem) Entry(info *FileInfo, filename string) Entry {
+	entry := Entry{
+		Name:     info.Name(),
+		Path:     fs.entryPath(filename),
+		Type:     "file",
+		Size:     info.Size(),
+		Mode:     info.Mode().String(),
+		Perm:     fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Uid:      info.Uid,
+		Gid:      info.Gid,
+		Checksum: info.Checksum,
+		Loaded:   info.IsLoaded,
 	}
+	if info.IsDir() {
+		entry.Type = "directory"
+		entry.Checksum = ""
+	} else if entry.Size == 0 {
+		// The content of a file not loaded is only known to the cache.
+		entry.Size = fs.vol.lru().Sizes[fs.absPath(filename)]
+	}
+	return entry
+}
+
+// entryPath returns the absolute path of filename, starting with a slash.
+func (fs *Filesystem) entryPath(filename string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(fs.absPath(filename), "."), "/")
 }
 
 // verifyPath is a function to check file or dir exists
@@ -167,7 +107,7 @@ func (fs *Filesystem) verifyPath(dirName string) (*Filesystem, error) {
 
 // searchFS to check file or dir exists
 func (fs *Filesystem) searchFS2(dirName string) (*Filesystem, error) {
-	checker := root
+	checker := fs.vol.Root()
 	segments := strings.Split(dirName, "/")
 
 	for idx, segment := range segments {
@@ -262,7 +202,7 @@ func (fs *Filesystem) absPath(pathname string) string {
 
 func (fs *Filesystem) handleRootNav(dirName string) *Filesystem {
 	if dirName[0] == '/' {
-		return root
+		return fs.vol.Root()
 	}
 	return fs
 }
@@ -351,16 +291,59 @@ func JoinPath(path ...string) string {
 	return unixPath
 }
 
-func GetFile(ctx context.Context, sourcePath string, targetFile *os.File) error {
-	token, err := GetTokenFromContext(ctx)
+// fetchObject gets the content of an evicted file from the intermediate
+// service and verifies it against the checksum stored for the file.
+func (fs *Filesystem) fetchObject(ctx context.Context, sess *session.Session, path string) ([]byte, error) {
+	token := sess.Token()
+
+	dep := sess.Dep
+
+	filename := filepath.Clean(path)
+	getFileURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/file/object?"
+
+	client := http.Client{}
+	var param = url.Values{}
+	param.Add("filename", filename)
+
+	req, err := http.NewRequest(http.MethodGet, getFileURL+param.Encode(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("token", token)
 
-	dep, ok := ctx.Value("dependency").(*boot.Dependencies)
-	if !ok {
-		return errors.New("failed to get dependency from context")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", constant.ErrRemoteData, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("failed to get file data from remote")
+	}
+	fileResp := GetFileResp{}
+	err = json.Unmarshal(body, &fileResp)
+	if err != nil {
+		return nil, errors.New("failed to unmarshal file body")
+	}
+
+	err = verifyChecksum(fs.MFS.Checksum(path), fileResp.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return fileResp.Data, nil
+}
+
+func GetFile(ctx context.Context, sess *session.Session, sourcePath string, targetFile *os.File) error {
+	token := sess.Token()
+
+	dep := sess.Dep
 
 	filename := filepath.Clean(sourcePath)
 	getFileURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/file/object?"
@@ -21,7 +21,7 @@ func (s *Shell) verifyPath(dirName string) (*Filesystem, error) {
 	segments := strings.Split(dirName, "/")
 
 	for _, segment := range segments {
-		if len(segment) == 0 {
+		if len(segment) == 0 || segment == "." {
 			continue
 		}
 		if segment == ".." {
@@ -41,7 +41,7 @@ func (s *Shell) verifyPath(dirName string) (*Filesystem, error) {
 
 func (s *Shell) handleRootNav(dirName string) *Filesystem {
 	if dirName[0] == '/' {
-		return root
+		return s.Fs.vol.Root()
 	}
 	return s.Fs
 }
@@ -2,48 +2,170 @@ package fsys
 
 import (
 	"context"
-	"fmt"
 	gofs "io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/marcellof23/vfs-TA/boot"
+	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/chunker"
 	"github.com/marcellof23/vfs-TA/pkg/model"
 	"github.com/marcellof23/vfs-TA/pkg/producer"
 	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 // Initiation Virtual MemFilesystem
 
+// Options configures a Volume.
+type Options struct {
+	BackupPath          string // Host directory replicated into the volume.
+	MaxFileSize         int64  // Largest file accepted by the volume.
+	LargeFileConstraint int64  // Files above this size are sent in chunks.
+	MemoryThreshold     int64  // Size of cached content before files are evicted.
+}
+
+// DefaultOptions returns the options used by the shell.
+func DefaultOptions(maxFileSize int64) Options {
+	return Options{
+		BackupPath:          "backup",
+		MaxFileSize:         maxFileSize,
+		LargeFileConstraint: 50 * 1024 * 1024, // 50MB
+		MemoryThreshold:     30 * 1024,
+	}
+}
+
+// Volume is one independent virtual Filesystem with its own tree and cache.
+//
+// The Filesystem methods do no locking. Every operation on the tree, whether
+// it comes from the shell, from another client or from an adapter, must run
+// through Do so that operations are applied one at a time. Root may be
+// called from any goroutine.
+type Volume struct {
+	opts  Options
+	mu    sync.RWMutex // Guards root and cache, replaced by reload.
+	root  *Filesystem
+	cache *LRUCache
+
+	ops       chan op
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	watchMu  sync.Mutex
+	watchers map[chan struct{}]struct{} // Woken by Changed.
+}
+
+// op is a unit of work applied by the writer goroutine of a Volume.
+type op struct {
+	fn   func() error
+	done chan error
+}
+
+// NewVolume creates a Volume by replicating opts.BackupPath.
+func NewVolume(opts Options) *Volume {
+	v := &Volume{
+		opts: opts,
+		ops:  make(chan op),
+		quit: make(chan struct{}),
+	}
+	v.reload()
+
+	go v.run()
+	return v
+}
+
+// run applies the submitted operations in order until the volume is closed.
+func (v *Volume) run() {
+	for {
+		select {
+		case o := <-v.ops:
+			o.done <- o.fn()
+		case <-v.quit:
+			return
+		}
+	}
+}
+
+// Do runs fn on the writer goroutine of the volume and returns its error.
+// Operations are applied atomically in the order they are submitted.
+// fn must not call Do or Reload itself.
+func (v *Volume) Do(fn func() error) error {
+	done := make(chan error, 1)
+	select {
+	case v.ops <- op{fn: fn, done: done}:
+	case <-v.quit:
+		return constant.ErrVolumeClosed
+	}
+	return <-done
+}
+
+// Close stops the writer goroutine. Later calls to Do fail.
+func (v *Volume) Close() {
+	v.closeOnce.Do(func() {
+		close(v.quit)
+	})
+}
+
+// New creates a Volume with the default options and returns its root directory.
 func New(maxFileSize int64) *Filesystem {
+	return NewVolume(DefaultOptions(maxFileSize)).Root()
+}
+
+// Root returns the root directory of the volume.
+func (v *Volume) Root() *Filesystem {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.root
+}
+
+// lru returns the cache of the loaded file contents of the volume.
+func (v *Volume) lru() *LRUCache {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.cache
+}
+
+// Options returns the options the volume was created with.
+func (v *Volume) Options() Options {
+	return v.opts
+}
+
+// Reload rebuilds the volume from its backup directory.
+func (v *Volume) Reload() error {
+	return v.Do(func() error {
+		v.reload()
+		return nil
+	})
+}
 
-	LruCache = Constructor()
+func (v *Volume) reload() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.cache = NewLRUCache(v.opts.MemoryThreshold)
 	// uncomment for recursively grab all files and directories from this level downwards.
-	root = ReplicateFilesystem(".", "backup", nil, maxFileSize)
+	v.root = v.replicateFilesystem(".", v.opts.BackupPath, nil)
 
 	// uncomment for initiate empty virtual Filesystem
-	// root = makeFilesystem(".", ".", nil)
+	// v.root = makeFilesystem(".", ".", nil, nil, v)
 
-	statBackup, _ := os.Stat("backup")
-	root.MFS.Chmod("/", statBackup.Mode())
-	root.MFS.Chown("/", 1055, 1055)
-	fsys := root
-	return fsys
+	statBackup, err := os.Stat(v.opts.BackupPath)
+	if err == nil {
+		v.root.MFS.Chmod("/", statBackup.Mode())
+	}
+	v.root.MFS.Chown("/", 1055, 1055)
 }
 
 // testFilessytemCreation initializes the Filesystem by replicating
 // the current root directory and all it's child direcctories.
-func copyFilesystem(ctx context.Context, publishing model.Publishing, dirName, replicatePath, targetPath string, fs *Filesystem) *Filesystem {
+func copyFilesystem(ctx context.Context, sess *session.Session, publishing model.Publishing, dirName, replicatePath, targetPath string, fs *Filesystem) *Filesystem {
 	var fileName gofs.DirEntry
 	var fi os.FileInfo
 
-	userState, ok := ctx.Value("userState").(model.UserState)
-	if !ok {
-		return &Filesystem{}
-	}
+	userState := sess.User
 
 	index := 0
 
@@ -51,52 +173,44 @@ func copyFilesystem(ctx context.Context, publishing model.Publishing, dirName, r
 	for index < len(files) {
 		fileName = files[index]
 		fi, _ = os.Stat(replicatePath + "/" + fileName.Name())
-		fl, _ := os.Open(replicatePath + "/" + fileName.Name())
 		dat, _ := os.ReadFile(replicatePath + "/" + fileName.Name())
 		mode := fi.Mode()
 		if mode.IsDir() {
 			dirname := fileName.Name()
-			fs.MkDir(ctx, publishing, dirname)
+			fs.MkDir(ctx, sess, publishing, dirname)
 			fs.MFS.Chmod(dirname, mode.Perm())
 			fs.MFS.Chown(filepath.ToSlash(filepath.Clean(filepath.Join(fs.rootPath, dirname))), userState.UserID, userState.GroupID)
-			copyFilesystem(ctx, publishing, dirName+"/"+fileName.Name(), replicatePath+"/"+fileName.Name(), targetPath, fs.directories[fileName.Name()])
+			copyFilesystem(ctx, sess, publishing, dirName+"/"+fileName.Name(), replicatePath+"/"+fileName.Name(), targetPath, fs.directories[fileName.Name()])
 		} else {
 			fs.files[fileName.Name()] = &file{
 				name:     fileName.Name(),
 				rootPath: strings.ReplaceAll(dirName, "//", "/") + "/" + fileName.Name(),
 			}
 			fname := fs.files[fileName.Name()].rootPath
+			sum := Checksum(dat)
 			memfile, _ := fs.MFS.Create(filepath.ToSlash(filepath.Join(targetPath, fname)))
 			memfile.Truncate(fi.Size())
 			fs.MFS.Chmod(memfile.Name(), mode.Perm())
+			fs.MFS.SetChecksum(memfile.Name(), sum)
 			fs.MFS.Chown(filepath.ToSlash(filepath.Clean(fname)), userState.UserID, userState.GroupID)
-			LruCache.Put(filepath.ToSlash(filepath.Join(targetPath, fname)), fi.Size(), dat, fs)
+			fs.vol.lru().Put(filepath.ToSlash(filepath.Join(targetPath, fname)), fi.Size(), dat, fs)
 
-			token, err := GetTokenFromContext(ctx)
-			if err != nil {
-				fmt.Println(err)
-			}
+			token := sess.Token()
 
 			if publishing.PublishSync {
-				pubs, err := GetPublisherFromContext(ctx)
-				if err != nil {
-					fmt.Println(err)
-				}
-
-				clientID, err := GetClientIDFromContext(ctx)
-				if err != nil {
-					fmt.Println(err)
-				}
+				pubs := sess.Publisher
+				clientID := sess.ClientID()
 
 				absDestPath := filepath.ToSlash(filepath.Join(targetPath, fname))
 				// Sync to other client
 				msgSync := pubsub_notify.MessageCommand{
-					FullCommand: fmt.Sprintf("%s %s", "upload-sync", absDestPath),
-					Buffer:      dat,
-					FileMode:    uint64(mode),
-					Uid:         userState.UserID,
-					Gid:         userState.GroupID,
-					ClientID:    clientID,
+					Args:     []string{"upload-sync", absDestPath},
+					Buffer:   dat,
+					FileMode: uint64(mode),
+					Uid:      userState.UserID,
+					Gid:      userState.GroupID,
+					Checksum: sum,
+					ClientID: clientID,
 				}
 
 				pubs.Publish(ctx, msgSync)
@@ -112,27 +226,44 @@ func copyFilesystem(ctx context.Context, publishing model.Publishing, dirName, r
 					FileMode:      uint64(mode),
 					Uid:           userState.UserID,
 					Gid:           userState.GroupID,
+					Checksum:      sum,
 				}
 
-				if fi.Size() <= int64(LargeFileConstraint) {
+				if fi.Size() <= fs.vol.opts.LargeFileConstraint {
 					memfile.Write(dat)
 					msg.Buffer = dat
-					r := producer.Retry(producer.ProduceCommand, 3e9)
-					go r(ctx, msg)
-				} else {
-					producer.ProduceCommand(ctx, msg)
 
+					task := sess.Progress.Start("upload", fname, fi.Size())
+					sess.Producer.GoAcked(ctx, msg, ackTask(task, fi.Size()))
+				} else {
 					fileChunker := chunker.FileChunk{
 						Ctx:           ctx,
+						Producer:      sess.Producer,
 						Command:       "write",
 						Token:         token,
 						AbsPathSource: fname,
 						AbsPathDest:   targetPath,
 						Uid:           userState.UserID,
 						Gid:           userState.GroupID,
+						Checksum:      sum,
 					}
 
-					_ = fileChunker.Process(fl)
+					// Producing the chunks does not need the volume.
+					chunkPath := replicatePath + "/" + fileName.Name()
+					_ = detach(sess, func() error {
+						chunkFile, err := os.Open(chunkPath)
+						if err != nil {
+							return err
+						}
+						defer chunkFile.Close()
+
+						task := sess.Progress.Start("upload", fname, 0)
+						fileChunker.Progress = task
+						sess.Producer.ProduceCommand(ctx, msg)
+						err = fileChunker.Process(chunkFile)
+						task.Finish(err)
+						return err
+					})
 				}
 			}
 		}
@@ -144,13 +275,12 @@ func copyFilesystem(ctx context.Context, publishing model.Publishing, dirName, r
 
 // testFilessytemCreation initializes the Filesystem by replicating
 // the current root directory and all it's child direcctories.
-func ReplicateFilesystem(dirName, replicatePath string, fs *Filesystem, maxFileSize int64) *Filesystem {
+func (v *Volume) replicateFilesystem(dirName, replicatePath string, fs *Filesystem) *Filesystem {
 	var fileName gofs.DirEntry
 	var fi os.FileInfo
 
 	if dirName == "." {
-		root = makeFilesystem(".", ".", nil, nil)
-		fs = root
+		fs = makeFilesystem(".", ".", nil, nil, v)
 	}
 
 	index := 0
@@ -162,12 +292,12 @@ func ReplicateFilesystem(dirName, replicatePath string, fs *Filesystem, maxFileS
 		mode := fi.Mode()
 		if mode.IsDir() {
 			dirname := fileName.Name()
-			fs.directories[dirname] = makeFilesystem(dirname, strings.ReplaceAll(dirName, "//", "/")+"/"+fileName.Name(), fs, fs.MemFilesystem)
+			fs.directories[dirname] = makeFilesystem(dirname, strings.ReplaceAll(dirName, "//", "/")+"/"+fileName.Name(), fs, fs.MemFilesystem, v)
 
 			dirNameClean := filepath.ToSlash(filepath.Join(fs.rootPath, dirname))
 			fs.MFS.Mkdir(dirNameClean, mode)
 			fs.MFS.Chown(dirNameClean, int(fi.Sys().(*syscall.Stat_t).Uid), int(fi.Sys().(*syscall.Stat_t).Gid))
-			ReplicateFilesystem(dirName+"/"+fileName.Name(), replicatePath+"/"+fileName.Name(), fs.directories[fileName.Name()], maxFileSize)
+			v.replicateFilesystem(dirName+"/"+fileName.Name(), replicatePath+"/"+fileName.Name(), fs.directories[fileName.Name()])
 		} else {
 			fs.files[fileName.Name()] = &file{
 				name:     fileName.Name(),
@@ -179,7 +309,8 @@ func ReplicateFilesystem(dirName, replicatePath string, fs *Filesystem, maxFileS
 			memfile.Write(dat)
 
 			fnameClean := filepath.ToSlash(filepath.Clean(fname))
-			LruCache.Put(fnameClean, int64(len(dat)), []byte{}, fs)
+			fs.MFS.SetChecksum(fnameClean, Checksum(dat))
+			v.cache.Put(fnameClean, int64(len(dat)), []byte{}, fs)
 			fs.MFS.Chmod(fnameClean, mode)
 			fs.MFS.Chown(fnameClean, int(fi.Sys().(*syscall.Stat_t).Uid), int(fi.Sys().(*syscall.Stat_t).Gid))
 
@@ -189,7 +320,7 @@ func ReplicateFilesystem(dirName, replicatePath string, fs *Filesystem, maxFileS
 	return fs
 }
 
-func makeFilesystem(dirName string, rootPath string, prev *Filesystem, fsys *boot.MemFilesystem) *Filesystem {
+func makeFilesystem(dirName string, rootPath string, prev *Filesystem, fsys *boot.MemFilesystem, vol *Volume) *Filesystem {
 	fs := boot.InitFilesystem()
 	if fsys == nil {
 		fs = boot.InitFilesystem()
@@ -206,5 +337,6 @@ func makeFilesystem(dirName string, rootPath string, prev *Filesystem, fsys *boo
 			directories: make(map[string]*Filesystem),
 			prev:        prev,
 		},
+		vol,
 	}
 }
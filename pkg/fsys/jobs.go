@@ -0,0 +1,333 @@
+package fsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// JobState is the state of a background job.
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobDone
+	JobFailed
+	JobKilled
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobDone:
+		return "Done"
+	case JobFailed:
+		return "Failed"
+	case JobKilled:
+		return "Killed"
+	}
+	return "Running"
+}
+
+// Job is a command line running in the background.
+type Job struct {
+	ID      int
+	Command string
+
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed when the job ends.
+	state    JobState
+	err      error
+	reported bool // The end of the job was printed.
+}
+
+// jobTable holds the background jobs of a shell.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+// add creates a running job with the next free number.
+func (t *jobTable) add(command string, cancel context.CancelFunc) *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := 1
+	if n := len(t.jobs); n > 0 {
+		id = t.jobs[n-1].ID + 1
+	}
+	job := &Job{ID: id, Command: command, cancel: cancel, done: make(chan struct{})}
+	t.jobs = append(t.jobs, job)
+	return job
+}
+
+// finish records the end of job.
+func (t *jobTable) finish(job *Job, err error, killed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job.err = err
+	switch {
+	case killed:
+		job.state = JobKilled
+	case err != nil:
+		job.state = JobFailed
+	default:
+		job.state = JobDone
+	}
+	close(job.done)
+}
+
+// find returns the job named by spec, %n or n, or the last job when spec is
+// empty.
+func (t *jobTable) find(spec string) (*Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if spec == "" {
+		if len(t.jobs) == 0 {
+			return nil, errors.New("no current job")
+		}
+		return t.jobs[len(t.jobs)-1], nil
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err == nil {
+		for _, job := range t.jobs {
+			if job.ID == id {
+				return job, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// remove forgets job.
+func (t *jobTable) remove(job *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, j := range t.jobs {
+		if j == job {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns the jobs, all of them or only the ended ones not yet
+// reported, and forgets the reported ended jobs.
+func (t *jobTable) list(all bool) JobList {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := JobList{}
+	var kept []*Job
+	for _, job := range t.jobs {
+		ended := job.state != JobRunning
+		if all || (ended && !job.reported) {
+			info := JobInfo{ID: job.ID, Command: job.Command, State: job.state.String()}
+			if job.err != nil {
+				info.Error = job.err.Error()
+			}
+			list = append(list, info)
+			job.reported = job.reported || ended
+		}
+		if !ended || !job.reported {
+			kept = append(kept, job)
+		}
+	}
+	t.jobs = kept
+	return list
+}
+
+// JobInfo describes a background job.
+type JobInfo struct {
+	ID      int    `json:"id"`
+	Command string `json:"command"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+// JobList is the list of jobs printed by jobs.
+type JobList []JobInfo
+
+func (l JobList) WriteText(w io.Writer) error {
+	for _, job := range l {
+		fmt.Fprintf(w, "[%d]  %-8s %s\n", job.ID, job.State, job.Command)
+	}
+	return nil
+}
+
+func (l JobList) entries() []interface{} {
+	entries := make([]interface{}, len(l))
+	for i, job := range l {
+		entries[i] = job
+	}
+	return entries
+}
+
+// startJob runs stages in the background on a copy of the shell, so that a
+// later cd or variable change of the shell does not affect it. The pipeline
+// runs on the volume as in the foreground, the unlocked commands alone on
+// their line without holding it, and the slow transfers of the job run once
+// its operation has released the volume.
+func (s *Shell) startJob(ctx context.Context, sess *session.Session, command string, stages []Stage, publishing model.Publishing) *Job {
+	ctx, cancel := context.WithCancel(ctx)
+	job := s.jobs.add(command, cancel)
+
+	var deferred []func() error
+	jobSess := *sess
+	// Progress bars would be drawn over the prompt.
+	jobSess.Progress = nil
+	jobSess.Defer = func(fn func() error) {
+		deferred = append(deferred, fn)
+	}
+
+	sub := s.subshell()
+	go func() {
+		err := sub.runLocked(ctx, &jobSess, stages, publishing, s.Fs.vol.Do)
+		for _, fn := range deferred {
+			if err != nil {
+				break
+			}
+			if err = fn(); err != nil {
+				fmt.Fprintf(os.Stderr, "[%d] %s\n", job.ID, err.Error())
+				err = fmt.Errorf("%w: %s", errReported, err.Error())
+			}
+		}
+		s.jobs.finish(job, err, ctx.Err() != nil)
+	}()
+
+	fmt.Fprintf(os.Stderr, "[%d] %s\n", job.ID, command)
+	return job
+}
+
+// subshell returns a copy of the shell with its own variables, aliases and
+// working directory.
+func (s *Shell) subshell() *Shell {
+	sub := &Shell{
+		Fs:       s.Fs,
+		ErrExit:  s.ErrExit,
+		Format:   s.Format,
+		vars:     map[string]string{},
+		exported: map[string]bool{},
+		aliases:  map[string]string{},
+		status:   s.status,
+		jobs:     &jobTable{},
+		oldDir:   s.oldDir,
+		dirStack: append([]string{}, s.dirStack...),
+	}
+	for name, value := range s.vars {
+		sub.vars[name] = value
+	}
+	for name := range s.exported {
+		sub.exported[name] = true
+	}
+	for name, value := range s.aliases {
+		sub.aliases[name] = value
+	}
+	return sub
+}
+
+// NotifyJobs prints the jobs that ended since the last call.
+func (s *Shell) NotifyJobs(w io.Writer) {
+	if list := s.jobs.list(false); len(list) > 0 {
+		writeResult(w, s.Format, list)
+	}
+}
+
+// Jobs returns every job.
+func (s *Shell) Jobs() JobList {
+	return s.jobs.list(true)
+}
+
+// Foreground waits for a job, the last one by default, and returns its
+// error. An interrupt kills the job.
+func (s *Shell) Foreground(w io.Writer, spec string) error {
+	job, err := s.jobs.find(spec)
+	if err != nil {
+		return fmt.Errorf("fg: %w", err)
+	}
+	fmt.Fprintln(w, job.Command)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	select {
+	case <-job.done:
+	case <-interrupt:
+		job.cancel()
+		<-job.done
+	}
+
+	s.jobs.remove(job)
+	return job.err
+}
+
+// WaitJobs waits for the named jobs, or all of them, and returns the error
+// of the last failing one. An interrupt stops waiting.
+func (s *Shell) WaitJobs(specs []string) error {
+	var jobs []*Job
+	if len(specs) == 0 {
+		s.jobs.mu.Lock()
+		jobs = append(jobs, s.jobs.jobs...)
+		s.jobs.mu.Unlock()
+	}
+	for _, spec := range specs {
+		job, err := s.jobs.find(spec)
+		if err != nil {
+			return fmt.Errorf("wait: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	var lastErr error
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-interrupt:
+			return errors.New("wait: interrupted")
+		}
+
+		s.jobs.remove(job)
+		if job.err != nil {
+			lastErr = job.err
+		}
+	}
+	return lastErr
+}
+
+// Kill cancels a job, stopping its transfers.
+func (s *Shell) Kill(spec string) error {
+	job, err := s.jobs.find(spec)
+	if err != nil {
+		return fmt.Errorf("kill: %w", err)
+	}
+	job.cancel()
+	return nil
+}
+
+// detach runs fn once the current operation has released the volume when
+// the session belongs to a background job, so the slow transfer of a
+// command does not block the other commands. Otherwise fn runs at once.
+func detach(sess *session.Session, fn func() error) error {
+	if sess.Defer != nil {
+		sess.Defer(fn)
+		return nil
+	}
+	return fn()
+}
@@ -6,11 +6,6 @@ import (
 	"os"
 )
 
-var (
-	LruCache        *LRUCache
-	MemoryThreshold = int64(30 * 1024)
-)
-
 type Node struct {
 	Filename string
 	FileSize int64
@@ -20,11 +15,13 @@ type Node struct {
 type LRUCache struct {
 	Queue     *list.List
 	Items     map[string]*Node
+	Sizes     map[string]int64 // The real size of every file put in the cache.
 	TotalSize int64
+	Threshold int64
 }
 
-func Constructor() *LRUCache {
-	return &LRUCache{Queue: list.New(), Items: make(map[string]*Node), TotalSize: 0}
+func NewLRUCache(threshold int64) *LRUCache {
+	return &LRUCache{Queue: list.New(), Items: make(map[string]*Node), Sizes: make(map[string]int64), TotalSize: 0, Threshold: threshold}
 }
 
 func (l *LRUCache) Put(key string, value int64, content []byte, fs *Filesystem) {
@@ -33,8 +30,8 @@ func (l *LRUCache) Put(key string, value int64, content []byte, fs *Filesystem)
 	defer removedFile.Close()
 
 	if item, ok := l.Items[key]; !ok {
-		FileSizeMap[key] = value
-		if l.TotalSize >= MemoryThreshold {
+		l.Sizes[key] = value
+		if l.TotalSize >= l.Threshold {
 			back := l.Queue.Back()
 			l.Queue.Remove(back)
 			delete(l.Items, back.Value.(string))
@@ -44,7 +41,7 @@ func (l *LRUCache) Put(key string, value int64, content []byte, fs *Filesystem)
 			destFile, _ := fs.MFS.OpenFile(filename, os.O_RDWR|os.O_TRUNC, destStat.Mode())
 			defer destFile.Close()
 
-			l.TotalSize -= FileSizeMap[filename]
+			l.TotalSize -= l.Sizes[filename]
 			destFile.Truncate(0)
 			destFile.Write([]byte{})
 		}
@@ -0,0 +1,322 @@
+package fsys
+
+import (
+	"bytes"
+)
+
+// splitLines splits data into lines keeping their line endings, so that
+// joining them gives data back. The last line may have no newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// change replaces the lines a[A:AEnd] of a sequence with b[B:BEnd] of
+// another.
+type change struct {
+	A, AEnd int
+	B, BEnd int
+}
+
+// diffLines returns the changes turning the lines a into b, in order, found
+// with the linear space variant of the Myers algorithm so that they are as
+// few as possible.
+func diffLines(a, b []string) []change {
+	// The lines are compared by number, the same for equal lines.
+	ids := make(map[string]int)
+	number := func(lines []string) []int {
+		numbers := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			numbers[i] = id
+		}
+		return numbers
+	}
+
+	d := &differ{
+		a:        number(a),
+		b:        number(b),
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	var changes []change
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && !d.deleted[i] && !d.inserted[j] {
+			i++
+			j++
+			continue
+		}
+
+		c := change{A: i, B: j}
+		for i < len(a) && d.deleted[i] {
+			i++
+		}
+		for j < len(b) && d.inserted[j] {
+			j++
+		}
+		c.AEnd, c.BEnd = i, j
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// differ marks the lines of a deleted and the lines of b inserted by the
+// shortest edit script.
+type differ struct {
+	a, b     []int
+	deleted  []bool
+	inserted []bool
+}
+
+// compare marks the edits turning a[aLo:aHi] into b[bLo:bHi]. The lines are
+// split at the middle of the shortest path, then each half is compared.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo < aHi && bLo < bHi {
+		x, y, ok := d.middle(aLo, aHi, bLo, bHi)
+		// A split at an end would not make the halves smaller.
+		if ok && !(x == aLo && y == bLo) && !(x == aHi && y == bHi) {
+			d.compare(aLo, x, bLo, y)
+			d.compare(x, aHi, y, bHi)
+			return
+		}
+	}
+
+	for i := aLo; i < aHi; i++ {
+		d.deleted[i] = true
+	}
+	for j := bLo; j < bHi; j++ {
+		d.inserted[j] = true
+	}
+}
+
+// middle returns where the paths searched from both ends of a[aLo:aHi] and
+// b[bLo:bHi] meet, which is on a shortest path. It is false when the lines
+// have nothing in common.
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	size := 2*maxD + 2
+	forward := make([]int, size)
+	backward := make([]int, size)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// The paths meet while searching forward when delta is odd.
+	odd := delta%2 != 0
+
+	// The diagonals going past the end of a or b are not searched again.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[i] = x
+
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < size && backward[j] != -1 && x >= n-backward[j] {
+					return aLo + x, bLo + y, true
+				}
+			}
+		}
+
+		for k := -step + bStart; k <= step-bEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < size && forward[j] != -1 {
+					fx := forward[j]
+					fy := offset + fx - j
+					if fx >= n-x {
+						return aLo + fx, bLo + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Markers written around the conflicts of a merge.
+const (
+	conflictStart  = "<<<<<<< "
+	conflictMiddle = "=======\n"
+	conflictEnd    = ">>>>>>> "
+)
+
+// merge3 merges the changes made from base in mine and in theirs. The
+// regions changed differently on both sides are kept with conflict markers
+// labelled with the names given. It returns the merged content and the
+// number of conflicts.
+func merge3(base, mine, theirs []byte, mineName, theirsName string) ([]byte, int) {
+	baseLines := splitLines(base)
+	mineLines := splitLines(mine)
+	theirsLines := splitLines(theirs)
+
+	mineChanges := diffLines(baseLines, mineLines)
+	theirsChanges := diffLines(baseLines, theirsLines)
+
+	var out bytes.Buffer
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+	}
+	// A conflict marker must start on its own line.
+	endLine := func() {
+		if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+			out.WriteByte('\n')
+		}
+	}
+
+	conflicts := 0
+	pos, i, j := 0, 0, 0
+	for i < len(mineChanges) || j < len(theirsChanges) {
+		// The next group starts with the first change, then takes the
+		// changes of both sides overlapping it until none is left.
+		lo := -1
+		if i < len(mineChanges) {
+			lo = mineChanges[i].A
+		}
+		if j < len(theirsChanges) && (lo < 0 || theirsChanges[j].A < lo) {
+			lo = theirsChanges[j].A
+		}
+		hi := lo
+		mi, tj := i, j
+		for {
+			if i < len(mineChanges) && overlaps(mineChanges[i], lo, hi) {
+				if mineChanges[i].AEnd > hi {
+					hi = mineChanges[i].AEnd
+				}
+				i++
+				continue
+			}
+			if j < len(theirsChanges) && overlaps(theirsChanges[j], lo, hi) {
+				if theirsChanges[j].AEnd > hi {
+					hi = theirsChanges[j].AEnd
+				}
+				j++
+				continue
+			}
+			break
+		}
+
+		writeLines(baseLines[pos:lo])
+		pos = hi
+
+		mineSide := side(baseLines, mineLines, mineChanges[mi:i], lo, hi)
+		theirsSide := side(baseLines, theirsLines, theirsChanges[tj:j], lo, hi)
+		switch {
+		case mi == i:
+			writeLines(theirsSide)
+		case tj == j, equalLines(mineSide, theirsSide):
+			writeLines(mineSide)
+		default:
+			conflicts++
+			endLine()
+			out.WriteString(conflictStart + mineName + "\n")
+			writeLines(mineSide)
+			endLine()
+			out.WriteString(conflictMiddle)
+			writeLines(theirsSide)
+			endLine()
+			out.WriteString(conflictEnd + theirsName + "\n")
+		}
+	}
+	writeLines(baseLines[pos:])
+	return out.Bytes(), conflicts
+}
+
+// overlaps tells whether c touches the lines [lo, hi) of the base. An
+// insertion at either end touches them.
+func overlaps(c change, lo, hi int) bool {
+	return c.A <= hi && c.AEnd >= lo
+}
+
+// side returns the lines of one side replacing the base lines [lo, hi),
+// given its changes within them.
+func side(base, lines []string, changes []change, lo, hi int) []string {
+	if len(changes) == 0 {
+		return base[lo:hi]
+	}
+	first, last := changes[0], changes[len(changes)-1]
+	return lines[first.B-(first.A-lo) : last.BEnd+(hi-last.AEnd)]
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package fsys
+
+// Watch returns a channel receiving a value once the content of the volume
+// changes after the call, and a function to stop watching. Changes close
+// together are received as one.
+func (v *Volume) Watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	v.watchMu.Lock()
+	if v.watchers == nil {
+		v.watchers = map[chan struct{}]struct{}{}
+	}
+	v.watchers[ch] = struct{}{}
+	v.watchMu.Unlock()
+
+	return ch, func() {
+		v.watchMu.Lock()
+		delete(v.watchers, ch)
+		v.watchMu.Unlock()
+	}
+}
+
+// Changed wakes the watchers of the volume. It is called once a write, from
+// this client or replicated from another one, has been applied, and never
+// blocks.
+func (v *Volume) Changed() {
+	v.watchMu.Lock()
+	defer v.watchMu.Unlock()
+
+	for ch := range v.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
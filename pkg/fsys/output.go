@@ -0,0 +1,213 @@
+package fsys
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+)
+
+// Format is how the commands write their results.
+type Format int
+
+const (
+	FormatText   Format = iota // Text for humans.
+	FormatJSON                 // One indented JSON document per command.
+	FormatNDJSON               // One JSON object per line, one per entry for lists.
+)
+
+// ParseFormat returns the format called name: text, json or ndjson.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	case "ndjson":
+		return FormatNDJSON, nil
+	}
+	return FormatText, fmt.Errorf("unknown output format %q: expected text, json or ndjson", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatNDJSON:
+		return "ndjson"
+	}
+	return "text"
+}
+
+// Result is the structured result of a command. It is encoded as JSON in
+// the machine-readable formats and written by WriteText otherwise.
+type Result interface {
+	WriteText(w io.Writer) error
+}
+
+// listResult is a Result made of entries, written one per line in NDJSON.
+type listResult interface {
+	Result
+	entries() []interface{}
+}
+
+// Print writes result to the stdout of the command in its output format.
+func (inv *Invocation) Print(result Result) error {
+	return writeResult(inv.Stdout, inv.Format, result)
+}
+
+// writeResult writes result to w in format.
+func writeResult(w io.Writer, format Format, result Result) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		list, ok := result.(listResult)
+		if !ok {
+			return enc.Encode(result)
+		}
+		for _, entry := range list.entries() {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return result.WriteText(w)
+}
+
+// errorResult is the error of a command in the machine-readable formats.
+type errorResult struct {
+	Command string `json:"command,omitempty"`
+	Error   string `json:"error"`
+}
+
+// writeError writes the error of command to w in format.
+func writeError(w io.Writer, format Format, command string, err error) {
+	if format == FormatText {
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(errorResult{Command: command, Error: err.Error()})
+}
+
+// PathResult is a path of the virtual Filesystem, printed by pwd.
+type PathResult struct {
+	Path string `json:"path"`
+}
+
+func (r PathResult) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintln(w, r.Path)
+	return err
+}
+
+// Entry describes a file or directory.
+type Entry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"` // "file" or "directory".
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"` // As printed by ls -l, e.g. -rw-r--r--.
+	Perm     string    `json:"perm"` // The permission bits in octal.
+	ModTime  time.Time `json:"mtime"`
+	Uid      int       `json:"uid"`
+	Gid      int       `json:"gid"`
+	Checksum string    `json:"sha256,omitempty"`
+	Loaded   bool      `json:"loaded"` // The content is held by the client.
+}
+
+// IsDir tells whether the entry is a directory.
+func (e Entry) IsDir() bool {
+	return e.Type == "directory"
+}
+
+// WriteText writes the entry as stat does.
+func (e Entry) WriteText(w io.Writer) error {
+	tipe := "File"
+	if e.IsDir() {
+		tipe = "Directory"
+	}
+
+	fmt.Fprintln(w, "File: ", e.Name)
+	fmt.Fprintln(w, "Size: ", e.Size)
+	fmt.Fprintln(w, "Access: ", e.Mode)
+	fmt.Fprintln(w, "Modify: ", e.ModTime)
+	fmt.Fprintln(w, "Type: ", tipe)
+	fmt.Fprintln(w, "UserID: ", e.Uid)
+	fmt.Fprintln(w, "GroupID: ", e.Gid)
+	if !e.IsDir() {
+		fmt.Fprintln(w, "Checksum: ", e.Checksum)
+	}
+	return nil
+}
+
+// Listing is the content of a directory, printed by ls.
+type Listing struct {
+	Entries []Entry
+	Long    bool // Print the details of the entries as text.
+}
+
+func (l Listing) MarshalJSON() ([]byte, error) {
+	if l.Entries == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l.Entries)
+}
+
+func (l Listing) entries() []interface{} {
+	entries := make([]interface{}, len(l.Entries))
+	for i, entry := range l.Entries {
+		entries[i] = entry
+	}
+	return entries
+}
+
+// WriteText writes the names of the entries, directories in color, with
+// their mode, owner, size and modification time when Long is set.
+func (l Listing) WriteText(w io.Writer) error {
+	for _, entry := range l.Entries {
+		display := entry.Name
+		if entry.IsDir() {
+			display = fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorBlue, entry.Name)
+		}
+
+		if l.Long {
+			fmt.Fprintf(w, "%s %5d %5d %10d %s %s\n", entry.Mode, entry.Uid, entry.Gid, entry.Size, entry.ModTime.Format("Jan _2 15:04"), display)
+		} else {
+			fmt.Fprintln(w, display)
+		}
+	}
+	return nil
+}
+
+// ChecksumResult is the sha256 of a file, printed by sha256sum.
+type ChecksumResult struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+}
+
+func (r ChecksumResult) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s  %s\n", r.Sha256, r.Path)
+	return err
+}
+
+// VerifyResult is the outcome of verify for a file.
+type VerifyResult struct {
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (r VerifyResult) WriteText(w io.Writer) error {
+	status := "OK"
+	if !r.OK {
+		status = "FAILED"
+	}
+	_, err := fmt.Fprintf(w, "%s: %s\n", r.Path, status)
+	return err
+}
@@ -0,0 +1,180 @@
+package fsys
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// Less pages through the file name on the terminal, reading its lines only
+// as they are shown. The file is written as is when stdout is not a
+// terminal.
+func (fs *Filesystem) Less(ctx context.Context, sess *session.Session, streams Streams, name string) error {
+	f, err := fs.openRanged(ctx, sess, name)
+	if err != nil {
+		return fmt.Errorf("less: %w", err)
+	}
+
+	out, ok := streams.Stdout.(*os.File)
+	if !ok || !term.IsTerminal(int(out.Fd())) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return copyRange(streams.Stdout, f, 0, f.Size())
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("less: %w", err)
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+
+	p := &pager{
+		out:  out,
+		name: name,
+		r:    bufio.NewReaderSize(io.NewSectionReader(f, 0, f.Size()), rangeBlock),
+	}
+	err = p.run()
+	// The screen is left as it was, below the prompt.
+	fmt.Fprint(out, "\r\x1b[2K")
+	if err != nil {
+		return fmt.Errorf("less: %s: %w", name, err)
+	}
+	return nil
+}
+
+// pager shows the lines of a file one screen at a time.
+type pager struct {
+	out  *os.File
+	name string
+	r    *bufio.Reader // The rest of the file, read as more lines are shown.
+
+	lines []string // The lines read so far.
+	eof   bool     // The whole file has been read.
+	top   int      // The first line on the screen.
+}
+
+// Keys understood by the pager, as read from the terminal in raw mode.
+const (
+	keyInterrupt = "\x03"
+	keyUp        = "\x1b[A"
+	keyDown      = "\x1b[B"
+	keyPageUp    = "\x1b[5~"
+	keyPageDown  = "\x1b[6~"
+)
+
+// run draws the screen and handles the keys until the pager is quit.
+func (p *pager) run() error {
+	key := make([]byte, 8)
+	for {
+		rows, err := p.draw()
+		if err != nil {
+			return err
+		}
+
+		n, err := os.Stdin.Read(key)
+		if err != nil {
+			return err
+		}
+
+		switch string(key[:n]) {
+		case "q", "Q", keyInterrupt:
+			return nil
+		case " ", "f", keyPageDown:
+			p.scroll(rows)
+		case "b", keyPageUp:
+			p.scroll(-rows)
+		case "j", "\r", "\n", keyDown:
+			p.scroll(1)
+		case "k", "y", keyUp:
+			p.scroll(-1)
+		case "g", "<":
+			p.top = 0
+		case "G", ">":
+			if err := p.load(-1); err != nil {
+				return err
+			}
+			p.top = len(p.lines) - rows
+			if p.top < 0 {
+				p.top = 0
+			}
+		}
+	}
+}
+
+// scroll moves the screen by n lines, without going past the last line.
+func (p *pager) scroll(n int) {
+	p.top += n
+	if p.top < 0 {
+		p.top = 0
+	}
+	if p.eof && p.top >= len(p.lines) {
+		p.top = len(p.lines) - 1
+		if p.top < 0 {
+			p.top = 0
+		}
+	}
+}
+
+// load reads lines until n are known, or the whole file when n is negative.
+func (p *pager) load(n int) error {
+	for !p.eof && (n < 0 || len(p.lines) < n) {
+		line, err := p.r.ReadString('\n')
+		if err == io.EOF {
+			p.eof = true
+			if line == "" {
+				break
+			}
+		} else if err != nil {
+			return err
+		}
+		p.lines = append(p.lines, strings.TrimRight(line, "\r\n"))
+	}
+	return nil
+}
+
+// draw shows the lines from top on the screen, with a status line, and
+// returns the number of lines shown per screen.
+func (p *pager) draw() (int, error) {
+	width, height, err := term.GetSize(int(p.out.Fd()))
+	if err != nil || height < 2 {
+		width, height = 80, 24
+	}
+	rows := height - 1
+
+	if err := p.load(p.top + rows); err != nil {
+		return rows, err
+	}
+
+	var screen bytes.Buffer
+	screen.WriteString("\x1b[H\x1b[2J")
+	for i := p.top; i < p.top+rows; i++ {
+		if i < len(p.lines) {
+			line := strings.ReplaceAll(p.lines[i], "\t", "    ")
+			if len(line) > width {
+				line = line[:width]
+			}
+			screen.WriteString(line)
+		} else {
+			screen.WriteString("~")
+		}
+		screen.WriteString("\r\n")
+	}
+
+	status := ":"
+	if p.top == 0 {
+		status = p.name
+	}
+	if p.eof && p.top+rows >= len(p.lines) {
+		status = "(END)"
+	}
+	fmt.Fprintf(&screen, "\x1b[7m%s\x1b[0m", status)
+
+	_, err = p.out.Write(screen.Bytes())
+	return rows, err
+}
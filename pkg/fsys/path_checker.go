@@ -23,12 +23,12 @@ func (fs *Filesystem) CheckCPPath(pathSource, pathDest string) error {
 
 	_, err := fs.Stat(pathSource)
 	if err != nil {
-		return fmt.Errorf("cp: %s: %s", err.Error(), constant.ErrPathNotFound.Error())
+		return fmt.Errorf("cp: %s: %w", err.Error(), constant.ErrPathNotFound)
 	}
 
 	_, err = fs.Stat(pathDest)
 	if err == nil {
-		return fmt.Errorf("cp: %s", constant.ErrPathNotFound.Error())
+		return fmt.Errorf("cp: %w", constant.ErrPathNotFound)
 	}
 
 	return nil
@@ -37,12 +37,12 @@ func (fs *Filesystem) CheckCPPath(pathSource, pathDest string) error {
 func (fs *Filesystem) CheckCPRecPath(pathSource, pathDest string) error {
 	_, err := fs.Stat(pathSource)
 	if err != nil {
-		return fmt.Errorf("cp: %s: %s", err.Error(), constant.ErrPathNotFound.Error())
+		return fmt.Errorf("cp: %s: %w", err.Error(), constant.ErrPathNotFound)
 	}
 
 	_, err = fs.searchFS(pathDest)
 	if err != nil {
-		return fmt.Errorf("cp: %s: %s", err.Error(), constant.ErrPathNotFound.Error())
+		return fmt.Errorf("cp: %s: %w", err.Error(), constant.ErrPathNotFound)
 	}
 
 	return nil
@@ -51,12 +51,12 @@ func (fs *Filesystem) CheckCPRecPath(pathSource, pathDest string) error {
 func (fs *Filesystem) CheckUploadPath(pathSource, pathDest string) error {
 	_, err := os.Stat(pathSource)
 	if err != nil {
-		return fmt.Errorf("%s: %s", err.Error(), constant.ErrPathNotFound.Error())
+		return fmt.Errorf("%s: %w", err.Error(), constant.ErrPathNotFound)
 	}
 
 	_, err = fs.Stat(pathDest)
 	if err == nil {
-		return fmt.Errorf("%s", constant.ErrAlreadyExists.Error())
+		return constant.ErrAlreadyExists
 	}
 
 	return nil
@@ -70,7 +70,7 @@ func (fs *Filesystem) CheckUploadRecPath(pathSource, pathDest string) error {
 
 	_, err = fs.searchFS(pathDest)
 	if err != nil {
-		return fmt.Errorf("upload: %s: %s", err.Error(), constant.ErrPathNotFound.Error())
+		return fmt.Errorf("upload: %s: %w", err.Error(), constant.ErrPathNotFound)
 	}
 
 	return nil
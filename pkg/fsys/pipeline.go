@@ -0,0 +1,277 @@
+package fsys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// Redirect sends a stream of a command to or from a file. Targets are paths
+// of the virtual Filesystem unless prefixed with constant.HostPrefix.
+type Redirect struct {
+	Kind   lexer.TokenKind
+	Target string
+}
+
+// Stage is one command of a pipeline.
+type Stage struct {
+	Args      []string
+	Redirects []Redirect
+}
+
+// ParsePipeline groups the tokens of a command line into the stages of a
+// pipeline.
+func ParsePipeline(tokens []lexer.Token) ([]Stage, error) {
+	var stages []Stage
+	var stage Stage
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Kind {
+		case lexer.Word:
+			stage.Args = append(stage.Args, token.Value)
+		case lexer.Pipe:
+			if len(stage.Args) == 0 {
+				return nil, syntaxError(token)
+			}
+			stages = append(stages, stage)
+			stage = Stage{}
+		default:
+			if i+1 >= len(tokens) || tokens[i+1].Kind != lexer.Word {
+				return nil, syntaxError(token)
+			}
+			stage.Redirects = append(stage.Redirects, Redirect{Kind: token.Kind, Target: tokens[i+1].Value})
+			i++
+		}
+	}
+
+	if len(stage.Args) == 0 {
+		if len(stages) > 0 || len(stage.Redirects) > 0 {
+			return nil, errors.New("syntax error: unexpected end of line")
+		}
+		return nil, nil
+	}
+	return append(stages, stage), nil
+}
+
+func syntaxError(token lexer.Token) error {
+	return fmt.Errorf("syntax error near unexpected token `%s'", token.Value)
+}
+
+// ParseList splits the tokens of a command line into pipelines separated
+// by semicolons or ampersands.
+func ParseList(tokens []lexer.Token) ([][]Stage, error) {
+	var pipelines [][]Stage
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && tokens[i].Kind != lexer.Semicolon && tokens[i].Kind != lexer.Background {
+			continue
+		}
+
+		stages, err := ParsePipeline(tokens[start:i])
+		if err != nil {
+			return nil, err
+		}
+		if len(stages) == 0 && i < len(tokens) {
+			return nil, syntaxError(tokens[i])
+		}
+		if len(stages) > 0 {
+			pipelines = append(pipelines, stages)
+		}
+		start = i + 1
+	}
+	return pipelines, nil
+}
+
+// Run runs the stages of a pipeline one after another, feeding the output of
+// each stage to the next one. Errors are printed to the stderr of their stage
+// and the error of the last stage is returned.
+func (s *Shell) Run(ctx context.Context, sess *session.Session, stages []Stage, publishing model.Publishing) error {
+	return s.runInput(ctx, sess, stages, publishing, nil)
+}
+
+// runInput runs the stages like Run, the first one reading stdin, nil for
+// no input.
+func (s *Shell) runInput(ctx context.Context, sess *session.Session, stages []Stage, publishing model.Publishing, stdin io.Reader) error {
+	var input io.Reader = strings.NewReader("")
+	if stdin != nil {
+		input = stdin
+	}
+	var err error
+
+	for i, stage := range stages {
+		var output *bytes.Buffer
+		streams := Streams{
+			Stdin:  input,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+			Format: s.Format,
+		}
+		if i < len(stages)-1 {
+			output = &bytes.Buffer{}
+			streams.Stdout = output
+		}
+
+		err = s.runStage(ctx, sess, stage, publishing, streams)
+		if err != nil && !errors.Is(err, errReported) && !isExit(err) {
+			writeError(os.Stderr, s.Format, stage.Args[0], err)
+		}
+
+		input = strings.NewReader("")
+		if output != nil {
+			input = output
+		}
+	}
+	return err
+}
+
+// errReported marks an error already printed to the stderr of its command.
+var errReported = errors.New("reported")
+
+// ExitError is returned by exit. The shell runs no more commands, and the
+// caller exits with Status once the jobs and replicated operations are done.
+type ExitError struct {
+	Status int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit %d", e.Status)
+}
+
+// isExit reports whether err comes from exit, and is then not printed.
+func isExit(err error) bool {
+	var exit *ExitError
+	return errors.As(err, &exit)
+}
+
+// runStage opens the redirections of stage and runs its command.
+func (s *Shell) runStage(ctx context.Context, sess *session.Session, stage Stage, publishing model.Publishing, streams Streams) error {
+	var closers []io.Closer
+	closeAll := func() error {
+		var firstErr error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i].Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	for _, redirect := range stage.Redirects {
+		err := s.openRedirect(ctx, sess, publishing, redirect, &streams, &closers)
+		if err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	_, err := s.ExecuteWith(ctx, sess, stage.Args, publishing, streams)
+	if err != nil && !errors.Is(err, errReported) && !isExit(err) {
+		// The error belongs to the stderr of the command, which may be redirected.
+		writeError(streams.Stderr, streams.Format, stage.Args[0], err)
+		err = fmt.Errorf("%w: %s", errReported, err.Error())
+	}
+
+	if cerr := closeAll(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openRedirect opens the target of redirect and sets it on streams.
+func (s *Shell) openRedirect(ctx context.Context, sess *session.Session, publishing model.Publishing, redirect Redirect, streams *Streams, closers *[]io.Closer) error {
+	target := redirect.Target
+	host := strings.HasPrefix(target, constant.HostPrefix)
+	target = strings.TrimPrefix(strings.TrimPrefix(target, constant.HostPrefix), constant.VFSPrefix)
+
+	if redirect.Kind == lexer.RedirectIn {
+		var data []byte
+		var err error
+		if host {
+			data, err = os.ReadFile(target)
+		} else {
+			data, err = s.readRedirect(ctx, sess, target)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", redirect.Target, err)
+		}
+		streams.Stdin = bytes.NewReader(data)
+		return nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if redirect.Kind == lexer.AppendOut || redirect.Kind == lexer.AppendErr {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	var w io.WriteCloser
+	if host {
+		f, err := os.OpenFile(target, flag, 0o644)
+		if err != nil {
+			return err
+		}
+		w = f
+	} else {
+		f, err := s.Fs.OpenFile(ctx, sess, publishing, target, flag, 0o644)
+		if err != nil {
+			return fmt.Errorf("%s: %w", redirect.Target, err)
+		}
+		w = &fileSink{f: f}
+	}
+	*closers = append(*closers, w)
+
+	if redirect.Kind == lexer.RedirectErr || redirect.Kind == lexer.AppendErr {
+		streams.Stderr = w
+	} else {
+		streams.Stdout = w
+	}
+	return nil
+}
+
+// readRedirect returns the content of a file of the virtual Filesystem.
+func (s *Shell) readRedirect(ctx context.Context, sess *session.Session, name string) ([]byte, error) {
+	f, err := s.Fs.Open(ctx, sess, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return afero.ReadAll(f)
+}
+
+// fileSink collects the output of a command and writes it to a file of the
+// virtual Filesystem in one replicated write when the command ends. An empty
+// output is replicated as a truncate to the size of the file, so that the
+// other clients create or truncate it too.
+type fileSink struct {
+	bytes.Buffer
+	f *File
+}
+
+func (s *fileSink) Close() error {
+	var err error
+	if s.Len() > 0 {
+		_, err = s.f.Write(s.Bytes())
+	} else {
+		var info os.FileInfo
+		info, err = s.f.Stat()
+		if err == nil {
+			err = s.f.Truncate(info.Size())
+		}
+	}
+	if err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,286 @@
+package fsys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// rangeBlock is the size of the ranges read at once by head, tail and less.
+const rangeBlock = 64 * 1024
+
+// UnlockedSession returns a copy of sess for a command running outside the
+// volume queue.
+func UnlockedSession(sess *session.Session) *session.Session {
+	unlocked := *sess
+	unlocked.Unlocked = true
+	return &unlocked
+}
+
+// volumeDo returns the function running fn on the volume: through Do when
+// the command of sess runs outside the volume queue, else at once since the
+// caller already holds the volume.
+func (fs *Filesystem) volumeDo(sess *session.Session) func(fn func() error) error {
+	if sess.Unlocked {
+		return fs.vol.Do
+	}
+	return func(fn func() error) error {
+		return fn()
+	}
+}
+
+// rangedFile reads a file of the virtual Filesystem by ranges without
+// loading it: from the volume when its content is loaded, else from the
+// intermediate service with HTTP range requests. Ranged reads are not
+// checked against the checksum of the file, which covers the whole content.
+type rangedFile struct {
+	ctx     context.Context
+	sess    *session.Session
+	fs      *Filesystem
+	do      func(fn func() error) error // Runs fn on the volume.
+	path    string                      // The absolute path of the file.
+	size    int64
+	modTime time.Time
+	loaded  bool
+}
+
+// openRanged opens the file name for ranged reads, after checking that the
+// session user may read it. The file is looked up on the volume, then the
+// size of a file never loaded is asked to the intermediate service.
+func (fs *Filesystem) openRanged(ctx context.Context, sess *session.Session, name string) (*rangedFile, error) {
+	f, err := fs.statRanged(ctx, sess, name)
+	if err != nil {
+		return nil, err
+	}
+	if f.size < 0 {
+		_, f.size, err = fetchRange(ctx, sess, f.path, "0-0")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// statRanged returns the file name as found on the volume, with a size of
+// -1 when it was never loaded.
+func (fs *Filesystem) statRanged(ctx context.Context, sess *session.Session, name string) (*rangedFile, error) {
+	f := &rangedFile{ctx: ctx, sess: sess, fs: fs, do: fs.volumeDo(sess), size: -1}
+	err := f.do(func() error {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s: Is a directory", name)
+		}
+		if sess.Role() == "Normal" {
+			if err := fs.requireAccess(sess, name, "r--"); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+		f.path = fs.absPath(name)
+		f.modTime = info.ModTime()
+		if info.Size() > 0 || fs.MFS.Checksum(f.path) == Checksum(nil) {
+			f.loaded = true
+			f.size = info.Size()
+		} else if size, ok := fs.vol.lru().Sizes[f.path]; ok && size > 0 {
+			f.size = size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Size returns the size of the file.
+func (f *rangedFile) Size() int64 {
+	return f.size
+}
+
+// Loaded tells whether the content of the file is held by the volume.
+func (f *rangedFile) Loaded() bool {
+	return f.loaded
+}
+
+// ReadAt reads len(p) bytes at off, from the volume or with one range
+// request.
+func (f *rangedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+
+	var n int
+	if f.loaded {
+		err := f.do(func() error {
+			file, err := f.fs.MFS.Open(f.path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			n, err = file.ReadAt(p[:end-off], off)
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+	} else {
+		data, _, err := fetchRange(f.ctx, f.sess, f.path, fmt.Sprintf("%d-%d", off, end-1))
+		if err != nil {
+			return 0, err
+		}
+		n = copy(p, data)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// copyRange writes n bytes of r from off to w, reading one block at a time.
+func copyRange(w io.Writer, r io.ReaderAt, off, n int64) error {
+	buf := make([]byte, rangeBlock)
+	for n > 0 {
+		if n < int64(len(buf)) {
+			buf = buf[:n]
+		}
+
+		m, err := r.ReadAt(buf, off)
+		if m > 0 {
+			if _, werr := w.Write(buf[:m]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		off += int64(m)
+		n -= int64(m)
+	}
+	return nil
+}
+
+// fetchRange gets the bytes of an evicted file in spec, such as 0-99 or
+// -100 for the last 100 bytes, from the intermediate service. It also
+// returns the size of the whole file. A service answering with the whole
+// file instead of the range is supported.
+func fetchRange(ctx context.Context, sess *session.Session, path, spec string) ([]byte, int64, error) {
+	getFileURL := constant.Protocol + sess.Host() + constant.ApiVer + "/file/object?"
+
+	var param = url.Values{}
+	param.Add("filename", filepath.Clean(path))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getFileURL+param.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("token", sess.Token())
+	req.Header.Set("Range", "bytes="+spec)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, 0, errors.New("failed to get file data from remote")
+		}
+		size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, err
+		}
+		return data, size, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		size, _ := contentRangeSize(resp.Header.Get("Content-Range"))
+		return nil, size, nil
+	case http.StatusOK:
+		fileResp := GetFileResp{}
+		if err := json.NewDecoder(resp.Body).Decode(&fileResp); err != nil {
+			return nil, 0, errors.New("failed to unmarshal file body")
+		}
+		data, err := sliceRange(fileResp.Data, spec)
+		return data, int64(len(fileResp.Data)), err
+	}
+	return nil, 0, fmt.Errorf("%w: %s", constant.ErrRemoteData, resp.Status)
+}
+
+// contentRangeSize returns the size of the whole file from a Content-Range
+// header such as "bytes 0-99/1234".
+func contentRangeSize(header string) (int64, error) {
+	i := strings.LastIndexByte(header, '/')
+	if i < 0 || header[i+1:] == "*" {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return strconv.ParseInt(header[i+1:], 10, 64)
+}
+
+// sliceRange returns the bytes of data in the range spec.
+func sliceRange(data []byte, spec string) ([]byte, error) {
+	size := int64(len(data))
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n > size {
+			n = size
+		}
+		return data[size-n:], nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end {
+		return nil, nil
+	}
+	return data[start : end+1], nil
+}
@@ -0,0 +1,427 @@
+package fsys
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// Command Registry
+
+// OperandKind tells what a positional argument of a command refers to.
+type OperandKind int
+
+const (
+	OperandValue    OperandKind = iota // A plain value, e.g. a mode or a size.
+	OperandPath                        // A path of the virtual Filesystem.
+	OperandParent                      // A new path whose parent directory is checked.
+	OperandHostPath                    // A path of the host filesystem.
+	OperandProvider                    // A cloud storage provider.
+)
+
+// Operand is a positional argument of a command.
+type Operand struct {
+	Name      string      // The name shown in the usage.
+	Kind      OperandKind // What the operand refers to.
+	Access    string      // The permission a Normal user needs on the operand, e.g. "r--".
+	RecAccess string      // The permission needed with -r, defaults to Access.
+	Create    bool        // The path may not exist yet, its parent is then checked for -wx.
+	Variadic  bool        // The operand takes all the remaining arguments.
+	Optional  bool        // The operand may be omitted.
+}
+
+// Flag is an option of a command.
+type Flag struct {
+	Name     string // The flag as typed, e.g. "-r".
+	Value    string // The name of the value taken by the flag, empty for a switch.
+	Usage    string // What the flag does.
+	Optional bool   // A flag taking a value may be omitted.
+}
+
+// Streams are the standard streams of a command, with the format of the
+// results it writes to Stdout.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Format Format
+}
+
+// StdStreams returns the streams of the process.
+func StdStreams() Streams {
+	return Streams{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// Invocation is one parsed call of a command.
+type Invocation struct {
+	Streams
+	Ctx        context.Context
+	Sess       *session.Session
+	Publishing model.Publishing
+	Shell      *Shell            // The shell running the command, nil outside the shell.
+	Flags      map[string]string // The flags given, mapped to their value.
+	Args       []string          // The operands, without the flags.
+}
+
+// Has reports whether flag was given.
+func (inv *Invocation) Has(flag string) bool {
+	_, ok := inv.Flags[flag]
+	return ok
+}
+
+// Command describes a command: its arguments, the permissions it needs,
+// whether it is replicated and how it runs. Dispatch, usage, permission
+// checks, completion and help are all derived from it.
+type Command struct {
+	Name      string
+	Summary   string // One line description shown by help.
+	Flags     []Flag
+	Args      []Operand
+	AdminOnly bool // Normal users may not run the command.
+	Replicate bool // The command is applied when received from other clients.
+	Shell     bool // The command acts on the shell rather than the Filesystem.
+	Hidden    bool // The command is not listed by help and completion.
+	Internal  bool // The command is only applied from replicated messages.
+	Each      bool // Run once for each value of the variadic operand.
+	Unlocked  bool // The shell runs the command outside the volume queue, to wait for jobs or the intermediate service.
+
+	// Check runs extra validation before the command, after the arguments
+	// have been parsed and before the permissions are checked.
+	Check func(fs *Filesystem, inv *Invocation) error
+	// Run executes the command.
+	Run func(fs *Filesystem, inv *Invocation) error
+}
+
+var registry = map[string]*Command{}
+
+// Register adds cmd to the registry, replacing any command with the same name.
+func Register(cmd *Command) {
+	registry[cmd.Name] = cmd
+}
+
+// LookupCommand returns the registered command called name.
+func LookupCommand(name string) (*Command, bool) {
+	cmd, ok := registry[name]
+	return cmd, ok
+}
+
+// Commands returns the commands a user may type, sorted by name.
+func Commands() []*Command {
+	var cmds []*Command
+	for _, cmd := range registry {
+		if cmd.Hidden || cmd.Internal {
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+// IsReplicated reports whether a command received from another client
+// should be applied.
+func IsReplicated(name string) bool {
+	cmd, ok := registry[name]
+	return ok && cmd.Replicate
+}
+
+// Usage returns the usage text of the command.
+func (cmd *Command) Usage() string {
+	var sb strings.Builder
+	sb.WriteString("Usage : " + cmd.Name)
+	for _, flag := range cmd.Flags {
+		if flag.Value != "" {
+			sb.WriteString(fmt.Sprintf(" [%s %s]", flag.Name, flag.Value))
+		} else {
+			sb.WriteString(fmt.Sprintf(" [%s]", flag.Name))
+		}
+	}
+	for _, op := range cmd.Args {
+		if op.Variadic || op.Optional {
+			sb.WriteString(fmt.Sprintf(" [%s...]", op.Name))
+		} else {
+			sb.WriteString(fmt.Sprintf(" [%s]", op.Name))
+		}
+	}
+
+	if cmd.Summary != "" {
+		sb.WriteString("\n        " + cmd.Summary)
+	}
+	// The usages are aligned after the longest flag, such as --dry-run.
+	width := 4
+	for _, flag := range cmd.Flags {
+		if len(flag.Name) > width {
+			width = len(flag.Name)
+		}
+	}
+	for _, flag := range cmd.Flags {
+		sb.WriteString(fmt.Sprintf("\n        %-*s %s", width, flag.Name, flag.Usage))
+	}
+	return sb.String()
+}
+
+// flag returns the flag of the command called name.
+func (cmd *Command) flag(name string) (Flag, bool) {
+	for _, flag := range cmd.Flags {
+		if flag.Name == name {
+			return flag, true
+		}
+	}
+	return Flag{}, false
+}
+
+// operand returns the operand describing the i-th argument.
+func (cmd *Command) operand(i int) (Operand, bool) {
+	if len(cmd.Args) == 0 {
+		return Operand{}, false
+	}
+	if i < len(cmd.Args) {
+		return cmd.Args[i], true
+	}
+	last := cmd.Args[len(cmd.Args)-1]
+	return last, last.Variadic
+}
+
+// Parse splits args into the flags and operands of the command and
+// verifies that the number of operands matches its arguments.
+func (cmd *Command) Parse(args []string) (*Invocation, error) {
+	inv := &Invocation{
+		Flags: map[string]string{},
+	}
+	args = append([]string{}, args...)
+
+	i := 0
+	for ; i < len(args); i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+
+		flag, ok := cmd.flag(args[i])
+		if !ok {
+			break
+		}
+
+		if flag.Value == "" {
+			inv.Flags[flag.Name] = ""
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("%s: option %s requires an argument", cmd.Name, flag.Name)
+		}
+		inv.Flags[flag.Name] = args[i+1]
+		i++
+	}
+	inv.Args = args[i:]
+
+	// An explicit vfs: prefix may be given on the paths of the virtual Filesystem.
+	for j := range inv.Args {
+		op, ok := cmd.operand(j)
+		if ok && (op.Kind == OperandPath || op.Kind == OperandParent) {
+			inv.Args[j] = strings.TrimPrefix(inv.Args[j], constant.VFSPrefix)
+		}
+	}
+
+	for _, flag := range cmd.Flags {
+		if flag.Value != "" && !flag.Optional && !inv.Has(flag.Name) {
+			return nil, fmt.Errorf("%s: option %s is required", cmd.Name, flag.Name)
+		}
+	}
+
+	min, max := 0, len(cmd.Args)
+	for _, op := range cmd.Args {
+		if !op.Optional {
+			min++
+		}
+	}
+	if len(cmd.Args) > 0 && cmd.Args[len(cmd.Args)-1].Variadic {
+		max = -1
+	}
+	if len(inv.Args) < min || (max >= 0 && len(inv.Args) > max) {
+		return nil, fmt.Errorf("%s: wrong number of arguments", cmd.Name)
+	}
+	return inv, nil
+}
+
+// Authorize checks that the session user may run the command called name
+// with args, without running it.
+func (fs *Filesystem) Authorize(sess *session.Session, name string, args ...string) error {
+	cmd, ok := LookupCommand(name)
+	if !ok {
+		return fmt.Errorf("%s: Command not found", name)
+	}
+
+	inv, err := cmd.Parse(args)
+	if err != nil {
+		return err
+	}
+	inv.Sess = sess
+
+	return fs.authorize(cmd, inv)
+}
+
+// authorize runs the checks of the command and verifies the permissions of
+// the session user on each operand.
+func (fs *Filesystem) authorize(cmd *Command, inv *Invocation) error {
+	if cmd.Check != nil {
+		err := cmd.Check(fs, inv)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inv.Sess.Role() != "Normal" {
+		return nil
+	}
+	if cmd.AdminOnly {
+		return constant.ErrUnauthorizedAccess
+	}
+
+	for i, arg := range inv.Args {
+		op, _ := cmd.operand(i)
+
+		access := op.Access
+		if op.RecAccess != "" && inv.Has("-r") {
+			access = op.RecAccess
+		}
+		if access == "" {
+			continue
+		}
+
+		switch op.Kind {
+		case OperandPath:
+			// The host and the intermediate service check their own paths.
+			if strings.HasPrefix(arg, constant.HostPrefix) || strings.HasPrefix(arg, constant.RemotePrefix) {
+				continue
+			}
+			if _, err := fs.Stat(arg); err != nil && op.Create {
+				// The path is created in its parent.
+				parent := filepath.ToSlash(filepath.Dir(arg))
+				if err := fs.requireAccess(inv.Sess, parent, "-wx"); err != nil {
+					return err
+				}
+				continue
+			}
+			err := fs.requireAccess(inv.Sess, arg, access)
+			if err != nil {
+				return err
+			}
+		case OperandParent:
+			parent := filepath.ToSlash(filepath.Dir(arg))
+			err := fs.requireAccess(inv.Sess, parent, access)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requireAccess returns ErrUnauthorizedAccess unless the session user has
+// access on path.
+func (fs *Filesystem) requireAccess(sess *session.Session, path, access string) error {
+	userState := sess.User
+
+	acc, err := fs.getAccess(path, userState.UserID, userState.GroupID)
+	if err != nil {
+		return err
+	}
+
+	if !checkAccess(acc, access) {
+		return constant.ErrUnauthorizedAccess
+	}
+	return nil
+}
+
+// run authorizes and runs the command. A command marked Each runs once for
+// each value of its variadic operand, reporting the last error.
+func (fs *Filesystem) run(cmd *Command, inv *Invocation) error {
+	// An unlocked command is authorized on the volume like it reads it.
+	do := fs.volumeDo(inv.Sess)
+
+	n := len(cmd.Args)
+	if !cmd.Each || n == 0 || !cmd.Args[n-1].Variadic {
+		err := do(func() error { return fs.authorize(cmd, inv) })
+		if err != nil {
+			return err
+		}
+		return cmd.Run(fs, inv)
+	}
+
+	var lastErr error
+	for _, arg := range inv.Args[n-1:] {
+		each := *inv
+		each.Args = append(append([]string{}, inv.Args[:n-1]...), arg)
+
+		err := do(func() error { return fs.authorize(cmd, &each) })
+		if err == nil {
+			err = cmd.Run(fs, &each)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// CommandHelp is the summary of a command, with its usage when asked for
+// the command alone.
+type CommandHelp struct {
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	Usage   string `json:"usage,omitempty"`
+}
+
+// HelpList is the help printed by help.
+type HelpList []CommandHelp
+
+func (l HelpList) WriteText(w io.Writer) error {
+	for _, cmd := range l {
+		if cmd.Usage != "" {
+			fmt.Fprintln(w, cmd.Usage)
+		} else {
+			fmt.Fprintf(w, "%-10s %s\n", cmd.Name, cmd.Summary)
+		}
+	}
+	return nil
+}
+
+func (l HelpList) entries() []interface{} {
+	entries := make([]interface{}, len(l))
+	for i, cmd := range l {
+		entries[i] = cmd
+	}
+	return entries
+}
+
+// Help returns the summary of every command, or the usage of name.
+func Help(name string) (HelpList, error) {
+	if name != "" {
+		cmd, ok := LookupCommand(name)
+		if !ok || cmd.Internal {
+			return nil, fmt.Errorf("help: no help topics match '%s'", name)
+		}
+		return HelpList{{Name: cmd.Name, Summary: cmd.Summary, Usage: cmd.Usage()}}, nil
+	}
+
+	list := HelpList{}
+	for _, cmd := range Commands() {
+		list = append(list, CommandHelp{Name: cmd.Name, Summary: cmd.Summary})
+	}
+	return list, nil
+}
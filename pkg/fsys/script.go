@@ -0,0 +1,192 @@
+package fsys
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// RunLine runs one command line made of pipelines separated by semicolons,
+// each as one operation on the volume. A pipeline ending with & runs as a
+// background job. Each pipeline is expanded when it runs, so it sees the
+// variables and aliases set by the previous ones. It stops at the first
+// failing pipeline when ErrExit is set, and at exit, returning its
+// *ExitError. Errors are printed to stderr and the error of the last
+// pipeline is returned.
+func (s *Shell) RunLine(ctx context.Context, sess *session.Session, line string, publishing model.Publishing) error {
+	return s.runLine(ctx, sess, line, publishing, s.Fs.vol.Do)
+}
+
+// runLine runs a command line, each pipeline through do. A nil do means the
+// caller already holds the volume.
+func (s *Shell) runLine(ctx context.Context, sess *session.Session, line string, publishing model.Publishing, do func(func() error) error) error {
+	// The syntax of the whole line is checked before running anything, with
+	// the values of its variables not known yet.
+	tokens, err := lexer.Tokenize(line, nil)
+	if err == nil {
+		_, err = ParseList(tokens)
+	}
+	if err != nil {
+		s.status = 2
+		writeError(os.Stderr, s.Format, "", err)
+		return fmt.Errorf("%w: %s", errReported, err.Error())
+	}
+
+	commands, err := lexer.SplitCommands(line)
+	if err != nil {
+		return err
+	}
+
+	lookup := s.lookup(sess)
+	var lastErr error
+	for _, command := range commands {
+		stages, err := s.parseCommand(command.Source, lookup)
+		switch {
+		case err != nil:
+			s.status = 2
+			writeError(os.Stderr, s.Format, "", err)
+			lastErr = fmt.Errorf("%w: %s", errReported, err.Error())
+		case len(stages) == 0:
+			continue
+		case command.Background:
+			s.startJob(ctx, sess, strings.TrimSpace(command.Source), stages, publishing)
+			s.status = 0
+			lastErr = nil
+		default:
+			lastErr = s.runLocked(ctx, sess, stages, publishing, do)
+			s.status = 0
+			if lastErr != nil {
+				s.status = 1
+			}
+			var exit *ExitError
+			if errors.As(lastErr, &exit) {
+				s.status = exit.Status
+				return lastErr
+			}
+		}
+
+		if lastErr != nil && s.ErrExit {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// runLocked runs a pipeline through do, except the unlocked commands alone
+// on their line, which must not hold the volume: the commands waiting for
+// jobs, which need it, and the ones reading remote files. The redirections
+// of a Filesystem command are opened on the volume, so it then runs through
+// do as well.
+func (s *Shell) runLocked(ctx context.Context, sess *session.Session, stages []Stage, publishing model.Publishing, do func(func() error) error) error {
+	if cmd, ok := LookupCommand(stages[0].Args[0]); ok && cmd.Unlocked && len(stages) == 1 {
+		if do == nil && cmd.Shell {
+			err := fmt.Errorf("%s: cannot wait for jobs from a sourced file", cmd.Name)
+			writeError(os.Stderr, s.Format, cmd.Name, err)
+			return fmt.Errorf("%w: %s", errReported, err.Error())
+		}
+		if do != nil && (cmd.Shell || len(stages[0].Redirects) == 0) {
+			return s.runInput(ctx, UnlockedSession(sess), stages, publishing, s.Stdin)
+		}
+	}
+
+	if do == nil {
+		return s.Run(ctx, sess, stages, publishing)
+	}
+	return do(func() error {
+		return s.Run(ctx, sess, stages, publishing)
+	})
+}
+
+// parseCommand expands the variables and aliases of a command and groups
+// its tokens into the stages of a pipeline.
+func (s *Shell) parseCommand(command string, lookup lexer.LookupFunc) ([]Stage, error) {
+	tokens, err := lexer.Tokenize(command, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err = s.expandAliases(tokens, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePipeline(tokens)
+}
+
+// RunScript runs the command lines read from r, each pipeline as one
+// operation on the volume. A line ending with a backslash continues on the
+// next one. The background jobs of the script are waited for at its end.
+func (s *Shell) RunScript(ctx context.Context, sess *session.Session, r io.Reader, publishing model.Publishing) error {
+	err := s.runScript(ctx, sess, r, publishing, s.Fs.vol.Do)
+	if jobErr := s.WaitJobs(nil); err == nil {
+		err = jobErr
+	}
+	return err
+}
+
+// runScript runs the command lines read from r through do, nil when the
+// caller already holds the volume. With set -e the script stops at the first
+// failing line and returns its error, otherwise the error of the last line
+// is returned.
+func (s *Shell) runScript(ctx context.Context, sess *session.Session, r io.Reader, publishing model.Publishing, do func(func() error) error) error {
+	scanner := bufio.NewScanner(r)
+
+	var line strings.Builder
+	var lastErr error
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.HasSuffix(text, "\\") && !strings.HasSuffix(text, "\\\\") {
+			line.WriteString(text + "\n")
+			continue
+		}
+		line.WriteString(text)
+
+		input := line.String()
+		line.Reset()
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+
+		lastErr = s.runLine(ctx, sess, input, publishing, do)
+		if lastErr != nil && (s.ErrExit || isExit(lastErr)) {
+			return lastErr
+		}
+	}
+
+	if line.Len() > 0 {
+		input := line.String()
+		lastErr = s.runLine(ctx, sess, input, publishing, do)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// Source runs the command lines of a file of the virtual Filesystem, or of
+// the host when the name has the host prefix, in the current shell.
+func (s *Shell) Source(ctx context.Context, sess *session.Session, publishing model.Publishing, name string) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(name, constant.HostPrefix) {
+		data, err = os.ReadFile(strings.TrimPrefix(name, constant.HostPrefix))
+	} else {
+		data, err = s.readRedirect(ctx, sess, strings.TrimPrefix(name, constant.VFSPrefix))
+	}
+	if err != nil {
+		return fmt.Errorf("source: %s: %w", name, err)
+	}
+
+	// The caller already holds the volume, so the lines run directly.
+	return s.runScript(ctx, sess, bytes.NewReader(data), publishing, nil)
+}
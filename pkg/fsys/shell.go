@@ -1,30 +1,44 @@
 package fsys
 
 import (
-	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+
+	"github.com/marcellof23/vfs-TA/pkg/history"
 )
 
 // our Shell object.
 type Shell struct {
-	Fs *Filesystem
+	Fs      *Filesystem
+	ErrExit bool             // Stop a script or command line at the first failure (set -e).
+	History *history.History // Command history of an interactive shell, nil otherwise.
+	Format  Format           // Output format of the commands and their errors.
+	Stdin   io.Reader        // Input of the unlocked commands run alone in the foreground, nil for none.
+
+	vars     map[string]string // Shell variables.
+	exported map[string]bool   // Variables marked with export.
+	aliases  map[string]string // Command names replaced by their values.
+	status   int               // Exit status of the last command line, $?.
+	jobs     *jobTable         // Background jobs.
+	oldDir   string            // Previous working directory, for cd -.
+	dirStack []string          // Directories saved by pushd, the last pushed first.
 }
 
 // InitShell initializes our Shell object.
 func InitShell(fs *Filesystem) *Shell {
 	return &Shell{
-		Fs: fs,
+		Fs:       fs,
+		vars:     map[string]string{},
+		exported: map[string]bool{},
+		aliases:  map[string]string{},
+		jobs:     &jobTable{},
 	}
 }
 
-func (s *Shell) SetFilesystem(fs *Filesystem) {
-	s.Fs = fs
-}
-
 // ClearScreen clears the terminal screen.
 func (s *Shell) ClearScreen() {
 	clear := make(map[string]func())
@@ -44,21 +58,6 @@ func (s *Shell) ClearScreen() {
 	}
 }
 
-// ChDir switches you to a different active directory.
-func (s *Shell) ChDir(_ context.Context, publish bool, dirName string) error {
-	if dirName == "/" {
-		s.Fs = root
-		return nil
-	}
-
-	fsVerified, err := s.verifyPath(dirName)
-	if err != nil {
-		return err
-	}
-	s.Fs = fsVerified
-	return nil
-}
-
 func (s *Shell) cat(filename string) {
 	segments := strings.Split(filename, "/")
 	if len(segments) == 1 {
@@ -79,3 +78,46 @@ func (s *Shell) cat(filename string) {
 		}
 	}
 }
+
+// HistoryEntry is a line of the history with the number used by !n.
+type HistoryEntry struct {
+	Number int    `json:"number"`
+	Line   string `json:"line"`
+}
+
+// HistoryList is the history printed by history.
+type HistoryList []HistoryEntry
+
+func (l HistoryList) WriteText(w io.Writer) error {
+	for _, entry := range l {
+		fmt.Fprintf(w, "%5d  %s\n", entry.Number, entry.Line)
+	}
+	return nil
+}
+
+func (l HistoryList) entries() []interface{} {
+	entries := make([]interface{}, len(l))
+	for i, entry := range l {
+		entries[i] = entry
+	}
+	return entries
+}
+
+// HistoryEntries returns the last n lines of the history, or all of them
+// when n is 0.
+func (s *Shell) HistoryEntries(n int) HistoryList {
+	list := HistoryList{}
+	if s.History == nil {
+		return list
+	}
+
+	entries := s.History.Entries()
+	start := 0
+	if n > 0 && n < len(entries) {
+		start = len(entries) - n
+	}
+	for i := start; i < len(entries); i++ {
+		list = append(list, HistoryEntry{Number: i + 1, Line: entries[i]})
+	}
+	return list
+}
@@ -0,0 +1,409 @@
+package fsys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// SyncOptions are the options of sync.
+type SyncOptions struct {
+	Delete   bool // Remove the files of the destination not in the source.
+	DryRun   bool // Report the operations without making them.
+	Checksum bool // Compare the hashes of files of the same size even when their modification times are equal.
+}
+
+// Sync makes the tree dst like the tree src, one being a directory of the
+// host with the host: prefix and the other a directory of the virtual
+// Filesystem. Only the files new or changed, by size and modification time
+// or else by hash, are copied, and the modes changed alone are set, so that
+// uploading makes the fewest replicated operations. The files of dst not in
+// src are removed with Delete.
+func (fs *Filesystem) Sync(ctx context.Context, sess *session.Session, publishing model.Publishing, src, dst string, opts SyncOptions) (SyncResult, error) {
+	result := SyncResult{Src: src, Dst: dst, DryRun: opts.DryRun}
+
+	upload := strings.HasPrefix(src, constant.HostPrefix)
+	if upload == strings.HasPrefix(dst, constant.HostPrefix) {
+		return result, errors.New("sync: one of the directories must be of the host, with the host: prefix")
+	}
+
+	s := &syncer{fs: fs, ctx: ctx, sess: sess, publishing: publishing, src: src, dst: dst, upload: upload}
+	srcEntries, err := fs.walkDiffTree(ctx, sess, src)
+	if err != nil {
+		return result, fmt.Errorf("sync: %w", err)
+	}
+
+	result.Ops, err = s.sync(srcEntries, opts)
+	if err != nil {
+		return result, fmt.Errorf("sync: %w", err)
+	}
+	return result, nil
+}
+
+// syncer makes the operations of a sync.
+type syncer struct {
+	fs         *Filesystem
+	ctx        context.Context
+	sess       *session.Session
+	publishing model.Publishing
+	src, dst   string
+	upload     bool // From the host to the virtual Filesystem.
+
+	// With Delete, only the entries of dst in owned are removed, or all of
+	// them when nil.
+	owned map[string]bool
+}
+
+// sync makes the operations turning dst into the tree srcEntries, and
+// returns those made.
+func (s *syncer) sync(srcEntries map[string]treeEntry, opts SyncOptions) ([]SyncOp, error) {
+	dstEntries, exists, err := s.walkDst()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := s.plan(srcEntries, dstEntries, exists, opts)
+	if err != nil || opts.DryRun {
+		return ops, err
+	}
+
+	var done []SyncOp
+	for _, op := range ops {
+		if err := s.apply(op, srcEntries[op.rel]); err != nil {
+			return done, fmt.Errorf("%s: %w", op.Path, err)
+		}
+		done = append(done, op)
+	}
+	return done, nil
+}
+
+// walkDst returns the entries under the destination, and whether it
+// exists. The session user must be allowed to change a destination of the
+// virtual Filesystem, or to create it.
+func (s *syncer) walkDst() (map[string]treeEntry, bool, error) {
+	if !s.upload {
+		info, err := os.Stat(s.hostPath(""))
+		if os.IsNotExist(err) {
+			return map[string]treeEntry{}, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if !info.IsDir() {
+			return nil, false, fmt.Errorf("%s: Not a directory", s.dst)
+		}
+		entries, err := s.fs.walkDiffTree(s.ctx, s.sess, s.dst)
+		return entries, true, err
+	}
+
+	name := s.vfsPath("")
+	if _, err := s.fs.Stat(name); err != nil {
+		if s.sess.Role() == "Normal" {
+			parent := filepath.ToSlash(filepath.Dir(name))
+			if err := s.fs.requireAccess(s.sess, parent, "-wx"); err != nil {
+				return nil, false, fmt.Errorf("%s: %w", s.dst, err)
+			}
+		}
+		return map[string]treeEntry{}, false, nil
+	}
+	if s.sess.Role() == "Normal" {
+		if err := s.fs.requireAccess(s.sess, name, "rwx"); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", s.dst, err)
+		}
+	}
+	entries, err := s.fs.walkDiffTree(s.ctx, s.sess, s.dst)
+	return entries, true, err
+}
+
+// plan returns the operations making the entries of dst like those of src,
+// in the order of their paths.
+func (s *syncer) plan(srcEntries, dstEntries map[string]treeEntry, exists bool, opts SyncOptions) ([]SyncOp, error) {
+	var ops []SyncOp
+	if !exists {
+		ops = append(ops, SyncOp{Op: "mkdir", Path: "./", Reason: "new"})
+	}
+
+	paths := make([]string, 0, len(srcEntries)+len(dstEntries))
+	for rel := range srcEntries {
+		paths = append(paths, rel)
+	}
+	for rel := range dstEntries {
+		if _, ok := srcEntries[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	// The content of a directory removed from dst is removed with it.
+	removed := ""
+	for _, rel := range paths {
+		srcEntry, inSrc := srcEntries[rel]
+		dstEntry, inDst := dstEntries[rel]
+		if !inSrc && removed != "" && strings.HasPrefix(rel, removed) {
+			continue
+		}
+
+		switch {
+		case !inSrc:
+			if opts.Delete && (s.owned == nil || s.owned[rel]) {
+				op := SyncOp{Op: "remove", Path: rel, rel: rel}
+				if dstEntry.isDir {
+					op.Path += "/"
+					removed = op.Path
+				}
+				ops = append(ops, op)
+			}
+			continue
+		case inDst && srcEntry.isDir != dstEntry.isDir:
+			op := SyncOp{Op: "remove", Path: rel, Reason: "type", rel: rel}
+			if dstEntry.isDir {
+				op.Path += "/"
+				removed = op.Path
+			}
+			ops = append(ops, op)
+			inDst = false
+		}
+
+		if srcEntry.isDir {
+			switch {
+			case !inDst:
+				ops = append(ops, SyncOp{Op: "mkdir", Path: rel + "/", Reason: "new", rel: rel})
+			case srcEntry.mode != dstEntry.mode:
+				ops = append(ops, SyncOp{Op: "chmod", Path: rel + "/", Reason: fmt.Sprintf("%04o", srcEntry.mode), rel: rel})
+			}
+			continue
+		}
+
+		reason := ""
+		switch {
+		case !inDst:
+			reason = "new"
+		case srcEntry.size != dstEntry.size:
+			reason = "size"
+		case opts.Checksum || !srcEntry.modTime.Equal(dstEntry.modTime):
+			same, err := s.fs.sameContent(s.ctx, s.sess, s.src, s.dst, rel, srcEntry, dstEntry)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				reason = "content"
+			}
+		}
+
+		switch {
+		case reason != "":
+			ops = append(ops, SyncOp{Op: "copy", Path: rel, Reason: reason, rel: rel})
+		case srcEntry.mode != dstEntry.mode:
+			ops = append(ops, SyncOp{Op: "chmod", Path: rel, Reason: fmt.Sprintf("%04o", srcEntry.mode), rel: rel})
+		}
+	}
+	return ops, nil
+}
+
+// apply makes op on the destination, entry being the source of a copy,
+// mkdir or chmod.
+func (s *syncer) apply(op SyncOp, entry treeEntry) error {
+	if s.upload {
+		if err := s.authorize(op, entry); err != nil {
+			return err
+		}
+	}
+	if op.Path == "./" {
+		if s.upload {
+			return s.fs.MkDir(s.ctx, s.sess, s.publishing, s.vfsPath(""))
+		}
+		return os.MkdirAll(s.hostPath(""), 0o777)
+	}
+
+	if !s.upload {
+		name := s.hostPath(op.rel)
+		switch op.Op {
+		case "mkdir":
+			if err := os.Mkdir(name, entry.mode); err != nil {
+				return err
+			}
+			return os.Chmod(name, entry.mode)
+		case "copy":
+			return s.download(op.rel, entry)
+		case "chmod":
+			return os.Chmod(name, entry.mode)
+		case "remove":
+			return os.RemoveAll(name)
+		}
+		return nil
+	}
+
+	name := s.vfsPath(op.rel)
+	perm := fmt.Sprintf("%o", entry.mode)
+	switch op.Op {
+	case "mkdir":
+		if err := s.fs.MkDir(s.ctx, s.sess, s.publishing, name); err != nil {
+			return err
+		}
+		// The directories are made with the mode 0700.
+		if entry.mode != 0o700 {
+			return s.fs.Chmod(s.ctx, s.sess, s.publishing, name, perm)
+		}
+	case "copy":
+		// The host file is uploaded as by upload, which takes a path from
+		// the root of the virtual Filesystem.
+		absName := s.fs.absPath(name)
+		err := s.fs.vol.Root().UploadFile(s.ctx, s.sess, s.publishing, s.hostPath(op.rel), absName)
+		if err != nil {
+			return err
+		}
+		// The next sync compares the file by its modification time, here and
+		// on the other clients.
+		return s.fs.Chtimes(s.ctx, s.sess, s.publishing, name, entry.modTime)
+	case "chmod":
+		return s.fs.Chmod(s.ctx, s.sess, s.publishing, name, perm)
+	case "remove":
+		if strings.HasSuffix(op.Path, "/") {
+			return s.fs.RemoveDir(s.ctx, s.sess, s.publishing, name)
+		}
+		return s.fs.RemoveFile(s.ctx, s.sess, s.publishing, name)
+	}
+	return nil
+}
+
+// authorize checks that the session user may apply op to the virtual
+// Filesystem, as the command doing it would.
+func (s *syncer) authorize(op SyncOp, entry treeEntry) error {
+	name := s.vfsPath(op.rel)
+	switch op.Op {
+	case "mkdir":
+		return s.fs.Authorize(s.sess, "mkdir", name)
+	case "copy":
+		// upload refuses to replace a file, which sync does when the
+		// session user may write it.
+		if _, err := s.fs.Stat(name); err == nil {
+			if s.sess.Role() != "Normal" {
+				return nil
+			}
+			return s.fs.requireAccess(s.sess, name, "-w-")
+		}
+		return s.fs.Authorize(s.sess, "upload", s.hostPath(op.rel), name)
+	case "chmod":
+		return s.fs.Authorize(s.sess, "chmod", fmt.Sprintf("%o", entry.mode), name)
+	case "remove":
+		if strings.HasSuffix(op.Path, "/") {
+			return s.fs.Authorize(s.sess, "rm", "-r", name)
+		}
+		return s.fs.Authorize(s.sess, "rm", name)
+	}
+	return nil
+}
+
+// download copies the file rel of the virtual Filesystem to the host,
+// reading an evicted file from the intermediate service without loading
+// it, and checks it against its stored checksum.
+func (s *syncer) download(rel string, entry treeEntry) error {
+	f, err := s.fs.openRanged(s.ctx, s.sess, s.vfsPath(rel))
+	if err != nil {
+		return err
+	}
+
+	name := s.hostPath(rel)
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.mode)
+	if err != nil {
+		return err
+	}
+
+	task := s.sess.Progress.Start("download", s.vfsPath(rel), f.Size())
+	h := sha256.New()
+	err = copyRange(io.MultiWriter(out, h, task), f, 0, f.Size())
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && entry.checksum != "" && hex.EncodeToString(h.Sum(nil)) != entry.checksum {
+		os.Remove(name)
+		err = constant.ErrChecksumMismatch
+	}
+	task.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(name, entry.mode); err != nil {
+		return err
+	}
+	// The next sync compares the file by its modification time.
+	return os.Chtimes(name, entry.modTime, entry.modTime)
+}
+
+// vfsPath returns the path of rel under the directory of the virtual
+// Filesystem synced.
+func (s *syncer) vfsPath(rel string) string {
+	root := s.dst
+	if !s.upload {
+		root = s.src
+	}
+	return path.Join(strings.TrimPrefix(root, constant.VFSPrefix), rel)
+}
+
+// hostPath returns the path of rel under the directory of the host synced.
+func (s *syncer) hostPath(rel string) string {
+	root := s.src
+	if !s.upload {
+		root = s.dst
+	}
+	return filepath.Join(strings.TrimPrefix(root, constant.HostPrefix), filepath.FromSlash(rel))
+}
+
+// SyncOp is an operation made by sync on the destination.
+type SyncOp struct {
+	Op     string `json:"op"`               // "mkdir", "copy", "chmod" or "remove".
+	Path   string `json:"path"`             // Relative to the roots, ending with a slash for a directory.
+	Reason string `json:"reason,omitempty"` // "new", "size", "content" or "type", or the mode set by chmod.
+
+	rel string // The path of the entry, without the slash of a directory.
+}
+
+// SyncResult is the result of sync: the operations made, or to be made
+// for a dry run.
+type SyncResult struct {
+	Src    string
+	Dst    string
+	DryRun bool
+	Ops    []SyncOp
+}
+
+func (r SyncResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.entries())
+}
+
+func (r SyncResult) entries() []interface{} {
+	entries := make([]interface{}, 0, len(r.Ops))
+	for _, op := range r.Ops {
+		entries = append(entries, op)
+	}
+	return entries
+}
+
+// WriteText writes an operation per line, with its reason.
+func (r SyncResult) WriteText(w io.Writer) error {
+	for _, op := range r.Ops {
+		if op.Reason != "" {
+			fmt.Fprintf(w, "%-6s %s (%s)\n", op.Op, op.Path, op.Reason)
+		} else {
+			fmt.Fprintf(w, "%-6s %s\n", op.Op, op.Path)
+		}
+	}
+	if r.DryRun {
+		fmt.Fprintf(w, "dry run: %d operations not made\n", len(r.Ops))
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package fsys
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// readInputs returns the content of the named files one after another, or
+// everything read from stdin when no file is named.
+func (fs *Filesystem) readInputs(ctx context.Context, sess *session.Session, stdin io.Reader, names []string) ([]byte, error) {
+	if len(names) == 0 {
+		if stdin == nil {
+			return nil, nil
+		}
+		return io.ReadAll(stdin)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, constant.ErrPathNotFound)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("%s: Is a directory", name)
+		}
+
+		data, err := fs.readContent(ctx, sess, fs.absPath(name))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// lines splits data into lines without their line endings.
+func lines(data []byte) []string {
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		result = append(result, scanner.Text())
+	}
+	return result
+}
+
+// Grep prints the lines of the named files, or of stdin, matching pattern.
+func (fs *Filesystem) Grep(ctx context.Context, sess *session.Session, streams Streams, pattern string, ignoreCase, invert bool, names []string) error {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("grep: %w", err)
+	}
+
+	data, err := fs.readInputs(ctx, sess, streams.Stdin, names)
+	if err != nil {
+		return fmt.Errorf("grep: %w", err)
+	}
+
+	for _, line := range lines(data) {
+		if re.MatchString(line) != invert {
+			fmt.Fprintln(streams.Stdout, line)
+		}
+	}
+	return nil
+}
+
+// Sort prints the lines of the named files, or of stdin, in order.
+func (fs *Filesystem) Sort(ctx context.Context, sess *session.Session, streams Streams, reverse bool, names []string) error {
+	data, err := fs.readInputs(ctx, sess, streams.Stdin, names)
+	if err != nil {
+		return fmt.Errorf("sort: %w", err)
+	}
+
+	sorted := lines(data)
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	} else {
+		sort.Strings(sorted)
+	}
+
+	for _, line := range sorted {
+		fmt.Fprintln(streams.Stdout, line)
+	}
+	return nil
+}
+
+// Head prints the first count lines, or bytes when bytes is set, of the
+// named files, or of stdin. Only the ranges needed are read from the files
+// not loaded.
+func (fs *Filesystem) Head(ctx context.Context, sess *session.Session, streams Streams, count int64, countBytes bool, names []string) error {
+	if len(names) == 0 {
+		if streams.Stdin == nil {
+			return nil
+		}
+		return head(streams.Stdout, bufio.NewReader(streams.Stdin), count, countBytes)
+	}
+
+	for i, name := range names {
+		f, err := fs.openRanged(ctx, sess, name)
+		if err != nil {
+			return fmt.Errorf("head: %w", err)
+		}
+
+		if len(names) > 1 {
+			if i > 0 {
+				fmt.Fprintln(streams.Stdout)
+			}
+			fmt.Fprintf(streams.Stdout, "==> %s <==\n", name)
+		}
+
+		if countBytes {
+			err = copyRange(streams.Stdout, f, 0, count)
+		} else {
+			err = head(streams.Stdout, bufio.NewReaderSize(io.NewSectionReader(f, 0, f.Size()), rangeBlock), count, false)
+		}
+		if err != nil {
+			return fmt.Errorf("head: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// head writes the first count lines, or bytes, of r to w.
+func head(w io.Writer, r *bufio.Reader, count int64, countBytes bool) error {
+	if countBytes {
+		_, err := io.CopyN(w, r, count)
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	for ; count > 0; count-- {
+		line, err := r.ReadBytes('\n')
+		if _, werr := w.Write(line); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCount returns the count given to head or tail: the bytes with -c,
+// else the lines with -n, 10 lines by default.
+func parseCount(inv *Invocation) (int64, bool, error) {
+	value, countBytes := inv.Flags["-c"], inv.Has("-c")
+	if !countBytes {
+		value = "10"
+		if inv.Has("-n") {
+			value = inv.Flags["-n"]
+		}
+	}
+
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || count < 0 {
+		return 0, false, fmt.Errorf("invalid number: %s", value)
+	}
+	return count, countBytes, nil
+}
+
+// followInterval is how often tail -f checks the file without being told
+// of a change, for the appends not replicated to this client.
+const followInterval = time.Second
+
+// Tail prints the last count lines, or bytes when bytes is set, of the file
+// name, or of stdin. Only the ranges needed are read when the file is not
+// loaded, from its end. With follow, the bytes appended to the file are then
+// printed as they arrive, until interrupted.
+func (fs *Filesystem) Tail(ctx context.Context, sess *session.Session, streams Streams, count int64, countBytes, follow bool, name string) error {
+	if name == "" {
+		if streams.Stdin == nil {
+			return nil
+		}
+
+		// Following a pipe is meaningless, it has already ended.
+		data, err := io.ReadAll(streams.Stdin)
+		if err != nil {
+			return fmt.Errorf("tail: %w", err)
+		}
+		off, err := tailOffset(bytes.NewReader(data), int64(len(data)), count, countBytes)
+		if err != nil {
+			return fmt.Errorf("tail: %w", err)
+		}
+		_, err = streams.Stdout.Write(data[off:])
+		return err
+	}
+
+	if follow && !sess.Unlocked {
+		return errors.New("tail: -f must run alone in the foreground, it would hold the volume")
+	}
+
+	f, err := fs.openRanged(ctx, sess, name)
+	if err != nil {
+		return fmt.Errorf("tail: %w", err)
+	}
+	off, err := tailOffset(f, f.Size(), count, countBytes)
+	if err != nil {
+		return fmt.Errorf("tail: %s: %w", name, err)
+	}
+	if err := copyRange(streams.Stdout, f, off, f.Size()-off); err != nil {
+		return fmt.Errorf("tail: %s: %w", name, err)
+	}
+
+	if !follow {
+		return nil
+	}
+	return fs.follow(ctx, sess, streams, name, f.Size())
+}
+
+// tailOffset returns the offset of the last count lines, or bytes, of r,
+// reading it backwards one block at a time.
+func tailOffset(r io.ReaderAt, size, count int64, countBytes bool) (int64, error) {
+	if countBytes || count <= 0 {
+		if count > size {
+			return 0, nil
+		}
+		return size - count, nil
+	}
+
+	buf := make([]byte, rangeBlock)
+	var lines int64
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+
+		block := buf[:end-start]
+		if _, err := r.ReadAt(block, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			// The newline ending the file ends the last line.
+			if block[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			lines++
+			if lines == count {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// follow prints the bytes appended to the file name after off, as the
+// volume tells of its changes, until interrupted. A file truncated is
+// printed again from its start.
+func (fs *Filesystem) follow(ctx context.Context, sess *session.Session, streams Streams, name string, off int64) error {
+	changes, stop := fs.vol.Watch()
+	defer stop()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-changes:
+		case <-ticker.C:
+		case <-interrupt:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+
+		f, err := fs.statRanged(ctx, sess, name)
+		if err != nil {
+			return fmt.Errorf("tail: %w", err)
+		}
+
+		var data []byte
+		size := f.Size()
+		if !f.Loaded() {
+			data, size, err = fetchRange(ctx, sess, f.path, fmt.Sprintf("%d-", off))
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("tail: %s: %w", name, err)
+			}
+		}
+
+		if size < off {
+			fmt.Fprintf(streams.Stderr, "tail: %s: file truncated\n", name)
+			off = 0
+			continue
+		}
+
+		if f.Loaded() {
+			err = copyRange(streams.Stdout, f, off, size-off)
+		} else {
+			_, err = streams.Stdout.Write(data)
+		}
+		if err != nil {
+			return fmt.Errorf("tail: %s: %w", name, err)
+		}
+		off = size
+	}
+}
+
+// wcBlock is the size of the ranges read at once by wc, which reads the
+// files whole.
+const wcBlock = 1024 * 1024
+
+// Wc counts the lines, words and bytes of the named files, or of stdin. The
+// size of a file is its count of bytes, so it is not read when only the
+// bytes are counted.
+func (fs *Filesystem) Wc(ctx context.Context, sess *session.Session, stdin io.Reader, names []string, opts WcOptions) (WcList, error) {
+	if !opts.Lines && !opts.Words && !opts.Bytes {
+		opts = WcOptions{Lines: true, Words: true, Bytes: true}
+	}
+	list := WcList{Options: opts}
+
+	if len(names) == 0 {
+		var counter wcCounter
+		if stdin != nil {
+			if _, err := io.Copy(&counter, stdin); err != nil {
+				return list, fmt.Errorf("wc: %w", err)
+			}
+		}
+		list.Counts = append(list.Counts, counter.counts("", opts))
+		return list, nil
+	}
+
+	var total wcCounter
+	for _, name := range names {
+		f, err := fs.openRanged(ctx, sess, name)
+		if err != nil {
+			return list, fmt.Errorf("wc: %w", err)
+		}
+
+		counter := wcCounter{bytes: f.Size()}
+		if opts.Lines || opts.Words {
+			counter.bytes = 0
+			r := bufio.NewReaderSize(io.NewSectionReader(f, 0, f.Size()), wcBlock)
+			if _, err := r.WriteTo(&counter); err != nil {
+				return list, fmt.Errorf("wc: %s: %w", name, err)
+			}
+		}
+
+		total.lines += counter.lines
+		total.words += counter.words
+		total.bytes += counter.bytes
+		list.Counts = append(list.Counts, counter.counts(name, opts))
+	}
+
+	if len(names) > 1 {
+		list.Total = total.counts("total", opts)
+	}
+	return list, nil
+}
+
+// WcOptions are the counts printed by wc, all of them when none is set.
+type WcOptions struct {
+	Lines bool
+	Words bool
+	Bytes bool
+}
+
+// wcCounter counts the lines, words and bytes written to it.
+type wcCounter struct {
+	lines, words, bytes int64
+	inWord              bool
+}
+
+func (c *wcCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			c.lines++
+		}
+
+		space := b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+		if !space && !c.inWord {
+			c.words++
+		}
+		c.inWord = !space
+	}
+	c.bytes += int64(len(p))
+	return len(p), nil
+}
+
+// counts returns the counts selected by opts for the file name.
+func (c *wcCounter) counts(name string, opts WcOptions) WcCounts {
+	counts := WcCounts{Path: name}
+	if opts.Lines {
+		counts.Lines = &c.lines
+	}
+	if opts.Words {
+		counts.Words = &c.words
+	}
+	if opts.Bytes {
+		counts.Bytes = &c.bytes
+	}
+	return counts
+}
+
+// WcCounts are the counts of a file, printed by wc. The counts not asked
+// for are nil.
+type WcCounts struct {
+	Path  string `json:"path,omitempty"` // Empty for stdin.
+	Lines *int64 `json:"lines,omitempty"`
+	Words *int64 `json:"words,omitempty"`
+	Bytes *int64 `json:"bytes,omitempty"`
+}
+
+// WcList is the result of wc, with the sum of the counts when several
+// files were counted.
+type WcList struct {
+	Counts  []WcCounts
+	Total   WcCounts
+	Options WcOptions
+}
+
+func (l WcList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.entries())
+}
+
+func (l WcList) entries() []interface{} {
+	entries := make([]interface{}, 0, len(l.Counts)+1)
+	for _, counts := range l.Counts {
+		entries = append(entries, counts)
+	}
+	if l.Total.Path != "" {
+		entries = append(entries, l.Total)
+	}
+	return entries
+}
+
+// WriteText writes the counts of each file on a line, in columns as wide as
+// the largest count.
+func (l WcList) WriteText(w io.Writer) error {
+	width := 1
+	for _, entry := range l.entries() {
+		for _, n := range entry.(WcCounts).values() {
+			if digits := len(fmt.Sprint(n)); digits > width {
+				width = digits
+			}
+		}
+	}
+
+	for _, entry := range l.entries() {
+		counts := entry.(WcCounts)
+		var line []string
+		for _, n := range counts.values() {
+			line = append(line, fmt.Sprintf("%*d", width, n))
+		}
+		if counts.Path != "" {
+			line = append(line, counts.Path)
+		}
+		fmt.Fprintln(w, strings.Join(line, " "))
+	}
+	return nil
+}
+
+// values returns the counts set, in the order lines, words and bytes.
+func (c WcCounts) values() []int64 {
+	var values []int64
+	for _, n := range []*int64{c.Lines, c.Words, c.Bytes} {
+		if n != nil {
+			values = append(values, *n)
+		}
+	}
+	return values
+}
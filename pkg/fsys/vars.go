@@ -0,0 +1,221 @@
+package fsys
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// builtinVars are the variables computed by the shell, which cannot be set.
+var builtinVars = map[string]bool{"?": true, "PWD": true, "OLDPWD": true, "USER": true}
+
+// Cwd returns the working directory as an absolute path.
+func (s *Shell) Cwd() string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(s.Fs.GetRootPath(), "."), "/")
+}
+
+// Var returns the value of a shell variable and whether it is set.
+func (s *Shell) Var(name string) (string, bool) {
+	value, ok := s.vars[name]
+	return value, ok
+}
+
+// SetVar sets a shell variable, exporting it when export is set.
+func (s *Shell) SetVar(name, value string, export bool) error {
+	if !isName(name) {
+		return fmt.Errorf("`%s': not a valid identifier", name)
+	}
+	if builtinVars[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+
+	s.vars[name] = value
+	if export {
+		s.exported[name] = true
+	}
+	return nil
+}
+
+// Unset removes a shell variable.
+func (s *Shell) Unset(name string) {
+	delete(s.vars, name)
+	delete(s.exported, name)
+}
+
+// lookup returns the function expanding the variables of a command line:
+// the built-in $?, $PWD, $OLDPWD, $USER and $HOME, the directory cd goes
+// to, then the shell variables. The environment of the host is not read.
+func (s *Shell) lookup(sess *session.Session) lexer.LookupFunc {
+	return func(name string) (string, bool) {
+		switch name {
+		case "?":
+			return strconv.Itoa(s.status), true
+		case "PWD":
+			return s.Cwd(), true
+		case "OLDPWD":
+			if s.oldDir != "" {
+				return s.oldDir, true
+			}
+		case "USER":
+			if sess != nil {
+				return sess.User.Username, true
+			}
+		case "HOME":
+			return s.Home(sess), true
+		}
+
+		value, ok := s.vars[name]
+		return value, ok
+	}
+}
+
+// PrintVars prints the shell variables, only the exported ones when
+// exported is set, in a form that can be read back.
+func (s *Shell) PrintVars(w io.Writer, exported bool) {
+	names := make([]string, 0, len(s.vars))
+	for name := range s.vars {
+		if !exported || s.exported[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if exported {
+			fmt.Fprintf(w, "export %s=%s\n", name, lexer.Quote(s.vars[name]))
+		} else {
+			fmt.Fprintf(w, "%s=%s\n", name, lexer.Quote(s.vars[name]))
+		}
+	}
+}
+
+// assignment splits a NAME=value word.
+func assignment(word string) (string, string, bool) {
+	i := strings.IndexByte(word, '=')
+	if i <= 0 || !isName(word[:i]) {
+		return "", "", false
+	}
+	return word[:i], word[i+1:], true
+}
+
+// assignVars sets the variables of NAME=value words.
+func (s *Shell) assignVars(words []string, export bool) error {
+	for _, word := range words {
+		name, value, ok := assignment(word)
+		if !ok {
+			name, value = word, ""
+			if export {
+				// export NAME marks an existing variable.
+				value = s.vars[name]
+			}
+		}
+		if err := s.SetVar(name, value, export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAssignmentList tells whether every word is a NAME=value assignment.
+func isAssignmentList(words []string) bool {
+	for _, word := range words {
+		if _, _, ok := assignment(word); !ok {
+			return false
+		}
+	}
+	return len(words) > 0
+}
+
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAlias defines an alias replacing the command name by value.
+func (s *Shell) SetAlias(name, value string) error {
+	if name == "" || strings.ContainsAny(name, " \t'\"\\$|;<>/=") {
+		return fmt.Errorf("`%s': invalid alias name", name)
+	}
+	s.aliases[name] = value
+	return nil
+}
+
+// Unalias removes an alias.
+func (s *Shell) Unalias(name string) error {
+	if _, ok := s.aliases[name]; !ok {
+		return fmt.Errorf("%s: not found", name)
+	}
+	delete(s.aliases, name)
+	return nil
+}
+
+// PrintAliases prints the aliases named, or all of them, in a form that can
+// be read back.
+func (s *Shell) PrintAliases(w io.Writer, names []string) error {
+	if len(names) == 0 {
+		for name := range s.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		value, ok := s.aliases[name]
+		if !ok {
+			return fmt.Errorf("%s: not found", name)
+		}
+		fmt.Fprintf(w, "alias %s=%s\n", name, lexer.Quote(value))
+	}
+	return nil
+}
+
+// expandAliases replaces the aliased command names of tokens by the tokens
+// of their values. An alias is not expanded again inside its own value.
+func (s *Shell) expandAliases(tokens []lexer.Token, lookup lexer.LookupFunc) ([]lexer.Token, error) {
+	if len(s.aliases) == 0 {
+		return tokens, nil
+	}
+	return s.expandAliasesExcept(tokens, lookup, map[string]bool{})
+}
+
+func (s *Shell) expandAliasesExcept(tokens []lexer.Token, lookup lexer.LookupFunc, expanding map[string]bool) ([]lexer.Token, error) {
+	var result []lexer.Token
+	commandStart := true
+	for _, token := range tokens {
+		value, ok := s.aliases[token.Value]
+		if token.Kind != lexer.Word || !commandStart || !ok || expanding[token.Value] {
+			result = append(result, token)
+			commandStart = token.Kind == lexer.Pipe
+			continue
+		}
+
+		valueTokens, err := lexer.Tokenize(value, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s: %w", token.Value, err)
+		}
+
+		expanding[token.Value] = true
+		valueTokens, err = s.expandAliasesExcept(valueTokens, lookup, expanding)
+		delete(expanding, token.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, valueTokens...)
+		commandStart = false
+	}
+	return result, nil
+}
@@ -0,0 +1,48 @@
+package fsys_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+)
+
+func TestHomeVariable(t *testing.T) {
+	vol, sess := newTestVolume(t)
+	ctx := context.Background()
+	t.Setenv("HOME", "/host/home")
+
+	sh := fsys.InitShell(vol.Root())
+	lines := []string{
+		"mkdir home; mkdir home/admin",
+		"echo $HOME > /expanded; cd; echo x > marker",
+		"cd /; HOME=/docs; echo $HOME > /expanded_set; cd; echo x > marker",
+		"echo $HOST_ONLY. > /unset",
+	}
+	t.Setenv("HOST_ONLY", "host")
+	for _, line := range lines {
+		if err := sh.RunLine(ctx, sess, line, model.Publishing{}); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	adapter := fsys.NewAferoFs(ctx, sess, vol.Root(), model.Publishing{})
+	want := map[string]string{
+		"expanded":          "/home/admin\n",
+		"home/admin/marker": "x\n",
+		"expanded_set":      "/docs\n",
+		"docs/marker":       "x\n",
+		"unset":             ".\n",
+	}
+	for name, content := range want {
+		data, err := afero.ReadFile(adapter, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: got %q, want %q", name, data, content)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package fsys_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/producer"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// newTestVolume returns a volume replicating a backup directory holding
+// docs/a.txt, and an admin session.
+func newTestVolume(t *testing.T) (*fsys.Volume, *session.Session) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if err := os.MkdirAll("backup/docs", 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("backup/docs/a.txt", []byte("hello\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	sess := session.New(nil, model.UserState{Username: "admin", Role: "Admin", UserID: 1055, GroupID: 1055, Token: "t"}, nil, producer.New(logger), logger)
+
+	vol := fsys.NewVolume(fsys.DefaultOptions(1024 * 1024))
+	t.Cleanup(vol.Close)
+	return vol, sess
+}
+
+// applyReplicated applies msg as the subscriber does when another client
+// replicates an operation.
+func applyReplicated(ctx context.Context, sess *session.Session, vol *fsys.Volume, msg pubsub_notify.MessageCommand) error {
+	comms, err := msg.Command()
+	if err != nil {
+		return err
+	}
+	return vol.Do(func() error {
+		switch comms[0] {
+		case "upload-sync":
+			return vol.Root().UploadSyncFile(ctx, sess, msg)
+		case "write-range", "truncate":
+			return vol.Root().ApplyRangeSync(ctx, sess, msg)
+		}
+		_, err := vol.Root().Execute(ctx, sess, comms, model.Publishing{})
+		return err
+	})
+}
+
+func TestVolumeShellAndSubscriber(t *testing.T) {
+	vol, sess := newTestVolume(t)
+	ctx := context.Background()
+	adapter := fsys.NewAferoFs(ctx, sess, vol.Root(), model.Publishing{})
+
+	const workers, rounds = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func(i int) { // shell
+			defer wg.Done()
+			sh := fsys.InitShell(vol.Root())
+			for j := 0; j < rounds; j++ {
+				line := fmt.Sprintf("mkdir s%d_%d; echo x > s%d_%d/f; chmod 644 s%d_%d/f", i, j, i, j, i, j)
+				if err := sh.RunLine(ctx, sess, line, model.Publishing{}); err != nil {
+					t.Errorf("%s: %v", line, err)
+				}
+			}
+		}(i)
+		go func(i int) { // subscriber
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				// as the other clients send them
+				name := fmt.Sprintf("r%d_%d", i, j)
+				msgs := []pubsub_notify.MessageCommand{
+					{Args: []string{"upload-sync", name}, Buffer: []byte("abc"), Checksum: fsys.Checksum([]byte("abc")), FileMode: 0o644},
+					{Args: []string{"write-range", "/" + name}, Offset: 3, Length: 3, Buffer: []byte("def"), Checksum: fsys.Checksum([]byte("abcdef")), FileMode: 0o644},
+					{Args: []string{"truncate", "/" + name}, Length: 2, Checksum: fsys.Checksum([]byte("ab")), FileMode: 0o644},
+				}
+				for _, msg := range msgs {
+					if err := applyReplicated(ctx, sess, vol, msg); err != nil {
+						t.Errorf("%s: %v", msg.Args[0], err)
+					}
+				}
+			}
+		}(i)
+		go func(i int) { // adapter
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				name := fmt.Sprintf("docs/w%d_%d", i, j)
+				if err := afero.WriteFile(adapter, name, []byte("x"), 0o644); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+				if _, err := afero.ReadDir(adapter, "docs"); err != nil {
+					t.Errorf("docs: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		for j := 0; j < rounds; j++ {
+			data, err := afero.ReadFile(adapter, fmt.Sprintf("r%d_%d", i, j))
+			if err != nil || string(data) != "ab" {
+				t.Errorf("r%d_%d: got %q, %v", i, j, data, err)
+			}
+			if _, err := adapter.Stat(fmt.Sprintf("s%d_%d/f", i, j)); err != nil {
+				t.Errorf("s%d_%d/f: %v", i, j, err)
+			}
+		}
+	}
+	entries, err := afero.ReadDir(adapter, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := workers*rounds + 1; len(entries) != want {
+		t.Errorf("docs: got %d entries, want %d", len(entries), want)
+	}
+}
+
+func TestVolumeReload(t *testing.T) {
+	vol, sess := newTestVolume(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 10; j++ {
+			if err := vol.Reload(); err != nil {
+				t.Errorf("reload: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			// the root read outside the queue is the one before or after a reload
+			adapter := fsys.NewAferoFs(ctx, sess, vol.Root(), model.Publishing{})
+			if _, err := afero.ReadFile(adapter, "docs/a.txt"); err != nil {
+				t.Errorf("docs/a.txt: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if _, err := vol.Root().Stat("docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVolumeClose(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	vol.Close()
+
+	if err := vol.Do(func() error { return nil }); err != constant.ErrVolumeClosed {
+		t.Fatalf("got %v, want %v", err, constant.ErrVolumeClosed)
+	}
+}
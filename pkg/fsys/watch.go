@@ -0,0 +1,375 @@
+package fsys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// Defaults of watch.
+const (
+	watchDebounce = 500 * time.Millisecond // The quiet time after a change before pushing.
+	watchInterval = 2 * time.Second        // The time between two scans when polling.
+)
+
+// WatchOptions are the options of watch.
+type WatchOptions struct {
+	Debounce  time.Duration // The quiet time after a change before pushing, watchDebounce when zero.
+	Interval  time.Duration // The time between two scans when polling, watchInterval when zero.
+	Poll      bool          // Scan the directory instead of using inotify, as for network mounts.
+	StateFile string        // Where the pushed files are recorded, under $XDG_DATA_HOME/vfs/watch when empty.
+}
+
+// parseWatchOptions returns the options given to the watch command.
+func parseWatchOptions(inv *Invocation) (WatchOptions, error) {
+	opts := WatchOptions{Poll: inv.Has("--poll"), StateFile: inv.Flags["--state"]}
+	durations := []struct {
+		flag string
+		d    *time.Duration
+	}{{"--debounce", &opts.Debounce}, {"--interval", &opts.Interval}}
+	for _, duration := range durations {
+		if !inv.Has(duration.flag) {
+			continue
+		}
+		d, err := time.ParseDuration(inv.Flags[duration.flag])
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("invalid duration: %s", inv.Flags[duration.flag])
+		}
+		*duration.d = d
+	}
+	return opts, nil
+}
+
+// hostNotifier tells when the directories of the host it watches change.
+type hostNotifier interface {
+	// Add watches the directory dir, without its subdirectories.
+	Add(dir string) error
+	// Events receives a value after changes, many changes giving one value
+	// when they are not received in time.
+	Events() <-chan struct{}
+	Close() error
+}
+
+// Watch pushes the changes of the host directory hostDir to the directory
+// dst of the virtual Filesystem, with the usual replicated operations,
+// until ctx is done or the process is interrupted. It must not be called
+// on the volume, which it takes for each push.
+//
+// The directory is watched with inotify, or scanned every Interval when
+// inotify is unavailable or Poll is set. Once the changes stop for
+// Debounce, the whole directory is compared to dst as by sync, so creates,
+// modifications, renames and deletes are pushed whatever the events seen.
+// A rename is pushed as a remove and a copy. Only the files pushed by the
+// watch are removed from dst, so the files added there by others are kept.
+// They are recorded in a state file, so that the changes made while the
+// watch was stopped are pushed when it starts again.
+func (fs *Filesystem) Watch(ctx context.Context, sess *session.Session, publishing model.Publishing, streams Streams, hostDir, dst string, opts WatchOptions) error {
+	if opts.Debounce <= 0 {
+		opts.Debounce = watchDebounce
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = watchInterval
+	}
+
+	info, err := os.Stat(hostDir)
+	if err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("watch: %s: Not a directory", hostDir)
+	}
+
+	pushSess := *sess
+	pushSess.Unlocked = false
+
+	w := &watcher{
+		streams:   streams,
+		hostDir:   hostDir,
+		statePath: opts.StateFile,
+		// The pushes run on the volume, so the commands they make must not
+		// take it again.
+		syncer: syncer{
+			fs:         fs,
+			ctx:        ctx,
+			sess:       &pushSess,
+			publishing: publishing,
+			src:        constant.HostPrefix + hostDir,
+			dst:        dst,
+			upload:     true,
+		},
+	}
+	if w.statePath == "" {
+		w.statePath, err = watchStatePath(hostDir, fs.entryPath(dst))
+		if err != nil {
+			return fmt.Errorf("watch: %w", err)
+		}
+	}
+	if err := w.loadState(); err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+
+	var notifier hostNotifier
+	if !opts.Poll {
+		notifier, err = newHostNotifier()
+		if err != nil {
+			fmt.Fprintf(streams.Stderr, "watch: %v, polling every %s\n", err, opts.Interval)
+		} else {
+			defer notifier.Close()
+			w.notifier = notifier
+		}
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// The changes made while the watch was stopped are pushed first.
+	scan, err := w.push()
+	if err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+
+	var events <-chan struct{}
+	if notifier != nil {
+		events = notifier.Events()
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	debounce := time.NewTimer(opts.Debounce)
+	debounce.Stop()
+
+	// A failed push is tried again at the next tick.
+	retry := false
+	for {
+		select {
+		case <-events:
+			debounce.Reset(opts.Debounce)
+			continue
+		case <-debounce.C:
+		case <-ticker.C:
+			if notifier != nil && !retry {
+				continue
+			}
+			if notifier == nil && !retry {
+				// A scan is pushed once it did not change for an interval,
+				// so that the files being written are pushed once complete.
+				next, err := scanHostTree(w.fs, w.ctx, w.sess, w.src)
+				if err != nil || sameTree(next, w.state.Files) || !sameTree(next, scan) {
+					scan = next
+					continue
+				}
+			}
+		case <-interrupt:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+
+		scan, err = w.push()
+		retry = err != nil
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(streams.Stderr, "watch: %v\n", err)
+		}
+	}
+}
+
+// watcher pushes the changes of a host directory with a syncer.
+type watcher struct {
+	syncer
+	streams   Streams
+	hostDir   string
+	notifier  hostNotifier // Nil when polling.
+	statePath string
+	state     watchState
+}
+
+// watchState records the entries of the host directory last pushed by
+// watch, by path relative to it.
+type watchState struct {
+	Host  string                  `json:"host"`
+	Dst   string                  `json:"dst"`
+	Files map[string]watchedEntry `json:"files"`
+}
+
+// watchedEntry is a pushed entry as found on the host.
+type watchedEntry struct {
+	Dir     bool        `json:"dir,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// push makes dst like the host directory, then records the entries pushed
+// and watches the directories. It returns the entries found on the host.
+func (w *watcher) push() (map[string]watchedEntry, error) {
+	entries, err := w.fs.walkDiffTree(w.ctx, w.sess, w.src)
+	if err != nil {
+		return nil, err
+	}
+
+	w.owned = make(map[string]bool, len(w.state.Files))
+	for rel := range w.state.Files {
+		w.owned[rel] = true
+	}
+
+	var ops []SyncOp
+	err = w.fs.vol.Do(func() error {
+		var err error
+		ops, err = w.sync(entries, SyncOptions{Delete: true})
+		return err
+	})
+	if len(ops) > 0 {
+		result := SyncResult{Src: w.src, Dst: w.dst, Ops: ops}
+		if werr := writeResult(w.streams.Stdout, w.streams.Format, result); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	// After a failure, only the operations made are recorded.
+	pushed := watchedEntries(entries)
+	if err != nil {
+		pushed = w.state.Files
+		for _, op := range ops {
+			if op.Op == "remove" {
+				delete(pushed, op.rel)
+			} else if entry, ok := entries[op.rel]; ok {
+				pushed[op.rel] = watched(entry)
+			}
+		}
+	}
+	w.state.Files = pushed
+	if serr := w.saveState(); serr != nil && err == nil {
+		err = serr
+	}
+
+	if w.notifier != nil {
+		if aerr := w.notifier.Add(w.hostDir); aerr != nil && err == nil {
+			err = aerr
+		}
+		for rel, entry := range entries {
+			if entry.isDir {
+				// A directory removed meanwhile is pushed again with its parent.
+				w.notifier.Add(filepath.Join(w.hostDir, filepath.FromSlash(rel)))
+			}
+		}
+	}
+	return watchedEntries(entries), err
+}
+
+// scanHostTree returns the entries of the host directory root, given with
+// the host: prefix, as recorded by watch.
+func scanHostTree(fs *Filesystem, ctx context.Context, sess *session.Session, root string) (map[string]watchedEntry, error) {
+	entries, err := fs.walkDiffTree(ctx, sess, root)
+	if err != nil {
+		return nil, err
+	}
+	return watchedEntries(entries), nil
+}
+
+// watchedEntries returns the entries of a host tree as recorded by watch.
+func watchedEntries(entries map[string]treeEntry) map[string]watchedEntry {
+	recorded := make(map[string]watchedEntry, len(entries))
+	for rel, entry := range entries {
+		recorded[rel] = watched(entry)
+	}
+	return recorded
+}
+
+// watched returns an entry of a host tree as recorded by watch.
+func watched(entry treeEntry) watchedEntry {
+	return watchedEntry{Dir: entry.isDir, Mode: entry.mode, Size: entry.size, ModTime: entry.modTime}
+}
+
+// sameTree tells whether two scans of a host tree found the same entries.
+func sameTree(a, b map[string]watchedEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for rel, entryA := range a {
+		entryB, ok := b[rel]
+		if !ok || entryA.Dir != entryB.Dir || entryA.Mode != entryB.Mode || entryA.Size != entryB.Size || !entryA.ModTime.Equal(entryB.ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchStatePath returns the state file of the watch of hostDir into dst,
+// under $XDG_DATA_HOME/vfs/watch, defaulting to ~/.local/share.
+func watchStatePath(hostDir, dst string) (string, error) {
+	abs, err := filepath.Abs(hostDir)
+	if err != nil {
+		return "", err
+	}
+
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+
+	sum := sha256.Sum256([]byte(abs + "\x00" + dst))
+	return filepath.Join(dir, "vfs", "watch", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// loadState reads the state file. A missing file is a watch never run, and
+// the state of another watch is not used.
+func (w *watcher) loadState() error {
+	abs, err := filepath.Abs(w.hostDir)
+	if err != nil {
+		return err
+	}
+	w.state = watchState{Host: abs, Dst: w.fs.entryPath(w.dst), Files: map[string]watchedEntry{}}
+
+	data, err := os.ReadFile(w.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state watchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %w", w.statePath, err)
+	}
+	if state.Host == w.state.Host && state.Dst == w.state.Dst && state.Files != nil {
+		w.state.Files = state.Files
+	}
+	return nil
+}
+
+// saveState replaces the state file, through a temporary file so that it
+// is never left half written.
+func (w *watcher) saveState() error {
+	data, err := json.Marshal(w.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.statePath), 0o700); err != nil {
+		return err
+	}
+
+	tmp := w.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.statePath)
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package fsys
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask are the events of a watched directory telling that it changed.
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyPoll is how long, in milliseconds, the reader waits for events
+// before checking whether it was closed.
+const inotifyPoll = 200
+
+// inotifyNotifier watches directories with inotify. The events are not
+// decoded, since watch compares the whole directory after them.
+type inotifyNotifier struct {
+	fd      int
+	events  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newHostNotifier() (hostNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &inotifyNotifier{
+		fd:      fd,
+		events:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go n.read()
+	return n, nil
+}
+
+// Add watches dir. Adding a directory already watched keeps its watch.
+func (n *inotifyNotifier) Add(dir string) error {
+	_, err := unix.InotifyAddWatch(n.fd, dir, inotifyMask|unix.IN_ONLYDIR)
+	return err
+}
+
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+// read drains the events until the notifier is closed, sending a value for
+// each read.
+func (n *inotifyNotifier) read() {
+	defer close(n.stopped)
+
+	buf := make([]byte, 64*1024)
+	fds := []unix.PollFd{{Fd: int32(n.fd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-n.done:
+			return
+		default:
+		}
+
+		ready, err := unix.Poll(fds, inotifyPoll)
+		if err == unix.EINTR || (err == nil && ready == 0) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		m, err := unix.Read(n.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if m > 0 {
+			select {
+			case n.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (n *inotifyNotifier) Close() error {
+	close(n.done)
+	<-n.stopped
+	return unix.Close(n.fd)
+}
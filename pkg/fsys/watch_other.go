@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fsys
+
+import (
+	"errors"
+)
+
+// newHostNotifier fails outside Linux, where watch polls the directory.
+func newHostNotifier() (hostNotifier, error) {
+	return nil, errors.New("inotify is only available on Linux")
+}
@@ -0,0 +1,104 @@
+package fsys
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/marcellof23/vfs-TA/lib/afero"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+)
+
+// DavFs adapts a Filesystem and a user session to webdav.FileSystem, on top
+// of AferoFs: permissions are checked for the session user and writes are
+// replicated according to publishing. The contexts given by the WebDAV
+// handler end with the requests, so the operations run with ctx instead, as
+// their replication may outlive them.
+type DavFs struct {
+	afero *AferoFs
+}
+
+var _ webdav.FileSystem = &DavFs{}
+
+// NewDavFs returns a webdav.FileSystem backed by fs.
+func NewDavFs(ctx context.Context, sess *session.Session, fs *Filesystem, publishing model.Publishing) *DavFs {
+	return &DavFs{afero: NewAferoFs(ctx, sess, fs, publishing)}
+}
+
+func (d *DavFs) Mkdir(_ context.Context, name string, perm os.FileMode) error {
+	return d.afero.Mkdir(name, perm)
+}
+
+func (d *DavFs) OpenFile(_ context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := d.afero.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f}, nil
+}
+
+func (d *DavFs) RemoveAll(_ context.Context, name string) error {
+	return d.afero.RemoveAll(name)
+}
+
+func (d *DavFs) Rename(_ context.Context, oldName, newName string) error {
+	return d.afero.Rename(oldName, newName)
+}
+
+func (d *DavFs) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	return d.afero.Stat(name)
+}
+
+// davFile collects the content written by a PUT, which comes in small
+// pieces, and writes it to the file of the virtual Filesystem in one
+// replicated write before anything else is done with the file.
+type davFile struct {
+	afero.File
+	pending bytes.Buffer
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return f.pending.Write(p)
+}
+
+// flush writes the collected content to the file.
+func (f *davFile) flush() error {
+	if f.pending.Len() == 0 {
+		return nil
+	}
+	_, err := f.File.Write(f.pending.Bytes())
+	f.pending.Reset()
+	return err
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	if err := f.flush(); err != nil {
+		return nil, err
+	}
+	return f.File.Stat()
+}
+
+func (f *davFile) Close() error {
+	err := f.flush()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
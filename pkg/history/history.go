@@ -0,0 +1,243 @@
+package history
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultLimit is the number of lines kept in a history file.
+const DefaultLimit = 1000
+
+// Redacted replaces the secrets of a recorded line.
+const Redacted = "***"
+
+var (
+	// secretAssign matches NAME=value where the name suggests a secret.
+	secretAssign = regexp.MustCompile(`(?i)(\b[\w-]*(?:password|passwd|secret|token|api_?key|credential)[\w-]*=)('[^']*'|"[^"]*"|\S+)`)
+	// secretFlag matches a flag named after a secret followed by its value.
+	secretFlag = regexp.MustCompile(`(?i)(\s--?[\w-]*(?:password|passwd|secret|token|api_?key|credential)[\w-]*\s+)('[^']*'|"[^"]*"|[^\s-]\S*)`)
+)
+
+// History is the command history of a user, kept in a file readable only by
+// its owner.
+type History struct {
+	path    string
+	limit   int
+	entries []string
+}
+
+// Path returns the history file of username under $XDG_DATA_HOME/vfs/history,
+// defaulting to ~/.local/share.
+func Path(username string) (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "vfs", "history", filepath.Base(username)), nil
+}
+
+// Load reads the history file at path, keeping the last limit lines. A
+// missing file is an empty history.
+func Load(path string, limit int) (*History, error) {
+	h := &History{path: path, limit: limit}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(h.entries) > limit {
+		h.entries = h.entries[len(h.entries)-limit:]
+		return h, h.rewrite()
+	}
+	return h, nil
+}
+
+// Entries returns the recorded lines, oldest first.
+func (h *History) Entries() []string {
+	return h.entries
+}
+
+// Add records line unless it is blank, starts with a space or repeats the
+// previous line. Secrets are redacted first. It returns the recorded line
+// and whether it was recorded.
+func (h *History) Add(line string) (string, bool, error) {
+	if strings.TrimSpace(line) == "" || line[0] == ' ' || line[0] == '\t' {
+		return "", false, nil
+	}
+
+	line = Redact(strings.TrimRight(line, " \t"))
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return line, false, nil
+	}
+
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.limit {
+		h.entries = h.entries[len(h.entries)-h.limit:]
+		return line, true, h.rewrite()
+	}
+	return line, true, h.append(line)
+}
+
+// Clear removes every recorded line.
+func (h *History) Clear() error {
+	h.entries = nil
+	return h.rewrite()
+}
+
+// append adds line at the end of the history file.
+func (h *History) append(line string) error {
+	err := os.MkdirAll(filepath.Dir(h.path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rewrite replaces the history file with the recorded lines.
+func (h *History) rewrite() error {
+	err := os.MkdirAll(filepath.Dir(h.path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, line := range h.entries {
+		fmt.Fprintln(w, line)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// Redact replaces the values of the arguments of line that look like
+// secrets, such as PASSWORD=value or --token value.
+func Redact(line string) string {
+	line = secretAssign.ReplaceAllString(line, "${1}"+Redacted)
+	return strings.TrimPrefix(secretFlag.ReplaceAllString(" "+line, "${1}"+Redacted), " ")
+}
+
+// Expand replaces the history references of line: !! is the previous line,
+// !n the line numbered n, !-n the nth previous line and !prefix the last line
+// starting with prefix. References are not expanded inside single quotes or
+// after a backslash. It returns the expanded line and whether it changed.
+func (h *History) Expand(line string) (string, bool, error) {
+	if !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+
+	var out strings.Builder
+	changed := false
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			out.WriteByte(c)
+			out.WriteByte(line[i+1])
+			i++
+			continue
+		case c == '\'':
+			quoted = !quoted
+		case c == '!' && !quoted && i+1 < len(line) && !isEventEnd(line[i+1]):
+			end := i + 2
+			if line[i+1] != '!' {
+				for end < len(line) && !isEventEnd(line[end]) {
+					end++
+				}
+			}
+
+			event := line[i+1 : end]
+			entry, err := h.event(event)
+			if err != nil {
+				return "", false, err
+			}
+			out.WriteString(entry)
+			changed = true
+			i = end - 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String(), changed, nil
+}
+
+// event returns the line referenced by the history event after a !.
+func (h *History) event(event string) (string, error) {
+	notFound := fmt.Errorf("!%s: event not found", event)
+
+	if event == "!" {
+		if len(h.entries) == 0 {
+			return "", notFound
+		}
+		return h.entries[len(h.entries)-1], nil
+	}
+
+	if n, err := strconv.Atoi(event); err == nil {
+		if n < 0 {
+			n = len(h.entries) + n + 1
+		}
+		if n < 1 || n > len(h.entries) {
+			return "", notFound
+		}
+		return h.entries[n-1], nil
+	}
+
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(h.entries[i], event) {
+			return h.entries[i], nil
+		}
+	}
+	return "", notFound
+}
+
+// isEventEnd tells whether c ends a history event.
+func isEventEnd(c byte) bool {
+	return strings.IndexByte(" \t\n=(;|&<>\"'", c) >= 0
+}
@@ -0,0 +1,354 @@
+package lexer
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnterminatedQuote = errors.New("unexpected EOF while looking for matching quote")
+	ErrUnterminatedBrace = errors.New("unexpected EOF while looking for matching '}'")
+	ErrTrailingBackslash = errors.New("unexpected EOF after backslash")
+)
+
+// LookupFunc returns the value of a variable and whether it is set.
+type LookupFunc func(name string) (string, bool)
+
+// TokenKind tells whether a token is a word or an operator.
+type TokenKind int
+
+const (
+	Word        TokenKind = iota
+	Pipe                  // |
+	RedirectOut           // >
+	AppendOut             // >>
+	RedirectIn            // <
+	RedirectErr           // 2>
+	AppendErr             // 2>>
+	Semicolon             // ;
+	Background            // &
+)
+
+// Token is a word or an operator of a command line.
+type Token struct {
+	Kind  TokenKind
+	Value string
+}
+
+// Split splits a command line into words the way a POSIX shell does.
+//
+// Words are separated by blanks. Single quotes keep everything literally,
+// double quotes keep blanks and expand variables, and a backslash escapes the
+// next character. A word starting with # begins a comment running to the end
+// of the line. $NAME, ${NAME} and $? are expanded with lookup outside single
+// quotes; the expanded value is never split into more words, and an unquoted
+// word expanding to nothing is dropped. A nil lookup leaves variables unset
+// but keeps the words made only of them, whose values are not known, so that
+// a line can be checked for syntax before its variables are set.
+func Split(input string, lookup LookupFunc) ([]string, error) {
+	l := &lexer{input: []rune(input), lookup: lookup}
+	tokens, err := l.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = token.Value
+	}
+	return words, nil
+}
+
+// Tokenize splits a command line like Split but also recognizes the
+// unquoted operators |, >, >>, <, 2>, 2>>, ; and &.
+func Tokenize(input string, lookup LookupFunc) ([]Token, error) {
+	l := &lexer{input: []rune(input), lookup: lookup, operators: true}
+	return l.tokens()
+}
+
+// Command is the source of one command of a command line.
+type Command struct {
+	Source     string
+	Background bool // The command ends with &.
+}
+
+// SplitCommands splits a command line at its unquoted semicolons and
+// ampersands and returns the source of each command, without expanding
+// anything, so that every command can be tokenized when it runs with the
+// variables set by the previous ones.
+func SplitCommands(input string) ([]Command, error) {
+	l := &lexer{input: []rune(input), operators: true}
+	_, err := l.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []Command
+	start := 0
+	for _, cut := range l.cuts {
+		commands = append(commands, Command{
+			Source:     string(l.input[start:cut]),
+			Background: l.input[cut] == '&',
+		})
+		start = cut + 1
+	}
+	return append(commands, Command{Source: string(l.input[start:])}), nil
+}
+
+type lexer struct {
+	input     []rune
+	pos       int
+	lookup    LookupFunc
+	operators bool
+	cuts      []int // Positions of the semicolons and ampersands.
+}
+
+func (l *lexer) tokens() ([]Token, error) {
+	var tokens []Token
+	var word strings.Builder
+	inWord := false
+	quoted := false // The current word has quotes or escapes.
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		// An unquoted word made only of empty expansions is dropped.
+		if quoted || word.Len() > 0 || l.lookup == nil {
+			tokens = append(tokens, Token{Kind: Word, Value: word.String()})
+		}
+		word.Reset()
+		inWord = false
+		quoted = false
+	}
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case isBlank(c):
+			endWord()
+			l.pos++
+		case l.operators && (c == '|' || c == '<' || c == '>'):
+			kind := l.operator(c)
+			if inWord && !quoted && word.String() == "2" && c == '>' {
+				word.Reset()
+				inWord = false
+				if kind == AppendOut {
+					kind = AppendErr
+				} else {
+					kind = RedirectErr
+				}
+			}
+			endWord()
+			tokens = append(tokens, Token{Kind: kind, Value: kind.String()})
+		case l.operators && (c == ';' || c == '&'):
+			endWord()
+			kind := Semicolon
+			if c == '&' {
+				kind = Background
+			}
+			tokens = append(tokens, Token{Kind: kind, Value: kind.String()})
+			l.cuts = append(l.cuts, l.pos)
+			l.pos++
+		case c == '#' && !inWord:
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '\'':
+			inWord = true
+			quoted = true
+			l.pos++
+			end := l.index('\'')
+			if end < 0 {
+				return nil, ErrUnterminatedQuote
+			}
+			word.WriteString(string(l.input[l.pos:end]))
+			l.pos = end + 1
+		case c == '"':
+			inWord = true
+			quoted = true
+			l.pos++
+			err := l.doubleQuoted(&word)
+			if err != nil {
+				return nil, err
+			}
+		case c == '\\':
+			l.pos++
+			if l.pos >= len(l.input) {
+				return nil, ErrTrailingBackslash
+			}
+			// A backslash before a newline continues the line.
+			if l.input[l.pos] != '\n' {
+				word.WriteRune(l.input[l.pos])
+				inWord = true
+				quoted = true
+			}
+			l.pos++
+		case c == '$':
+			inWord = true
+			err := l.variable(&word)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			inWord = true
+			word.WriteRune(c)
+			l.pos++
+		}
+	}
+
+	endWord()
+	return tokens, nil
+}
+
+// operator reads the operator starting with c.
+func (l *lexer) operator(c rune) TokenKind {
+	l.pos++
+	switch c {
+	case '|':
+		return Pipe
+	case '<':
+		return RedirectIn
+	}
+
+	if l.pos < len(l.input) && l.input[l.pos] == '>' {
+		l.pos++
+		return AppendOut
+	}
+	return RedirectOut
+}
+
+// String returns the operator as typed.
+func (k TokenKind) String() string {
+	switch k {
+	case Pipe:
+		return "|"
+	case RedirectOut:
+		return ">"
+	case AppendOut:
+		return ">>"
+	case RedirectIn:
+		return "<"
+	case RedirectErr:
+		return "2>"
+	case AppendErr:
+		return "2>>"
+	case Semicolon:
+		return ";"
+	case Background:
+		return "&"
+	}
+	return "word"
+}
+
+// doubleQuoted reads up to the closing double quote.
+func (l *lexer) doubleQuoted(word *strings.Builder) error {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return nil
+		case '\\':
+			// Inside double quotes a backslash only escapes these characters.
+			if l.pos+1 < len(l.input) && strings.ContainsRune("\"\\$`\n", l.input[l.pos+1]) {
+				if l.input[l.pos+1] != '\n' {
+					word.WriteRune(l.input[l.pos+1])
+				}
+				l.pos += 2
+				continue
+			}
+			word.WriteRune(c)
+			l.pos++
+		case '$':
+			err := l.variable(word)
+			if err != nil {
+				return err
+			}
+		default:
+			word.WriteRune(c)
+			l.pos++
+		}
+	}
+	return ErrUnterminatedQuote
+}
+
+// variable expands the variable starting at the current $.
+func (l *lexer) variable(word *strings.Builder) error {
+	l.pos++ // skip $
+	if l.pos >= len(l.input) {
+		word.WriteRune('$')
+		return nil
+	}
+
+	var name string
+	switch c := l.input[l.pos]; {
+	case c == '{':
+		end := l.index('}')
+		if end < 0 {
+			return ErrUnterminatedBrace
+		}
+		name = string(l.input[l.pos+1 : end])
+		l.pos = end + 1
+	case c == '?':
+		name = "?"
+		l.pos++
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.input) && isNameChar(l.input[l.pos]) {
+			l.pos++
+		}
+		name = string(l.input[start:l.pos])
+	default:
+		word.WriteRune('$')
+		return nil
+	}
+
+	if l.lookup != nil {
+		value, _ := l.lookup(name)
+		word.WriteString(value)
+	}
+	return nil
+}
+
+// index returns the position of the next r from the current position, or -1.
+func (l *lexer) index(r rune) int {
+	for i := l.pos; i < len(l.input); i++ {
+		if l.input[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Join quotes args so that Split returns them unchanged.
+func Join(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quote returns s quoted for the shell when it contains special characters.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t\n'\"\\$#|&;<>()*?[]{}~`") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isBlank(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c rune) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
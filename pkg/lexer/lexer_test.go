@@ -0,0 +1,117 @@
+package lexer_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
+)
+
+func lookup(name string) (string, bool) {
+	vars := map[string]string{"OUT": "out.txt", "TWO": "a b", "EMPTY": "", "?": "1"}
+	value, ok := vars[name]
+	return value, ok
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`echo a  b`, []string{"echo", "a", "b"}},
+		{`echo 'a $OUT' "b $OUT"`, []string{"echo", "a $OUT", "b out.txt"}},
+		{`echo a\ b "c\"d" 'e\f'`, []string{"echo", "a b", `c"d`, `e\f`}},
+		{`echo $TWO ${OUT}x $?`, []string{"echo", "a b", "out.txtx", "1"}},
+		{`echo $EMPTY $UNSET x`, []string{"echo", "x"}},
+		{`echo "$EMPTY" '' x$EMPTY`, []string{"echo", "", "", "x"}},
+		{`echo a # comment`, []string{"echo", "a"}},
+		{`echo a\
+b`, []string{"echo", "ab"}},
+	}
+	for _, test := range tests {
+		got, err := lexer.Split(test.input, lookup)
+		if err != nil {
+			t.Errorf("Split(%q): %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{`echo 'a`, lexer.ErrUnterminatedQuote},
+		{`echo "a`, lexer.ErrUnterminatedQuote},
+		{`echo ${OUT`, lexer.ErrUnterminatedBrace},
+		{`echo a\`, lexer.ErrTrailingBackslash},
+	}
+	for _, test := range tests {
+		if _, err := lexer.Split(test.input, lookup); err != test.want {
+			t.Errorf("Split(%q): got %v, want %v", test.input, err, test.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	word := func(value string) lexer.Token { return lexer.Token{Kind: lexer.Word, Value: value} }
+	op := func(kind lexer.TokenKind) lexer.Token { return lexer.Token{Kind: kind, Value: kind.String()} }
+
+	tests := []struct {
+		input  string
+		lookup lexer.LookupFunc
+		want   []lexer.Token
+	}{
+		{`cat a|wc -l`, lookup, []lexer.Token{word("cat"), word("a"), op(lexer.Pipe), word("wc"), word("-l")}},
+		{`echo a > $OUT 2> err >> b 2>>e < in`, lookup, []lexer.Token{
+			word("echo"), word("a"), op(lexer.RedirectOut), word("out.txt"), op(lexer.RedirectErr), word("err"),
+			op(lexer.AppendOut), word("b"), op(lexer.AppendErr), word("e"), op(lexer.RedirectIn), word("in"),
+		}},
+		{`echo a2> b '2'>c`, lookup, []lexer.Token{word("echo"), word("a2"), op(lexer.RedirectOut), word("b"), word("2"), op(lexer.RedirectOut), word("c")}},
+		{`echo 'a|b' "c;d"`, lookup, []lexer.Token{word("echo"), word("a|b"), word("c;d")}},
+		{`a; b & c`, lookup, []lexer.Token{word("a"), op(lexer.Semicolon), word("b"), op(lexer.Background), word("c")}},
+		// The syntax check keeps the words of variables it cannot expand.
+		{`echo hi > $OUT`, nil, []lexer.Token{word("echo"), word("hi"), op(lexer.RedirectOut), word("")}},
+		{`echo hi > $EMPTY`, lookup, []lexer.Token{word("echo"), word("hi"), op(lexer.RedirectOut)}},
+	}
+	for _, test := range tests {
+		got, err := lexer.Tokenize(test.input, test.lookup)
+		if err != nil {
+			t.Errorf("Tokenize(%q): %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	got, err := lexer.SplitCommands(`a $X; b 'c;d' & e | f`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lexer.Command{
+		{Source: "a $X"},
+		{Source: " b 'c;d' ", Background: true},
+		{Source: " e | f"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	args := []string{"a", "b c", "", "it's", "$X", "a|b"}
+	got, err := lexer.Split(lexer.Join(args), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("got %q, want %q", got, args)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -19,10 +20,6 @@ const (
 	topic         = "command-log"
 )
 
-var (
-	commandLog *log.Logger
-)
-
 type Message struct {
 	Command       string
 	AbsPathSource string
@@ -30,19 +27,39 @@ type Message struct {
 	Token         string
 	FileMode      uint64
 	Order         int
+	Offset        int64
+	Length        int64
 	Uid           int
 	Gid           int
+	Checksum      string
 	Buffer        []byte
 }
 
+// Producer writes command messages to the intermediate service topic.
+type Producer struct {
+	Brokers []string
+	Topic   string
+	logger  *log.Logger
+
+	pending sync.WaitGroup // Messages produced in the background.
+	mu      sync.Mutex
+	err     error // First error of a background message since the last Wait.
+}
+
+// New creates a Producer writing to the default broker and topic.
+func New(logger *log.Logger) *Producer {
+	return &Producer{
+		Brokers: []string{brokerAddress},
+		Topic:   topic,
+		logger:  logger,
+	}
+}
+
 type Effector func(context.Context, Message) error
 
-func Retry(effector Effector, delay time.Duration) Effector {
+func (p *Producer) Retry(effector Effector, delay time.Duration) Effector {
 	return func(ctx context.Context, msg Message) error {
-		log, ok := ctx.Value("server-logger").(*log.Logger)
-		if !ok {
-			return fmt.Errorf("ERROR: logger not initiated")
-		}
+		log := p.logger
 
 		for r := 0; ; r++ {
 			err := effector(ctx, msg)
@@ -61,6 +78,46 @@ func Retry(effector Effector, delay time.Duration) Effector {
 	}
 }
 
+// Go produces msg in the background, retrying when the write fails. Wait
+// blocks until it is written.
+func (p *Producer) Go(ctx context.Context, msg Message) {
+	p.GoAcked(ctx, msg, nil)
+}
+
+// GoAcked produces msg like Go and calls acked, when set, once the broker
+// acknowledged the write or the retries failed.
+func (p *Producer) GoAcked(ctx context.Context, msg Message, acked func(err error)) {
+	r := p.Retry(p.ProduceCommand, 3*time.Second)
+
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		err := r(ctx, msg)
+		if err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+		if acked != nil {
+			acked(err)
+		}
+	}()
+}
+
+// Wait blocks until the messages produced with Go are written and returns
+// the first error since the last call.
+func (p *Producer) Wait() error {
+	p.pending.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.err
+	p.err = nil
+	return err
+}
+
 func IntermediateHealthCheck(ctx context.Context, dep *boot.Dependencies) error {
 	healthURL := constant.Protocol + dep.Config().Server.Addr + "/health"
 
@@ -132,17 +189,13 @@ func checkHealth(conf kafka.ReaderConfig) error {
 	}
 }
 
-func ProduceCommand(ctx context.Context, msg Message) error {
-
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return fmt.Errorf("ERROR: logger not initiated")
-	}
+func (p *Producer) ProduceCommand(ctx context.Context, msg Message) error {
+	log := p.logger
 
 	writer := kafka.Writer{
-		Addr:       kafka.TCP(brokerAddress),
+		Addr:       kafka.TCP(p.Brokers...),
 		BatchBytes: 1e9,
-		Topic:      topic,
+		Topic:      p.Topic,
 	}
 
 	var buff []byte
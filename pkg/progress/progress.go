@@ -0,0 +1,387 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// States of a transfer reported in the events.
+const (
+	StateStart    = "start"
+	StateProgress = "progress"
+	StateDone     = "done"
+	StateFailed   = "failed"
+)
+
+const (
+	barWidth      = 24
+	drawInterval  = 200 * time.Millisecond
+	eventInterval = time.Second
+)
+
+// Event is the state of a transfer, written as one JSON line in
+// non-interactive mode.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	Bytes       int64     `json:"bytes"`
+	Total       int64     `json:"total,omitempty"`
+	Files       int       `json:"files,omitempty"`
+	FilesTotal  int       `json:"files_total,omitempty"`
+	ChunksSent  int       `json:"chunks_sent,omitempty"`
+	ChunksAcked int       `json:"chunks_acked,omitempty"`
+	ChunksTotal int       `json:"chunks_total,omitempty"`
+	Rate        float64   `json:"rate,omitempty"` // Bytes per second.
+	ETA         float64   `json:"eta,omitempty"`  // Seconds left.
+	Error       string    `json:"error,omitempty"`
+}
+
+// Reporter shows the progress of the running transfers, as bars redrawn in
+// place on a terminal or as JSON events otherwise. A nil Reporter reports
+// nothing.
+type Reporter struct {
+	w           io.Writer
+	interactive bool
+
+	mu    sync.Mutex
+	tasks []*Task       // Running transfers, in start order.
+	lines int           // Lines of bars drawn on the terminal.
+	stop  chan struct{} // Stops the redraw loop, nil when not running.
+}
+
+// New creates a Reporter writing to w, drawing bars when interactive is set
+// and JSON events otherwise.
+func New(w io.Writer, interactive bool) *Reporter {
+	return &Reporter{w: w, interactive: interactive}
+}
+
+// ForFile creates a Reporter writing to f, drawing bars when f is a
+// terminal.
+func ForFile(f *os.File) *Reporter {
+	return New(f, term.IsTerminal(int(f.Fd())))
+}
+
+// Start begins reporting a transfer of total bytes, 0 when unknown. op names
+// the operation, such as upload, and name the file.
+func (r *Reporter) Start(op, name string, total int64) *Task {
+	if r == nil {
+		return nil
+	}
+
+	t := &Task{r: r, op: op, name: name, total: total, start: time.Now()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tasks = append(r.tasks, t)
+	if r.interactive {
+		if r.stop == nil {
+			r.stop = make(chan struct{})
+			go r.redraw(r.stop)
+		}
+		r.draw()
+	} else {
+		r.emit(t, StateStart, nil)
+	}
+	return t
+}
+
+// redraw draws the bars until stop is closed.
+func (r *Reporter) redraw(stop chan struct{}) {
+	ticker := time.NewTicker(drawInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			r.draw()
+			r.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// clear erases the bars drawn, leaving the cursor where the first one was.
+func (r *Reporter) clear() {
+	if r.lines > 0 {
+		fmt.Fprintf(r.w, "\x1b[%dA", r.lines)
+	}
+	for i := 0; i < r.lines; i++ {
+		fmt.Fprint(r.w, "\r\x1b[2K\n")
+	}
+	if r.lines > 0 {
+		fmt.Fprintf(r.w, "\x1b[%dA", r.lines)
+	}
+	r.lines = 0
+}
+
+// draw redraws the bar of every running transfer.
+func (r *Reporter) draw() {
+	if r.lines > 0 {
+		fmt.Fprintf(r.w, "\x1b[%dA", r.lines)
+	}
+	for _, t := range r.tasks {
+		fmt.Fprintf(r.w, "\r\x1b[2K%s\n", t.line(time.Now()))
+	}
+	if len(r.tasks) < r.lines {
+		// Lines left by the transfers that ended are erased.
+		for i := len(r.tasks); i < r.lines; i++ {
+			fmt.Fprint(r.w, "\r\x1b[2K\n")
+		}
+		fmt.Fprintf(r.w, "\x1b[%dA", r.lines-len(r.tasks))
+	}
+	r.lines = len(r.tasks)
+}
+
+// emit writes the event of t.
+func (r *Reporter) emit(t *Task, state string, err error) {
+	t.lastEvent = time.Now()
+	event := t.event(t.lastEvent, state)
+	if err != nil {
+		event.Error = err.Error()
+	}
+	json.NewEncoder(r.w).Encode(event)
+}
+
+// update reports a change of t, at most once per interval.
+func (r *Reporter) update(t *Task) {
+	if !r.interactive && time.Since(t.lastEvent) >= eventInterval {
+		r.emit(t, StateProgress, nil)
+	}
+}
+
+// finish stops reporting t, leaving a last line with its status.
+func (r *Reporter) finish(t *Task, err error) {
+	for i, task := range r.tasks {
+		if task == t {
+			r.tasks = append(r.tasks[:i], r.tasks[i+1:]...)
+			break
+		}
+	}
+
+	state := StateDone
+	if err != nil {
+		state = StateFailed
+	}
+
+	if !r.interactive {
+		r.emit(t, state, err)
+		return
+	}
+
+	r.clear()
+	status := state
+	if err != nil {
+		status += ": " + err.Error()
+	}
+	fmt.Fprintf(r.w, "%s  %s\n", t.summary(time.Now()), status)
+	r.draw()
+
+	if len(r.tasks) == 0 && r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// Task is a transfer being reported. A nil Task ignores every call, so
+// the transfers run the same without a Reporter.
+type Task struct {
+	r     *Reporter
+	op    string
+	name  string
+	start time.Time
+
+	// The fields below are guarded by the mutex of the Reporter.
+	bytes       int64
+	total       int64
+	files       int
+	filesTotal  int
+	chunksSent  int
+	chunksAcked int
+	chunksTotal int
+	ended       bool
+	lastEvent   time.Time
+}
+
+// Add counts n more bytes transferred.
+func (t *Task) Add(n int64) {
+	t.change(func() { t.bytes += n })
+}
+
+// Write counts the bytes of p, so a Task can be the destination of a copy.
+func (t *Task) Write(p []byte) (int, error) {
+	t.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// SetTotal sets the size of the transfer.
+func (t *Task) SetTotal(total int64) {
+	t.change(func() { t.total = total })
+}
+
+// SetBytes sets the bytes transferred, for a transfer polled for its state.
+func (t *Task) SetBytes(n int64) {
+	t.change(func() { t.bytes = n })
+}
+
+// SetFiles sets the files transferred out of total.
+func (t *Task) SetFiles(n, total int) {
+	t.change(func() { t.files, t.filesTotal = n, total })
+}
+
+// SetChunks sets the number of chunks the file is sent in.
+func (t *Task) SetChunks(total int) {
+	t.change(func() { t.chunksTotal = total })
+}
+
+// ChunkSent counts a chunk handed to the producer.
+func (t *Task) ChunkSent() {
+	t.change(func() { t.chunksSent++ })
+}
+
+// ChunkAcked counts a chunk of n bytes acknowledged by the broker.
+func (t *Task) ChunkAcked(n int64) {
+	t.change(func() {
+		t.chunksAcked++
+		t.bytes += n
+	})
+}
+
+// Finish ends the transfer, failed when err is set.
+func (t *Task) Finish(err error) {
+	if t == nil {
+		return
+	}
+
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+
+	if !t.ended {
+		t.ended = true
+		t.r.finish(t, err)
+	}
+}
+
+// change applies fn to t and reports it.
+func (t *Task) change(fn func()) {
+	if t == nil {
+		return
+	}
+
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+
+	fn()
+	if !t.ended {
+		t.r.update(t)
+	}
+}
+
+// rate returns the average speed of the transfer in bytes per second, and
+// the seconds left when the size is known.
+func (t *Task) rate(now time.Time) (float64, float64) {
+	elapsed := now.Sub(t.start).Seconds()
+	if elapsed <= 0 || t.bytes == 0 {
+		return 0, 0
+	}
+
+	rate := float64(t.bytes) / elapsed
+	eta := 0.0
+	if t.total > t.bytes {
+		eta = float64(t.total-t.bytes) / rate
+	}
+	return rate, eta
+}
+
+func (t *Task) event(now time.Time, state string) Event {
+	rate, eta := t.rate(now)
+	return Event{
+		Time:        now,
+		Op:          t.op,
+		Name:        t.name,
+		State:       state,
+		Bytes:       t.bytes,
+		Total:       t.total,
+		Files:       t.files,
+		FilesTotal:  t.filesTotal,
+		ChunksSent:  t.chunksSent,
+		ChunksAcked: t.chunksAcked,
+		ChunksTotal: t.chunksTotal,
+		Rate:        rate,
+		ETA:         eta,
+	}
+}
+
+// line returns the bar of a running transfer.
+func (t *Task) line(now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-8s %s  ", t.op, t.name)
+
+	rate, eta := t.rate(now)
+	if t.total > 0 {
+		done := float64(t.bytes) / float64(t.total)
+		if done > 1 {
+			done = 1
+		}
+		filled := int(done * barWidth)
+		b.WriteString("[" + strings.Repeat("=", filled))
+		if filled < barWidth {
+			b.WriteString(">" + strings.Repeat(" ", barWidth-filled-1))
+		}
+		fmt.Fprintf(&b, "] %3.0f%%  %s / %s", done*100, Bytes(t.bytes), Bytes(t.total))
+	} else {
+		b.WriteString(Bytes(t.bytes))
+	}
+
+	if rate > 0 {
+		fmt.Fprintf(&b, "  %s/s", Bytes(int64(rate)))
+	}
+	if eta > 0 {
+		fmt.Fprintf(&b, "  ETA %s", time.Duration(eta*float64(time.Second)).Round(time.Second))
+	}
+	b.WriteString(t.counts())
+	return b.String()
+}
+
+// summary returns the line of an ended transfer.
+func (t *Task) summary(now time.Time) string {
+	return fmt.Sprintf("%-8s %s  %s in %s%s", t.op, t.name, Bytes(t.bytes), now.Sub(t.start).Round(100*time.Millisecond), t.counts())
+}
+
+// counts returns the files and chunks of the transfer, if any.
+func (t *Task) counts() string {
+	var counts string
+	if t.filesTotal > 0 {
+		counts += fmt.Sprintf("  %d/%d files", t.files, t.filesTotal)
+	}
+	if t.chunksTotal > 0 {
+		counts += fmt.Sprintf("  %d/%d chunks", t.chunksAcked, t.chunksTotal)
+	}
+	return counts
+}
+
+// Bytes formats a size with a binary unit.
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -5,23 +5,34 @@ import (
 	"log"
 
 	"cloud.google.com/go/pubsub"
+
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
 )
 
 type MessageCommand struct {
 	ClientID    string
-	FullCommand string
+	FullCommand string   // The command quoted as typed in the shell, kept for older clients.
+	Args        []string // The command and its arguments.
 	FileMode    uint64
 	Uid         int
 	Gid         int
+	Offset      int64
+	Length      int64
+	Checksum    string
+	More        bool // A range followed by more of the same write, the only one sent without a checksum.
 	Buffer      []byte
 }
 
-func GetTopic(ctx context.Context, c *pubsub.Client, topic string) *pubsub.Topic {
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return &pubsub.Topic{}
+// Command returns the replicated command and its arguments. Messages that
+// only carry FullCommand are split with the shell lexer.
+func (m MessageCommand) Command() ([]string, error) {
+	if len(m.Args) > 0 {
+		return m.Args, nil
 	}
+	return lexer.Split(m.FullCommand, nil)
+}
 
+func GetTopic(ctx context.Context, log *log.Logger, c *pubsub.Client, topic string) *pubsub.Topic {
 	t := c.Topic(topic)
 	ok, err := t.Exists(ctx)
 	if err != nil {
@@ -4,19 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"sync"
 
 	"cloud.google.com/go/pubsub"
 	"google.golang.org/api/option"
 
 	"github.com/marcellof23/vfs-TA/boot"
+	"github.com/marcellof23/vfs-TA/pkg/lexer"
 	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
 )
 
 type Publisher struct {
 	topic *pubsub.Topic
+
+	pending sync.WaitGroup // Messages not acknowledged by the server yet.
+	mu      sync.Mutex
+	err     error // First publishing error since the last Wait.
 }
 
-func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Publisher, error) {
+func InitDefault(ctx context.Context, dep *boot.Dependencies, logger *log.Logger) (*Publisher, error) {
 	proj := dep.Config().Pubsub.Project
 	topic := dep.Config().Pubsub.Topic
 	credentialsFile := dep.Config().Pubsub.CredentialFile
@@ -25,7 +31,7 @@ func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Publisher, error
 	if err != nil {
 		log.Fatalf("Could not create pubsub Client: %v", err)
 	}
-	t := pubsub_notify.GetTopic(ctx, client, topic)
+	t := pubsub_notify.GetTopic(ctx, logger, client, topic)
 
 	pubs := &Publisher{
 		topic: t,
@@ -34,7 +40,13 @@ func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Publisher, error
 	return pubs, nil
 }
 
+// Publish sends msg to the other clients. FullCommand is filled from Args
+// when it is empty.
 func (p *Publisher) Publish(ctx context.Context, msg pubsub_notify.MessageCommand) error {
+	if msg.FullCommand == "" {
+		msg.FullCommand = lexer.Join(msg.Args)
+	}
+
 	var buff []byte
 	buff, err := json.Marshal(msg)
 	if err != nil {
@@ -42,9 +54,34 @@ func (p *Publisher) Publish(ctx context.Context, msg pubsub_notify.MessageComman
 		return err
 	}
 
-	_ = p.topic.Publish(ctx, &pubsub.Message{
+	res := p.topic.Publish(ctx, &pubsub.Message{
 		Data: buff,
 	})
 
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		if _, err := res.Get(ctx); err != nil {
+			log.Println("ERROR: failed to publish:", err)
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}()
+
 	return nil
 }
+
+// Wait blocks until the published messages are acknowledged by the server
+// and returns the first error since the last call.
+func (p *Publisher) Wait() error {
+	p.pending.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.err
+	p.err = nil
+	return err
+}
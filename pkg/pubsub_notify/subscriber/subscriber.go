@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -13,23 +12,17 @@ import (
 	"google.golang.org/api/option"
 
 	"github.com/marcellof23/vfs-TA/boot"
-	"github.com/marcellof23/vfs-TA/constant"
-	"github.com/marcellof23/vfs-TA/global"
 	"github.com/marcellof23/vfs-TA/pkg/fsys"
 	"github.com/marcellof23/vfs-TA/pkg/model"
 	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify"
+	"github.com/marcellof23/vfs-TA/pkg/session"
 )
 
 type Subscriber struct {
 	Subs *pubsub.Subscription
 }
 
-func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Subscriber, error) {
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return &Subscriber{}, fmt.Errorf("failed to get logger from context")
-	}
-
+func InitDefault(ctx context.Context, dep *boot.Dependencies, log *log.Logger) (*Subscriber, error) {
 	proj := dep.Config().Pubsub.Project
 	topic := dep.Config().Pubsub.Topic
 	credentialsFile := dep.Config().Pubsub.CredentialFile
@@ -39,7 +32,7 @@ func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Subscriber, erro
 		log.Fatalf("Could not create pubsub Client: %v", err)
 	}
 
-	t := pubsub_notify.GetTopic(ctx, client, topic)
+	t := pubsub_notify.GetTopic(ctx, log, client, topic)
 
 	sub, err := client.CreateSubscription(ctx, "command-"+uuid.New().String(), pubsub.SubscriptionConfig{
 		ExpirationPolicy: 24 * time.Hour,
@@ -57,11 +50,9 @@ func InitDefault(ctx context.Context, dep *boot.Dependencies) (*Subscriber, erro
 	return subs, nil
 }
 
-func (s *Subscriber) ListenMessage(ctx context.Context) error {
-	log, ok := ctx.Value("server-logger").(*log.Logger)
-	if !ok {
-		return fmt.Errorf("failed to get logger from context")
-	}
+// ListenMessage applies the commands published by other clients to vol.
+func (s *Subscriber) ListenMessage(ctx context.Context, sess *session.Session, vol *fsys.Volume) error {
+	log := sess.Logger
 
 	err := s.Subs.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
 		var msgCmd pubsub_notify.MessageCommand
@@ -74,16 +65,23 @@ func (s *Subscriber) ListenMessage(ctx context.Context) error {
 			PublishIntermediate: false,
 		}
 
-		clientID, _ := fsys.GetClientIDFromContext(ctx)
 		// Message is just for other clients
-		if msgCmd.ClientID != clientID {
-			comms := strings.Split(msgCmd.FullCommand, " ")
-			if _, ok := constant.CommandPubsub[comms[0]]; ok {
-				if comms[0] == "upload-sync" {
-					global.Filesys.UploadSyncFile(ctx, msgCmd)
-				} else {
-					global.Filesys.Execute(ctx, comms, publishing)
+		if msgCmd.ClientID != sess.ClientID() {
+			comms, err := msgCmd.Command()
+			if err == nil && len(comms) > 0 && fsys.IsReplicated(comms[0]) {
+				err := vol.Do(func() error {
+					if comms[0] == "upload-sync" {
+						return vol.Root().UploadSyncFile(ctx, sess, msgCmd)
+					} else if comms[0] == "write-range" || comms[0] == "truncate" {
+						return vol.Root().ApplyRangeSync(ctx, sess, msgCmd)
+					}
+					_, err := vol.Root().Execute(ctx, sess, comms, publishing)
+					return err
+				})
+				if err != nil {
+					log.Println("ERROR: ", err)
 				}
+				vol.Changed()
 			}
 		}
 
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+	"github.com/marcellof23/vfs-TA/pkg/user"
+)
+
+// writeChunk is the size of the writes made while receiving a file, each
+// replicated as one ranged write, so that the file is not held in memory
+// twice nor replicated in tiny pieces.
+const writeChunk = 1 << 20
+
+// apiParams are the parameters of an API request, from the query string
+// and, for the POST requests, the JSON body.
+type apiParams struct {
+	Path      string `json:"path"`
+	Src       string `json:"src"`
+	Dst       string `json:"dst"`
+	Mode      string `json:"mode"`
+	Recursive bool   `json:"recursive"`
+}
+
+// apiError is the body of a failed API request.
+type apiError struct {
+	Command string `json:"command"`
+	Error   string `json:"error"`
+}
+
+// apiLogin is the body of a successful login.
+type apiLogin struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// apiHandler handles an API request of the user of sess.
+type apiHandler func(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error
+
+// API returns a handler serving the Filesystem as JSON endpoints under
+// constant.ApiVer. The users log in with POST /login to get a token given as
+// "Authorization: Bearer <token>", or with basic authentication. The
+// operations are checked and replicated like the commands of the shell:
+//
+//	GET    /list?path=         list a directory
+//	GET    /stat?path=         describe a file or directory
+//	GET    /files?path=        read a file, with Range
+//	PUT    /files?path=        write a file from the body
+//	DELETE /files?path=        remove a file, or a directory with recursive
+//	POST   /mkdir              {"path"}
+//	POST   /cp                 {"src", "dst", "recursive"}
+//	POST   /mv                 {"src", "dst"}
+//	POST   /chmod              {"path", "mode"}
+//	POST   /migrate            {"src", "dst"}, the cloud providers
+func (s *Server) API() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(constant.ApiVer+"/login", s.apiLogin)
+	mux.HandleFunc(constant.ApiVer+"/logout", s.apiLogout)
+
+	routes := []struct {
+		pattern string
+		methods map[string]apiHandler
+	}{
+		{"/list", map[string]apiHandler{http.MethodGet: s.apiList}},
+		{"/stat", map[string]apiHandler{http.MethodGet: s.apiStat}},
+		{"/files", map[string]apiHandler{http.MethodGet: s.apiRead, http.MethodPut: s.apiWrite, http.MethodDelete: s.apiRemove}},
+		{"/mkdir", map[string]apiHandler{http.MethodPost: s.apiMkdir}},
+		{"/cp", map[string]apiHandler{http.MethodPost: s.apiCopy}},
+		{"/mv", map[string]apiHandler{http.MethodPost: s.apiMove}},
+		{"/chmod", map[string]apiHandler{http.MethodPost: s.apiChmod}},
+		{"/migrate", map[string]apiHandler{http.MethodPost: s.apiMigrate}},
+	}
+	for _, route := range routes {
+		command := strings.TrimPrefix(route.pattern, "/")
+		mux.Handle(constant.ApiVer+route.pattern, s.apiRoute(command, route.methods))
+	}
+	return mux
+}
+
+// apiRoute authenticates the requests of command and dispatches them by
+// method.
+func (s *Server) apiRoute(command string, methods map[string]apiHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := methods[r.Method]
+		if !ok {
+			allowed := make([]string, 0, len(methods))
+			for method := range methods {
+				allowed = append(allowed, method)
+			}
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, command, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+
+		sess, err := s.apiSession(r)
+		if err != nil {
+			s.writeLoginError(w, command, err)
+			return
+		}
+
+		params, err := parseParams(r)
+		if err != nil {
+			writeAPIError(w, command, statusOf(err), err)
+			return
+		}
+
+		if err := handler(w, r, sess, params); err != nil {
+			writeAPIError(w, command, statusOf(err), err)
+		}
+	})
+}
+
+// apiSession returns the session of the user of the request, given by a
+// token or basic authentication.
+func (s *Server) apiSession(r *http.Request) (*session.Session, error) {
+	if token, ok := bearerToken(r); ok {
+		sess, ok := s.tokenSession(token)
+		if !ok {
+			return nil, fmt.Errorf("invalid or expired token: %w", constant.ErrInvalidCredentials)
+		}
+		return sess, nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, constant.ErrInvalidCredentials
+	}
+	return s.login(username, password)
+}
+
+// bearerToken returns the token of the Authorization header of r.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len("Bearer "):]), true
+}
+
+// parseParams returns the parameters of r.
+func parseParams(r *http.Request) (apiParams, error) {
+	query := r.URL.Query()
+	params := apiParams{
+		Path: query.Get("path"),
+		Src:  query.Get("src"),
+		Dst:  query.Get("dst"),
+		Mode: query.Get("mode"),
+	}
+	if recursive := query.Get("recursive"); recursive != "" {
+		var err error
+		params.Recursive, err = strconv.ParseBool(recursive)
+		if err != nil {
+			return params, paramErrorf("recursive: invalid boolean: %s", recursive)
+		}
+	}
+
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil && err != io.EOF {
+			return params, &paramError{err}
+		}
+	}
+	return params, nil
+}
+
+func (s *Server) apiLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, "login", http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	var creds user.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		writeAPIError(w, "login", http.StatusBadRequest, err)
+		return
+	}
+
+	sess, err := s.issueToken(creds.Username, creds.Password)
+	if err != nil {
+		s.writeLoginError(w, "login", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiLogin{Token: sess.Token(), Username: sess.User.Username, Role: sess.Role()})
+}
+
+// writeLoginError responds with the failure of a login: 401 for invalid
+// credentials, 502 when the intermediate service could not check them.
+func (s *Server) writeLoginError(w http.ResponseWriter, command string, err error) {
+	if errors.Is(err, constant.ErrInvalidCredentials) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vfs"`)
+		writeAPIError(w, command, http.StatusUnauthorized, err)
+		return
+	}
+	s.base.Logger.Println("ERROR: api: login:", err)
+	writeAPIError(w, command, http.StatusBadGateway, err)
+}
+
+func (s *Server) apiLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, "logout", http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	if token, ok := bearerToken(r); ok {
+		s.revokeToken(token)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiList(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	if params.Path == "" {
+		params.Path = "/"
+	}
+
+	var listing fsys.Listing
+	err := s.vol.Do(func() (err error) {
+		listing, err = s.root().List(sess, params.Path, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, listing)
+	return nil
+}
+
+func (s *Server) apiStat(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	entry, err := s.entry(sess, params.Path)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, entry)
+	return nil
+}
+
+// apiRead sends the content of a file, or of the ranges asked, with the
+// checksum of the file as ETag.
+func (s *Server) apiRead(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	entry, err := s.entry(sess, params.Path)
+	if err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		return paramErrorf("%s: is a directory", params.Path)
+	}
+
+	f, err := fsys.NewAferoFs(s.ctx, sess, s.root(), s.publishing).Open(params.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if entry.Checksum != "" {
+		w.Header().Set("ETag", `"`+entry.Checksum+`"`)
+	}
+	http.ServeContent(w, r, entry.Name, entry.ModTime, f)
+	return nil
+}
+
+// apiWrite replaces the content of a file, created when needed, with the
+// body, received and replicated in chunks.
+func (s *Server) apiWrite(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	if params.Path == "" {
+		return paramErrorf("path: missing")
+	}
+	entry, statErr := s.entry(sess, params.Path)
+	if statErr == nil && entry.IsDir() {
+		return paramErrorf("%s: is a directory", params.Path)
+	}
+
+	f, err := fsys.NewAferoFs(s.ctx, sess, s.root(), s.publishing).OpenFile(params.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	err = copyChunks(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusOK
+	if statErr != nil {
+		status = http.StatusCreated
+	}
+	return s.writeEntry(w, sess, status, params.Path)
+}
+
+func (s *Server) apiRemove(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	args := []string{"rm", params.Path}
+	if params.Recursive {
+		args = []string{"rm", "-r", params.Path}
+	} else if entry, err := s.entry(sess, params.Path); err == nil && entry.IsDir() {
+		return paramErrorf("%s: is a directory, remove it with recursive", params.Path)
+	}
+
+	if err := s.run(sess, args...); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) apiMkdir(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	if _, err := s.entry(sess, params.Path); err == nil {
+		return fmtPathError(params.Path, constant.ErrAlreadyExists)
+	}
+	if err := s.run(sess, "mkdir", params.Path); err != nil {
+		return err
+	}
+	return s.writeEntry(w, sess, http.StatusCreated, params.Path)
+}
+
+func (s *Server) apiCopy(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	args := []string{"cp", params.Src, params.Dst}
+	if params.Recursive {
+		args = []string{"cp", "-r", params.Src, params.Dst}
+	} else if entry, err := s.entry(sess, params.Src); err == nil && entry.IsDir() {
+		return paramErrorf("%s: is a directory, copy it with recursive", params.Src)
+	}
+	if err := s.run(sess, args...); err != nil {
+		return err
+	}
+	return s.writeEntry(w, sess, http.StatusCreated, params.Dst)
+}
+
+// apiMove moves a file or directory by copying it and removing the source,
+// each step checked and replicated like the shell commands.
+func (s *Server) apiMove(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	if params.Src == "" || params.Dst == "" {
+		return paramErrorf("src and dst: missing")
+	}
+	if err := fsys.NewAferoFs(s.ctx, sess, s.root(), s.publishing).Rename(params.Src, params.Dst); err != nil {
+		return err
+	}
+	return s.writeEntry(w, sess, http.StatusCreated, params.Dst)
+}
+
+func (s *Server) apiChmod(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	if _, err := strconv.ParseUint(params.Mode, 8, 32); err != nil {
+		return paramErrorf("mode: invalid octal mode: %s", params.Mode)
+	}
+	if err := s.run(sess, "chmod", params.Mode, params.Path); err != nil {
+		return err
+	}
+	return s.writeEntry(w, sess, http.StatusOK, params.Path)
+}
+
+func (s *Server) apiMigrate(w http.ResponseWriter, r *http.Request, sess *session.Session, params apiParams) error {
+	for _, provider := range []string{params.Src, params.Dst} {
+		if provider != "" && !supported(sess.Clients(), provider) {
+			return paramErrorf("migrate: %s: cloud storage is not supported or not found", provider)
+		}
+	}
+	if err := s.run(sess, "migrate", params.Src, params.Dst); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// run runs the Filesystem command args for the user of sess on the volume,
+// with the checks and replication of the shell. An unlocked command, which
+// waits for the intermediate service, runs outside the volume queue.
+func (s *Server) run(sess *session.Session, args ...string) error {
+	for _, arg := range args[1:] {
+		if arg == "" {
+			return paramErrorf("%s: missing operand", args[0])
+		}
+	}
+	// The errors of the checks before the command are about the request.
+	authorize := func() error {
+		if err := s.root().Authorize(sess, args[0], args[1:]...); err != nil {
+			return &paramError{err}
+		}
+		return nil
+	}
+
+	streams := fsys.Streams{
+		Stdin:  strings.NewReader(""),
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+		Format: fsys.FormatJSON,
+	}
+	if cmd, ok := fsys.LookupCommand(args[0]); ok && cmd.Unlocked {
+		if err := s.vol.Do(authorize); err != nil {
+			return err
+		}
+		_, err := s.root().ExecuteWith(s.ctx, fsys.UnlockedSession(sess), args, s.publishing, streams)
+		return err
+	}
+	return s.vol.Do(func() error {
+		if err := authorize(); err != nil {
+			return err
+		}
+		_, err := s.root().ExecuteWith(s.ctx, sess, args, s.publishing, streams)
+		return err
+	})
+}
+
+// entry describes the file or directory name as the user of sess sees it.
+func (s *Server) entry(sess *session.Session, name string) (fsys.Entry, error) {
+	if name == "" {
+		return fsys.Entry{}, paramErrorf("path: missing")
+	}
+
+	var entry fsys.Entry
+	err := s.vol.Do(func() (err error) {
+		entry, err = s.root().StatEntry(sess, name)
+		return err
+	})
+	return entry, err
+}
+
+// writeEntry responds with the entry of name.
+func (s *Server) writeEntry(w http.ResponseWriter, sess *session.Session, status int, name string) error {
+	entry, err := s.entry(sess, name)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, status, entry)
+	return nil
+}
+
+// supported reports whether provider is one of the cloud storage providers
+// of clients.
+func supported(clients []string, provider string) bool {
+	for _, client := range clients {
+		if client == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// copyChunks copies r to w in writes of writeChunk bytes.
+func copyChunks(w io.Writer, r io.Reader) error {
+	buf := make([]byte, writeChunk)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// paramError is an error about the parameters of a request.
+type paramError struct {
+	err error
+}
+
+func (e *paramError) Error() string { return e.err.Error() }
+func (e *paramError) Unwrap() error { return e.err }
+
+// paramErrorf formats a paramError.
+func paramErrorf(format string, a ...interface{}) error {
+	return &paramError{fmt.Errorf(format, a...)}
+}
+
+// fmtPathError prefixes err with name, keeping it for statusOf.
+func fmtPathError(name string, err error) error {
+	return &os.PathError{Op: "stat", Path: name, Err: err}
+}
+
+// statusOf returns the HTTP status reporting err.
+func statusOf(err error) int {
+	var urlErr *url.Error
+	var paramErr *paramError
+	switch {
+	case errors.Is(err, constant.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, constant.ErrUnauthorizedAccess), errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, constant.ErrPathNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, constant.ErrAlreadyExists), errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, constant.ErrChecksumMismatch), errors.Is(err, constant.ErrRemoteData), errors.Is(err, constant.ErrRemoteFailed):
+		return http.StatusBadGateway
+	case errors.As(err, &urlErr):
+		// The intermediate service could not be reached.
+		return http.StatusBadGateway
+	case errors.Is(err, constant.ErrVolumeClosed):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &paramErr):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON responds with status and v as JSON. The response is started,
+// so a failure to send it can only be seen by the client.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError responds with status and err as JSON.
+func writeAPIError(w http.ResponseWriter, command string, status int, err error) {
+	writeJSON(w, status, apiError{Command: command, Error: err.Error()})
+}
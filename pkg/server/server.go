@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/session"
+	"github.com/marcellof23/vfs-TA/pkg/user"
+)
+
+const (
+	// sessionTTL is how long a login is reused before the credentials are
+	// checked again by the intermediate service.
+	sessionTTL = 10 * time.Minute
+	// tokenIdle is how long the token of a login stays valid unused.
+	tokenIdle = time.Hour
+)
+
+// Server serves the virtual Filesystem of a client over HTTP to the users of
+// the intermediate service. Each request runs with the session of the user
+// it authenticates, so the permissions are checked for that user, while the
+// writes are replicated as those of the client.
+type Server struct {
+	ctx        context.Context
+	base       *session.Session // The session of the client.
+	vol        *fsys.Volume
+	publishing model.Publishing
+	locks      webdav.LockSystem
+
+	mu       sync.Mutex
+	sessions map[string]cachedSession // By hash of the credentials.
+	tokens   map[string]cachedSession // By token given to the API login.
+}
+
+// cachedSession is the session of a user logged in by a request.
+type cachedSession struct {
+	sess    *session.Session
+	expires time.Time
+}
+
+// New returns a Server of the Filesystem of vol. The requests run with ctx,
+// which must last as long as the server, and the services of base.
+func New(ctx context.Context, base *session.Session, vol *fsys.Volume, publishing model.Publishing) *Server {
+	return &Server{
+		ctx:        ctx,
+		base:       base,
+		vol:        vol,
+		publishing: publishing,
+		locks:      webdav.NewMemLS(),
+		sessions:   map[string]cachedSession{},
+		tokens:     map[string]cachedSession{},
+	}
+}
+
+// root returns the root Filesystem of the volume, looked up by each request
+// since a reload of the volume replaces it.
+func (s *Server) root() *fsys.Filesystem {
+	return s.vol.Root()
+}
+
+// login returns the session of the user logging in with username and
+// password, reusing the last login with the same credentials for
+// sessionTTL.
+func (s *Server) login(username, password string) (*session.Session, error) {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	cached, ok := s.sessions[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.sess, nil
+	}
+
+	currentUser, err := user.Login(s.base.Dep, username, password)
+	if err != nil {
+		return nil, err
+	}
+	sess := s.userSession(user.ToModelUserState(currentUser))
+
+	s.mu.Lock()
+	removeExpired(s.sessions)
+	s.sessions[key] = cachedSession{sess: sess, expires: time.Now().Add(sessionTTL)}
+	s.mu.Unlock()
+	return sess, nil
+}
+
+// issueToken logs in with username and password and returns the session,
+// which the token of the user then gives until unused for tokenIdle.
+func (s *Server) issueToken(username, password string) (*session.Session, error) {
+	currentUser, err := user.Login(s.base.Dep, username, password)
+	if err != nil {
+		return nil, err
+	}
+	sess := s.userSession(user.ToModelUserState(currentUser))
+
+	s.mu.Lock()
+	removeExpired(s.tokens)
+	s.tokens[sess.Token()] = cachedSession{sess: sess, expires: time.Now().Add(tokenIdle)}
+	s.mu.Unlock()
+	return sess, nil
+}
+
+// tokenSession returns the session given by token, extending its validity.
+func (s *Server) tokenSession(token string) (*session.Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.tokens[token]
+	if !ok || time.Now().After(cached.expires) {
+		delete(s.tokens, token)
+		return nil, false
+	}
+	cached.expires = time.Now().Add(tokenIdle)
+	s.tokens[token] = cached
+	return cached.sess, true
+}
+
+// revokeToken forgets token.
+func (s *Server) revokeToken(token string) {
+	s.mu.Lock()
+	delete(s.tokens, token)
+	s.mu.Unlock()
+}
+
+// removeExpired removes the expired sessions of cache.
+func removeExpired(cache map[string]cachedSession) {
+	now := time.Now()
+	for key, cached := range cache {
+		if now.After(cached.expires) {
+			delete(cache, key)
+		}
+	}
+}
+
+// userSession returns a session of state using the services of the client.
+// The ID of the client is kept, so that it skips the replicated operations
+// of its users.
+func (s *Server) userSession(state model.UserState) *session.Session {
+	state.ClientID = s.base.ClientID()
+	return session.New(s.base.Dep, state, s.base.Publisher, s.base.Producer, s.base.Logger)
+}
+
+// unauthorized asks the client for credentials.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="vfs", charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/marcellof23/vfs-TA/constant"
+	"github.com/marcellof23/vfs-TA/pkg/fsys"
+)
+
+// WebDAV returns a handler serving the Filesystem over WebDAV, the users
+// logging in with basic authentication. The locks are shared by all the
+// users.
+func (s *Server) WebDAV() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		sess, err := s.login(username, password)
+		if errors.Is(err, constant.ErrInvalidCredentials) {
+			unauthorized(w)
+			return
+		}
+		if err != nil {
+			s.base.Logger.Println("ERROR: webdav: login:", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+
+		h := &webdav.Handler{
+			FileSystem: fsys.NewDavFs(s.ctx, sess, s.root(), s.publishing),
+			LockSystem: s.locks,
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					s.base.Logger.Printf("ERROR: webdav: %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"log"
+
+	"github.com/marcellof23/vfs-TA/boot"
+	"github.com/marcellof23/vfs-TA/pkg/model"
+	"github.com/marcellof23/vfs-TA/pkg/producer"
+	"github.com/marcellof23/vfs-TA/pkg/progress"
+	"github.com/marcellof23/vfs-TA/pkg/pubsub_notify/publisher"
+)
+
+// Session carries the logged in user and the services a Filesystem
+// operation needs. It is passed explicitly to every operation while the
+// context is only used for cancellation and deadlines.
+type Session struct {
+	User      model.UserState
+	Dep       *boot.Dependencies
+	Publisher *publisher.Publisher
+	Producer  *producer.Producer
+	Logger    *log.Logger
+
+	// Progress reports the transfers of the operations, nil to report
+	// nothing.
+	Progress *progress.Reporter
+
+	// Defer, when set, receives the slow transfers of an operation so they
+	// run once the operation has released the volume. Background jobs set
+	// it on their own copy of the session.
+	Defer func(fn func() error)
+
+	// Unlocked marks a command running outside the volume queue, which then
+	// takes the volume for each of its operations. The shell sets it on its
+	// own copy of the session.
+	Unlocked bool
+}
+
+// New creates a Session for the given user.
+func New(dep *boot.Dependencies, user model.UserState, pubs *publisher.Publisher, prod *producer.Producer, logger *log.Logger) *Session {
+	return &Session{
+		User:      user,
+		Dep:       dep,
+		Publisher: pubs,
+		Producer:  prod,
+		Logger:    logger,
+	}
+}
+
+// Config returns the configuration of the session.
+func (s *Session) Config() boot.Config {
+	return s.Dep.Config()
+}
+
+// Role returns the role of the session user.
+func (s *Session) Role() string {
+	return s.User.Role
+}
+
+// Token returns the token of the session user.
+func (s *Session) Token() string {
+	return s.User.Token
+}
+
+// ClientID returns the ID of this client, used to skip our own replicated commands.
+func (s *Session) ClientID() string {
+	return s.User.ClientID
+}
+
+// Host returns the address of the intermediate service.
+func (s *Session) Host() string {
+	return s.Config().Server.Addr
+}
+
+// Clients returns the supported cloud storage providers.
+func (s *Session) Clients() []string {
+	return s.Config().Clients
+}
+
+// MaxFileSize returns the largest file size accepted, in bytes.
+func (s *Session) MaxFileSize() int64 {
+	return s.Config().MaxFileSize
+}
+
+// Wait blocks until the messages sent by the session operations are
+// acknowledged by Pub/Sub and Kafka.
+func (s *Session) Wait() error {
+	var pubErr error
+	if s.Publisher != nil {
+		pubErr = s.Publisher.Wait()
+	}
+
+	var prodErr error
+	if s.Producer != nil {
+		prodErr = s.Producer.Wait()
+	}
+
+	if pubErr != nil {
+		return pubErr
+	}
+	return prodErr
+}
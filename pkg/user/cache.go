@@ -0,0 +1,72 @@
+package user
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/marcellof23/vfs-TA/pkg/model"
+)
+
+// tokenCachePath returns the file caching the logged in User, under
+// $XDG_CACHE_HOME/vfs.
+func tokenCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vfs", "token.json"), nil
+}
+
+// SaveToken caches the logged in User so later commands can skip the login.
+func SaveToken(currentUser *User) error {
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ToModelUserState(currentUser))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// CachedUser returns the User cached by SaveToken.
+func CachedUser() (*User, error) {
+	path, err := tokenCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state model.UserState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	return initiateUser(state), nil
+}
+
+// RemoveToken removes the cached User.
+func RemoveToken() error {
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
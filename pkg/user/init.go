@@ -3,6 +3,7 @@ package user
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -111,15 +112,53 @@ func register(dep *boot.Dependencies) model.UserState {
 	return userState
 }
 
+// authenticate logs in with creds on the server and returns the state of
+// the logged in User.
+func authenticate(dep *boot.Dependencies, creds Credentials) (model.UserState, error) {
+	jsonValue, _ := json.Marshal(creds)
+
+	loginURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/user/login"
+	resp, err := http.Post(
+		loginURL,
+		"application/json",
+		bytes.NewBuffer(jsonValue))
+	if err != nil {
+		return model.UserState{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return model.UserState{}, constant.ErrInvalidCredentials
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return model.UserState{}, err
+	}
+
+	post := UserResp{}
+	err = json.Unmarshal(body, &post)
+	if err != nil {
+		return model.UserState{}, fmt.Errorf("reading body failed: %w", err)
+	}
+
+	return model.UserState{
+		Username: post.Data.Username,
+		Role:     post.Data.Role,
+		Token:    post.Token,
+		ClientID: post.Data.ClientID,
+		UserID:   post.Data.UserID,
+		GroupID:  post.Data.GroupID,
+	}, nil
+}
+
 // login gets a custom Username from the current User.
 func login(dep *boot.Dependencies) model.UserState {
-	var userState model.UserState
 	line, err := readline.New(">")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var resp *http.Response
 	for {
 		fmt.Println("Please enter a Username:")
 		uname, err := line.Readline()
@@ -132,40 +171,27 @@ func login(dep *boot.Dependencies) model.UserState {
 		if err != nil {
 			log.Fatal(err)
 		}
-		values := Credentials{Username: uname, Password: string(pass)}
-		jsonValue, _ := json.Marshal(values)
 
-		loginURL := constant.Protocol + dep.Config().Server.Addr + constant.ApiVer + "/user/login"
-		resp, err = http.Post(
-			loginURL,
-			"application/json",
-			bytes.NewBuffer(jsonValue))
-		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Println("Username or password is invalid\n")
-			continue
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		post := UserResp{}
-
-		err = json.Unmarshal(body, &post)
+		userState, err := authenticate(dep, Credentials{Username: uname, Password: string(pass)})
 		if err != nil {
-			log.Printf("Reading body failed: %s", err)
+			if errors.Is(err, constant.ErrInvalidCredentials) {
+				fmt.Print("Username or password is invalid\n\n")
+			} else {
+				log.Println(err)
+			}
 			continue
 		}
+		return userState
+	}
+}
 
-		userState = model.UserState{
-			Username: post.Data.Username,
-			Role:     post.Data.Role,
-			Token:    post.Token,
-			ClientID: post.Data.ClientID,
-			UserID:   post.Data.UserID,
-			GroupID:  post.Data.GroupID,
-		}
-		break
+// Login logs in without prompting, for scripts and one-shot commands.
+func Login(dep *boot.Dependencies, username, password string) (*User, error) {
+	userState, err := authenticate(dep, Credentials{Username: username, Password: password})
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	return userState
+	return initiateUser(userState), nil
 }
 
 // initUser initializes the User object on startup.
@@ -3,11 +3,13 @@ package user
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/chzyer/readline"
 
 	"github.com/marcellof23/vfs-TA/constant"
 	"github.com/marcellof23/vfs-TA/pkg/fsys"
+	"github.com/marcellof23/vfs-TA/pkg/history"
 	"github.com/marcellof23/vfs-TA/pkg/model"
 )
 
@@ -44,52 +46,53 @@ func ToModelUserState(user *User) model.UserState {
 	}
 }
 
-// updateUsername updates the name of the current User.
-func (currentUser *User) SetPrompt(prompt *readline.Instance, fs *fsys.Filesystem) {
-	var rootPath string
-	if fs.GetRootPath() == "." {
-		rootPath = "/"
-	} else if fs.GetRootPath()[0] == '.' && fs.GetRootPath()[1] == '/' {
-		rootPath = "/" + fs.GetRootPath()[2:]
-	} else {
-		rootPath = "/" + fs.GetRootPath()
+// updateUsername updates the name of the current User. The PS1 variable of
+// the shell overrides the prompt, with \u replaced by the username and \w by
+// the working directory.
+func (currentUser *User) SetPrompt(prompt *readline.Instance, s *fsys.Shell) {
+	if ps1, ok := s.Var("PS1"); ok {
+		prompt.SetPrompt(strings.NewReplacer(`\u`, currentUser.Username, `\w`, s.Cwd(), `\$`, "$").Replace(ps1))
+		return
 	}
 
 	coloredUsername := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorHiGreen, currentUser.Username)
-	coloredRootPath := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorHiBlue, rootPath)
+	coloredRootPath := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorHiBlue, s.Cwd())
 	prompt.SetPrompt(coloredUsername + ":" + coloredRootPath + "$> ")
 }
 
-// initPrompt initializes the input buffer for the
-// shell.
-func (currentUser *User) InitPrompt() *readline.Instance {
-	autoCompleter := readline.NewPrefixCompleter(
-		readline.PcItem("mkdir"),
-		readline.PcItem("pwd"),
-		readline.PcItem("ls"),
-		readline.PcItem("cat"),
-		readline.PcItem("stat"),
-		readline.PcItem("touch"),
-		readline.PcItem("rm"),
-		readline.PcItem("cp"),
-		readline.PcItem("chmod"),
-		readline.PcItem("migrate"),
-		readline.PcItem("download"),
-		readline.PcItem("upload"),
-		readline.PcItem("exit"),
-	)
+// LoadHistory loads the command history of the User.
+func (currentUser *User) LoadHistory() (*history.History, error) {
+	path, err := history.Path(currentUser.Username)
+	if err != nil {
+		return nil, err
+	}
+	return history.Load(path, history.DefaultLimit)
+}
 
+// initPrompt initializes the input buffer for the
+// shell. The lines of hist are available with the arrows and the reverse
+// search; the shell records new lines itself. completer completes the line
+// on tab.
+func (currentUser *User) InitPrompt(hist *history.History, completer readline.AutoCompleter) *readline.Instance {
 	coloredUsername := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorHiGreen, currentUser.Username)
 	coloredRootPath := fmt.Sprintf("\x1b[%dm%s\x1b[0m", constant.ColorHiBlue, "/")
 	prompt, err := readline.NewEx(&readline.Config{
-		Prompt:          coloredUsername + ":" + coloredRootPath + "$>",
-		HistoryFile:     "/tmp/commands.tmp",
-		AutoComplete:    autoCompleter,
-		InterruptPrompt: "^C",
-		EOFPrompt:       "exit",
+		Prompt:                 coloredUsername + ":" + coloredRootPath + "$>",
+		HistoryLimit:           history.DefaultLimit,
+		DisableAutoSaveHistory: true,
+		HistorySearchFold:      true,
+		AutoComplete:           completer,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "exit",
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if hist != nil {
+		for _, line := range hist.Entries() {
+			prompt.SaveHistory(line)
+		}
+	}
 	return prompt
 }